@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+	"gopkg.in/yaml.v3"
+)
+
+// queuesFile is the declarative queue definition file of laqueue apply
+type queuesFile struct {
+	Queues map[string]queueDefinition `yaml:"queues"`
+}
+
+// queueDefinition declares the stored settings of a queue
+type queueDefinition struct {
+	MaxRetries      int           `yaml:"max_retries"`
+	Retention       time.Duration `yaml:"retention"`
+	ResultRetention time.Duration `yaml:"result_retention"`
+	DefaultDelay    time.Duration `yaml:"default_delay"`
+	DeadLetterQueue string        `yaml:"dead_letter_queue"`
+	DiscardFailed   bool          `yaml:"discard_failed"`
+}
+
+// loadQueuesFile reads a queue definition file, rejecting unknown settings
+// so that typos don't silently leave a queue unconfigured
+func loadQueuesFile(path string) (*queuesFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var file queuesFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(file.Queues) == 0 {
+		return nil, fmt.Errorf("%s defines no queues", path)
+	}
+	return &file, nil
+}
+
+// applyQueues stores the settings of every queue of the file. Queues not in
+// the file keep their settings.
+func applyQueues(db *sql.DB, file *queuesFile) error {
+	names := make([]string, 0, len(file.Queues))
+	for name := range file.Queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		def := file.Queues[name]
+		q := queue.New(db, name)
+		err := q.SetConfig(queue.QueueConfig{
+			MaxRetries:      def.MaxRetries,
+			Retention:       def.Retention,
+			ResultRetention: def.ResultRetention,
+			DefaultDelay:    def.DefaultDelay,
+			DeadLetterQueue: def.DeadLetterQueue,
+			DiscardFailed:   def.DiscardFailed,
+		})
+		q.Close()
+		if err != nil {
+			return fmt.Errorf("queue %s: %w", name, err)
+		}
+		fmt.Printf("Configured queue '%s'\n", name)
+	}
+	return nil
+}
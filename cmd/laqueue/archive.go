@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"io"
+	"os"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// exportArchive writes the items of a queue, or of all queues if queueName
+// is empty, to an archive file, or to standard output if path is empty
+func exportArchive(db *sql.DB, queueName, path string) (int, error) {
+	var (
+		out  io.Writer = os.Stdout
+		file *os.File
+	)
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		out, file = f, f
+	}
+	buf := bufio.NewWriter(out)
+
+	archive, err := queue.NewArchiveWriter(buf)
+	if err != nil {
+		return 0, err
+	}
+	n, err := queue.Export(db, queueName, archive)
+	if err != nil {
+		return n, err
+	}
+	if err := archive.Close(); err != nil {
+		return n, err
+	}
+	if err := buf.Flush(); err != nil {
+		return n, err
+	}
+	// Pipes and terminals can't be synced
+	if file != nil {
+		return n, file.Sync()
+	}
+	return n, nil
+}
+
+// importArchive inserts the items of an archive file, or of standard input
+// if path is empty
+func importArchive(db *sql.DB, path string, onConflict queue.OnConflict) (int, error) {
+	var in io.Reader = os.Stdin
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	archive, err := queue.NewArchiveReader(bufio.NewReader(in))
+	if err != nil {
+		return 0, err
+	}
+	return queue.ImportWithOptions(db, archive, queue.ImportOptions{OnConflict: onConflict})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// batchJob is one line of a laqueue enqueue -ndjson file, e.g.
+// {"queue": "emails", "payload": {"to": "a@example.com"}, "delay": "5m"}
+type batchJob struct {
+	Queue    string            `json:"queue"`
+	Payload  json.RawMessage   `json:"payload"`
+	Delay    string            `json:"delay"`
+	Priority int               `json:"priority"`
+	LockKey  string            `json:"lock_key"`
+	GroupKey string            `json:"group_key"`
+	Metadata map[string]string `json:"metadata"`
+	Requires map[string]string `json:"requires"`
+}
+
+// batchEntry is a validated line of a batch file
+type batchEntry struct {
+	line  int
+	queue string
+	job   batchJob
+	opts  queue.EnqueueOptions
+}
+
+// batchProblem reports why a line of a batch file can't be enqueued
+type batchProblem struct {
+	line int
+	err  string
+}
+
+// readBatch reads and validates every line of a batch file. Lines without a
+// queue go to defaultQueue, and payloads larger than maxSize bytes are
+// rejected unless maxSize is 0. Identical jobs are reported as conflicts, as
+// they are almost always submitted twice by mistake. Every problem is
+// returned, not only the first one, so a file can be fixed in one go.
+func readBatch(r io.Reader, defaultQueue string, maxSize int) ([]batchEntry, []batchProblem, error) {
+	var (
+		entries  []batchEntry
+		problems []batchProblem
+		seen     = make(map[string]int)
+	)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		problem := func(format string, args ...any) {
+			problems = append(problems, batchProblem{line: line, err: fmt.Sprintf(format, args...)})
+		}
+
+		var job batchJob
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&job); err != nil {
+			problem("invalid JSON: %v", err)
+			continue
+		}
+		if len(job.Payload) == 0 {
+			problem("payload is required")
+			continue
+		}
+
+		var compact bytes.Buffer
+		json.Compact(&compact, job.Payload)
+		if maxSize > 0 && compact.Len() > maxSize {
+			problem("payload of %d bytes exceeds the limit of %d", compact.Len(), maxSize)
+			continue
+		}
+		// The scanner reuses its buffer, so keep the compacted copy
+		job.Payload = compact.Bytes()
+		if job.Queue == "" {
+			job.Queue = defaultQueue
+		}
+
+		entry := batchEntry{line: line, queue: job.Queue, job: job}
+		entry.opts = queue.EnqueueOptions{
+			Priority: job.Priority,
+			LockKey:  job.LockKey,
+			GroupKey: job.GroupKey,
+			Metadata: job.Metadata,
+			Requires: job.Requires,
+		}
+		if job.Delay != "" {
+			delay, err := time.ParseDuration(job.Delay)
+			if err != nil || delay < 0 {
+				problem("invalid delay %q", job.Delay)
+				continue
+			}
+			entry.opts.Delay = delay
+		}
+
+		// Compare jobs by their canonical encoding so that formatting doesn't
+		// hide a duplicate
+		key, _ := json.Marshal(job)
+		if first, ok := seen[string(key)]; ok {
+			problem("duplicates line %d", first)
+			continue
+		}
+		seen[string(key)] = line
+
+		entries = append(entries, entry)
+	}
+	return entries, problems, scanner.Err()
+}
+
+// printBatchPreview prints the problems of a batch file and what would be
+// enqueued into each queue
+func printBatchPreview(entries []batchEntry, problems []batchProblem) {
+	for _, p := range problems {
+		fmt.Printf("Line %d: %s\n", p.line, p.err)
+	}
+
+	counts := make(map[string]int)
+	delayed := make(map[string]int)
+	for _, entry := range entries {
+		counts[entry.queue]++
+		if entry.opts.Delay > 0 {
+			delayed[entry.queue]++
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("Would enqueue %d items to queue '%s' (%d delayed)\n", counts[name], name, delayed[name])
+	}
+	fmt.Printf("%d valid, %d invalid\n", len(entries), len(problems))
+}
+
+// enqueueBatch enqueues validated entries in file order and returns the
+// number of items enqueued
+func enqueueBatch(queues func(name string) *queue.LaQueue, entries []batchEntry) (int, error) {
+	for i, entry := range entries {
+		if _, err := queues(entry.queue).EnqueueWithOptions(entry.job.Payload, entry.opts); err != nil {
+			return i, fmt.Errorf("line %d: %w", entry.line, err)
+		}
+	}
+	return len(entries), nil
+}
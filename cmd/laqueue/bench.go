@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// benchResult holds the measurements of a bench run
+type benchResult struct {
+	Items        int
+	EnqueueTime  time.Duration
+	TotalTime    time.Duration
+	EnqueueRate  float64
+	ProcessRate  float64
+	EnqueueError int64
+	DequeueError int64
+	ProcessError int64
+}
+
+// runBench measures enqueue and processing throughput against a scratch
+// database created in dir, so the numbers reflect the disk the queue will
+// actually live on.
+func runBench(dir string, producers, workers, n int) (*benchResult, error) {
+	if producers < 1 || workers < 1 || n < 1 {
+		return nil, fmt.Errorf("producers, workers and n must be positive")
+	}
+
+	f, err := os.CreateTemp(dir, "laqueue_bench_*.db")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bench database: %w", err)
+	}
+	f.Close()
+	dbPath := f.Name()
+	defer os.Remove(dbPath)
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bench database: %w", err)
+	}
+	defer db.Close()
+
+	if err := initDatabase(db); err != nil {
+		return nil, fmt.Errorf("failed to initialize bench database: %w", err)
+	}
+
+	q := queue.New(db, "bench")
+	defer q.Close()
+
+	var (
+		enqueued  atomic.Int64
+		processed atomic.Int64
+		enqErrs   atomic.Int64
+		deqErrs   atomic.Int64
+		procErrs  atomic.Int64
+		prodWg    sync.WaitGroup
+		workWg    sync.WaitGroup
+	)
+
+	start := time.Now()
+
+	// Producers share the n items between them
+	for p := 0; p < producers; p++ {
+		prodWg.Add(1)
+		go func(p int) {
+			defer prodWg.Done()
+			for {
+				i := enqueued.Add(1)
+				if i > int64(n) {
+					return
+				}
+				if _, err := q.Enqueue(map[string]int64{"producer": int64(p), "seq": i}); err != nil {
+					enqErrs.Add(1)
+				}
+			}
+		}(p)
+	}
+
+	// Workers keep claiming until every successfully enqueued item is processed.
+	// A failed claim leaves the item pending, so it is retried rather than
+	// counted as processed.
+	for w := 0; w < workers; w++ {
+		workWg.Add(1)
+		go func() {
+			defer workWg.Done()
+			for processed.Load()+procErrs.Load() < int64(n)-enqErrs.Load() {
+				item, err := q.Dequeue()
+				if err != nil {
+					deqErrs.Add(1)
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				if item == nil {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				if err := q.Complete(item.ID); err != nil {
+					procErrs.Add(1)
+					continue
+				}
+				processed.Add(1)
+			}
+		}()
+	}
+
+	prodWg.Wait()
+	enqueueTime := time.Since(start)
+	workWg.Wait()
+	totalTime := time.Since(start)
+
+	return &benchResult{
+		Items:        n,
+		EnqueueTime:  enqueueTime,
+		TotalTime:    totalTime,
+		EnqueueRate:  float64(n) / enqueueTime.Seconds(),
+		ProcessRate:  float64(processed.Load()) / totalTime.Seconds(),
+		EnqueueError: enqErrs.Load(),
+		DequeueError: deqErrs.Load(),
+		ProcessError: procErrs.Load(),
+	}, nil
+}
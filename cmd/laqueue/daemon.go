@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nicotsx/laqueue/producer"
+	"github.com/nicotsx/laqueue/worker"
+	"gopkg.in/yaml.v3"
+)
+
+// daemonConfig is the configuration file of laqueue daemon
+type daemonConfig struct {
+	// DB overrides the -db flag when set
+	DB      string               `yaml:"db"`
+	Workers []daemonWorkerConfig `yaml:"workers"`
+
+	// DebugAddr, if set, is the address serving worker.DebugHandler, e.g.
+	// localhost:6061
+	DebugAddr string `yaml:"debug_addr"`
+
+	// HTTPAddr, if set, is the address serving producer.Handler, so that
+	// other processes enqueue items with producer.NewHTTP, e.g. :8080.
+	// HTTPBatchWindow and HTTPMaxBatch tune it, see producer.HandlerOptions.
+	HTTPAddr        string        `yaml:"http_addr"`
+	HTTPBatchWindow time.Duration `yaml:"http_batch_window"`
+	HTTPMaxBatch    int           `yaml:"http_max_batch"`
+}
+
+// daemonWorkerConfig defines an exec-based worker
+type daemonWorkerConfig struct {
+	Queue string `yaml:"queue"`
+
+	// QueuePattern subscribes the worker to every queue matching it instead
+	// of Queue, e.g. emails.*, see worker.Config
+	QueuePattern string `yaml:"queue_pattern"`
+
+	Command         []string      `yaml:"command"`
+	Interval        time.Duration `yaml:"interval"`
+	MinConcurrency  int           `yaml:"min_concurrency"`
+	MaxConcurrency  int           `yaml:"max_concurrency"`
+	MaxRetries      int           `yaml:"max_retries"`
+	Retention       time.Duration `yaml:"retention"`
+	ResultRetention time.Duration `yaml:"result_retention"`
+	RetryRate       int           `yaml:"retry_rate"`
+
+	// SnapshotInterval freezes a snapshot of the item counts this often,
+	// e.g. 24h for end-of-day reconciliation
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+
+	// ExpectedDuration and SlowFactor tune the detection of slow items, see
+	// worker.Config
+	ExpectedDuration time.Duration `yaml:"expected_duration"`
+	SlowFactor       float64       `yaml:"slow_factor"`
+
+	// Shard and Shards split the queue between daemon hosts, see
+	// worker.Config
+	Shard  int `yaml:"shard"`
+	Shards int `yaml:"shards"`
+
+	// ContentTypes lists the payload types the command supports, see
+	// worker.Config
+	ContentTypes []string `yaml:"content_types"`
+
+	// Labels describe the daemon host, so that it claims the items pinned
+	// to it, e.g. host: gpu-1, see worker.Config
+	Labels map[string]string `yaml:"labels"`
+
+	// BusyRetry is how long writes are retried while another process holds
+	// the database
+	BusyRetry time.Duration `yaml:"busy_retry"`
+
+	// Timeout, KillGrace, Dir and Env supervise the command, see execOptions
+	Timeout   time.Duration     `yaml:"timeout"`
+	KillGrace time.Duration     `yaml:"kill_grace"`
+	Dir       string            `yaml:"dir"`
+	Env       map[string]string `yaml:"env"`
+}
+
+// loadDaemonConfig reads and validates a daemon configuration file
+func loadDaemonConfig(path string) (*daemonConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config daemonConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	if len(config.Workers) == 0 && config.HTTPAddr == "" {
+		return nil, fmt.Errorf("%s defines no workers and no http_addr", path)
+	}
+	for i, w := range config.Workers {
+		if w.Queue == "" && w.QueuePattern == "" {
+			return nil, fmt.Errorf("worker %d: queue or queue_pattern is required", i+1)
+		}
+		if w.Queue != "" && w.QueuePattern != "" {
+			return nil, fmt.Errorf("worker %d: queue and queue_pattern are exclusive", i+1)
+		}
+		name := w.Queue + w.QueuePattern
+		if len(w.Command) == 0 {
+			return nil, fmt.Errorf("worker %d (%s): command is required", i+1, name)
+		}
+		if w.Shards > 1 && (w.Shard < 0 || w.Shard >= w.Shards) {
+			return nil, fmt.Errorf("worker %d (%s): shard must be between 0 and %d", i+1, name, w.Shards-1)
+		}
+		if w.Dir != "" {
+			if info, err := os.Stat(w.Dir); err != nil || !info.IsDir() {
+				return nil, fmt.Errorf("worker %d (%s): dir %s is not a directory", i+1, name, w.Dir)
+			}
+		}
+	}
+
+	return &config, nil
+}
+
+// runDaemon starts the workers of the configuration and blocks until the
+// process is interrupted, then drains them
+func runDaemon(ctx context.Context, db *sql.DB, config *daemonConfig) {
+	workers := make([]*worker.Worker, 0, len(config.Workers))
+	for _, wc := range config.Workers {
+		workers = append(workers, worker.NewContext(db, worker.Config{
+			QueueName:       wc.Queue,
+			QueuePattern:    wc.QueuePattern,
+			Interval:        wc.Interval,
+			MinConcurrency:  wc.MinConcurrency,
+			MaxConcurrency:  wc.MaxConcurrency,
+			MaxRetries:      wc.MaxRetries,
+			Retention:       wc.Retention,
+			ResultRetention: wc.ResultRetention,
+			RetryRate:       wc.RetryRate,
+
+			SnapshotInterval: wc.SnapshotInterval,
+
+			ExpectedDuration: wc.ExpectedDuration,
+			SlowFactor:       wc.SlowFactor,
+
+			Shard:  wc.Shard,
+			Shards: wc.Shards,
+			Labels: wc.Labels,
+
+			ContentTypes: wc.ContentTypes,
+
+			BusyRetry: wc.BusyRetry,
+		}, execHandler(wc.Command, execOptions{
+			Timeout:   wc.Timeout,
+			KillGrace: wc.KillGrace,
+			Dir:       wc.Dir,
+			Env:       wc.Env,
+		})))
+	}
+
+	if config.HTTPAddr != "" {
+		server := &http.Server{
+			Addr: config.HTTPAddr,
+			Handler: producer.HandlerWithOptions(db, producer.HandlerOptions{
+				BatchWindow: config.HTTPBatchWindow,
+				MaxBatch:    config.HTTPMaxBatch,
+			}),
+		}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Error serving enqueue endpoint: %v", err)
+			}
+		}()
+		// Answer the enqueue requests in flight before exiting
+		defer server.Shutdown(context.Background())
+	}
+
+	if config.DebugAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(config.DebugAddr, worker.DebugHandler(workers...)); err != nil {
+				log.Printf("Error serving debug endpoint: %v", err)
+			}
+		}()
+	}
+
+	worker.Run(ctx, workers...)
+}
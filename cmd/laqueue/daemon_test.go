@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadDaemonConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+
+	config, err := loadDaemonConfig(write("full.yaml", `
+db: app.db
+http_addr: ":8080"
+http_batch_window: 5ms
+http_max_batch: 50
+debug_addr: localhost:6061
+workers:
+  - queue: emails
+    command: [./send-email]
+    max_concurrency: 4
+    timeout: 30s
+    kill_grace: 2s
+`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.DB != "app.db" || config.HTTPAddr != ":8080" || config.HTTPBatchWindow != 5*time.Millisecond ||
+		config.HTTPMaxBatch != 50 || config.DebugAddr != "localhost:6061" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+	if len(config.Workers) != 1 {
+		t.Fatalf("Expected 1 worker, got %d", len(config.Workers))
+	}
+	if w := config.Workers[0]; w.Queue != "emails" || strings.Join(w.Command, " ") != "./send-email" ||
+		w.MaxConcurrency != 4 || w.Timeout != 30*time.Second || w.KillGrace != 2*time.Second {
+		t.Errorf("Unexpected worker %+v", w)
+	}
+
+	// A daemon may only serve the enqueue endpoint
+	if config, err := loadDaemonConfig(write("http.yaml", `http_addr: ":8080"`)); err != nil || len(config.Workers) != 0 {
+		t.Errorf("Expected an enqueue-only config, got %+v, %v", config, err)
+	}
+
+	for name, content := range map[string]string{
+		"empty.yaml":     `debug_addr: localhost:6061`,
+		"queue.yaml":     "workers:\n  - command: [./run]",
+		"exclusive.yaml": "workers:\n  - queue: a\n    queue_pattern: a.*\n    command: [./run]",
+		"command.yaml":   "workers:\n  - queue: a",
+		"shard.yaml":     "workers:\n  - queue: a\n    command: [./run]\n    shards: 2\n    shard: 2",
+		"invalid.yaml":   "workers: [",
+	} {
+		if _, err := loadDaemonConfig(write(name, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
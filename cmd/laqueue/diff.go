@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// itemKey identifies an item across snapshots
+type itemKey struct {
+	queueName string
+	id        int64
+}
+
+// snapshotDiff holds the items of each category found when comparing two snapshots
+type snapshotDiff struct {
+	Added        []itemKey
+	Completed    []itemKey
+	Failed       []itemKey
+	StillPending []itemKey
+	Removed      []itemKey
+}
+
+// loadStatuses reads the status of every item of a database file, optionally
+// restricted to one queue. The file is opened read-only.
+func loadStatuses(path, queueName string) (map[itemKey]string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT id, queue_name, status FROM queue_items
+		WHERE ? = '' OR queue_name = ?
+	`, queueName, queueName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[itemKey]string)
+	for rows.Next() {
+		var (
+			key    itemKey
+			status string
+		)
+		if err := rows.Scan(&key.id, &key.queueName, &status); err != nil {
+			return nil, err
+		}
+		statuses[key] = status
+	}
+	return statuses, rows.Err()
+}
+
+// diffSnapshots compares the items of two database files
+func diffSnapshots(beforePath, afterPath, queueName string) (*snapshotDiff, error) {
+	before, err := loadStatuses(beforePath, queueName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", beforePath, err)
+	}
+	after, err := loadStatuses(afterPath, queueName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", afterPath, err)
+	}
+
+	diff := &snapshotDiff{}
+	for key, status := range after {
+		previous, existed := before[key]
+		switch {
+		case !existed:
+			diff.Added = append(diff.Added, key)
+		case status == "completed" && previous != "completed":
+			diff.Completed = append(diff.Completed, key)
+		case status == "failed" && previous != "failed":
+			diff.Failed = append(diff.Failed, key)
+		case status == "pending" && previous == "pending":
+			diff.StillPending = append(diff.StillPending, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	for _, keys := range [][]itemKey{diff.Added, diff.Completed, diff.Failed, diff.StillPending, diff.Removed} {
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].queueName != keys[j].queueName {
+				return keys[i].queueName < keys[j].queueName
+			}
+			return keys[i].id < keys[j].id
+		})
+	}
+
+	return diff, nil
+}
+
+// printDiff prints the counts of each category, and the items if showIDs is set
+func printDiff(diff *snapshotDiff, showIDs bool) {
+	categories := []struct {
+		label string
+		keys  []itemKey
+	}{
+		{"Added", diff.Added},
+		{"Completed", diff.Completed},
+		{"Failed", diff.Failed},
+		{"Still pending", diff.StillPending},
+		{"Removed", diff.Removed},
+	}
+
+	for _, c := range categories {
+		fmt.Printf("%-14s %d\n", c.label+":", len(c.keys))
+		if showIDs {
+			for _, key := range c.keys {
+				fmt.Printf("  %s/%d\n", key.queueName, key.id)
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nicotsx/laqueue/worker"
+)
+
+// maxStderr is the number of bytes of stderr kept in error messages
+const maxStderr = 1024
+
+// defaultKillGrace is how long a command is given to exit after SIGTERM
+const defaultKillGrace = 10 * time.Second
+
+// execOptions supervises the commands run by execHandler
+type execOptions struct {
+	// Timeout bounds how long the command may run for an item. Zero means
+	// no timeout.
+	Timeout time.Duration
+
+	// KillGrace is how long a command is given to exit after SIGTERM, on
+	// timeout or shutdown, before it is killed with SIGKILL. Defaults to
+	// defaultKillGrace.
+	KillGrace time.Duration
+
+	// Dir is the working directory of the command. Defaults to the current
+	// directory.
+	Dir string
+
+	// Env adds variables to the environment of the command
+	Env map[string]string
+}
+
+// execHandler returns a handler running command for every item, with the
+// payload on stdin and the item described by LAQUEUE_* environment
+// variables (see jobEnv). The item succeeds if the command exits with status
+// 0; if it prints valid JSON on stdout, that is stored as the item's result.
+func execHandler(command []string, opts execOptions) worker.ProcessContextFunc {
+	if opts.KillGrace <= 0 {
+		opts.KillGrace = defaultKillGrace
+	}
+
+	return func(ctx context.Context, payload []byte) error {
+		runCtx := ctx
+		if opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+		}
+
+		cmd := exec.CommandContext(runCtx, command[0], command[1:]...)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Dir = opts.Dir
+		cmd.Env = append(os.Environ(), jobEnv(ctx, opts.Env)...)
+
+		// Ask the command to stop first, then kill it if it doesn't
+		cmd.Cancel = func() error {
+			return cmd.Process.Signal(syscall.SIGTERM)
+		}
+		cmd.WaitDelay = opts.KillGrace
+
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("timed out after %v: %w", opts.Timeout, err)
+			}
+			msg := strings.TrimSpace(stderr.String())
+			if len(msg) > maxStderr {
+				msg = msg[len(msg)-maxStderr:]
+			}
+			if msg != "" {
+				return fmt.Errorf("%s: %w: %s", command[0], err, msg)
+			}
+			return fmt.Errorf("%s: %w", command[0], err)
+		}
+
+		if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 && json.Valid(out) {
+			return worker.SetResult(ctx, json.RawMessage(out))
+		}
+		return nil
+	}
+}
+
+// jobEnv returns the environment variables describing the item of ctx,
+// followed by extra:
+//   - LAQUEUE_QUEUE, LAQUEUE_JOB_ID and LAQUEUE_ATTEMPT (1 for the first run)
+//   - LAQUEUE_EXTERNAL_ID, if the item has one, and LAQUEUE_CONTENT_TYPE
+//   - LAQUEUE_META_<KEY> for each metadata entry, with the key upper-cased
+//     and characters other than letters, digits and underscores replaced
+//     with underscores
+func jobEnv(ctx context.Context, extra map[string]string) []string {
+	var env []string
+	if name, ok := worker.QueueNameFromContext(ctx); ok {
+		env = append(env, "LAQUEUE_QUEUE="+name)
+	}
+	if id, ok := worker.JobIDFromContext(ctx); ok {
+		env = append(env, "LAQUEUE_JOB_ID="+strconv.FormatInt(id, 10))
+	}
+	if attempt, ok := worker.AttemptFromContext(ctx); ok {
+		env = append(env, "LAQUEUE_ATTEMPT="+strconv.Itoa(attempt))
+	}
+	if externalID, ok := worker.ExternalIDFromContext(ctx); ok && externalID != "" {
+		env = append(env, "LAQUEUE_EXTERNAL_ID="+externalID)
+	}
+	if contentType, ok := worker.ContentTypeFromContext(ctx); ok {
+		env = append(env, "LAQUEUE_CONTENT_TYPE="+contentType)
+	}
+
+	metadata, _ := worker.MetadataFromContext(ctx)
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, "LAQUEUE_META_"+envName(key)+"="+metadata[key])
+	}
+
+	keys = keys[:0]
+	for key := range extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+extra[key])
+	}
+	return env
+}
+
+// envName turns s into an environment variable name
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
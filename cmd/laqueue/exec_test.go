@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+	"github.com/nicotsx/laqueue/worker"
+)
+
+func TestExecHandler(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	run := func(script string, opts execOptions) (time.Duration, error) {
+		start := time.Now()
+		err := execHandler([]string{"sh", "-c", script}, opts)(context.Background(), []byte(`{}`))
+		return time.Since(start), err
+	}
+	exitStatus := func(err error) (syscall.WaitStatus, bool) {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return 0, false
+		}
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		return status, ok
+	}
+
+	// The exit status decides the outcome of the item
+	if _, err := run(`cat > /dev/null; echo done`, execOptions{}); err != nil {
+		t.Errorf("Expected success on exit status 0, got %v", err)
+	}
+	for _, code := range []int{1, 2, 75} {
+		_, err := run(`echo "broken pipe" >&2; exit `+strconv.Itoa(code), execOptions{})
+		status, ok := exitStatus(err)
+		if !ok || status.ExitStatus() != code {
+			t.Errorf("Expected exit status %d, got %v", code, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), "broken pipe") {
+			t.Errorf("Expected stderr in the error, got %v", err)
+		}
+	}
+
+	// On timeout the command is asked to stop with SIGTERM
+	elapsed, err := run(`trap 'kill $!; echo stopping >&2; exit 3' TERM; sleep 10 & wait`, execOptions{
+		Timeout:   100 * time.Millisecond,
+		KillGrace: 5 * time.Second,
+	})
+	if status, ok := exitStatus(err); !ok || status.ExitStatus() != 3 {
+		t.Errorf("Expected the command to exit on SIGTERM, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "timed out") || !strings.Contains(err.Error(), "stopping") {
+		t.Errorf("Expected a timeout error with stderr, got %v", err)
+	}
+	if elapsed > 3*time.Second {
+		t.Errorf("Expected the command to stop without waiting for the kill grace, took %v", elapsed)
+	}
+
+	// Commands ignoring SIGTERM are killed once the grace is over
+	elapsed, err = run(`trap '' TERM; exec sleep 10`, execOptions{
+		Timeout:   100 * time.Millisecond,
+		KillGrace: 200 * time.Millisecond,
+	})
+	if status, ok := exitStatus(err); !ok || !status.Signaled() || status.Signal() != syscall.SIGKILL {
+		t.Errorf("Expected the command to be killed, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+	if elapsed < 300*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Expected the command to be killed after the grace, took %v", elapsed)
+	}
+
+	// Shutdown stops the command without reporting a timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = execHandler([]string{"sh", "-c", `exec sleep 10`}, execOptions{Timeout: time.Minute})(ctx, nil)
+	if err == nil || strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected the command to be stopped on shutdown, got %v", err)
+	}
+}
+
+func TestExecHandlerJob(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	script := `printf '{"payload":%s,"job":"%s","attempt":%s,"queue":"%s","extra":"%s","dir":"%s"}' ` +
+		`"$(cat)" "$LAQUEUE_JOB_ID" "$LAQUEUE_ATTEMPT" "$LAQUEUE_QUEUE" "$EXTRA" "$(pwd)"`
+	w := worker.NewContext(db, worker.Config{QueueName: "scripts", Interval: 10 * time.Millisecond},
+		execHandler([]string{"sh", "-c", script}, execOptions{Dir: dir, Env: map[string]string{"EXTRA": "yes"}}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	done := make(chan struct{})
+	go func() {
+		w.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	result, err := queue.New(db, "scripts").EnqueueAndWait(ctx, map[string]int{"n": 1})
+	if err != nil {
+		t.Fatalf("Failed to process item: %v", err)
+	}
+	var out struct {
+		Payload map[string]int
+		Job     string
+		Attempt int
+		Queue   string
+		Extra   string
+		Dir     string
+	}
+	if err := json.Unmarshal(result, &out); err != nil {
+		t.Fatalf("Expected the JSON output as result, got %s: %v", result, err)
+	}
+	if out.Payload["n"] != 1 || out.Job == "" || out.Attempt != 1 || out.Queue != "scripts" || out.Extra != "yes" || out.Dir != dir {
+		t.Errorf("Unexpected job seen by the command: %+v", out)
+	}
+}
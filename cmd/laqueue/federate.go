@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// dbPaths is the value of the -db flag, which may be repeated to list or
+// count the items of several database files at once
+type dbPaths struct {
+	paths []string
+	set   bool
+}
+
+func (p *dbPaths) String() string {
+	return strings.Join(p.paths, ",")
+}
+
+// Set replaces the default path on first use, then appends
+func (p *dbPaths) Set(path string) error {
+	if !p.set {
+		p.paths, p.set = nil, true
+	}
+	p.paths = append(p.paths, path)
+	return nil
+}
+
+// sourcedItem is an item along with the database file it was read from
+type sourcedItem struct {
+	source string
+	item   *queue.QueueItem
+}
+
+// listFederated lists the matching items of every database file as one
+// listing, ordered and limited as opts asks for a single database. IDs of
+// different files are unrelated, so ID order is approximated by creation time.
+func listFederated(paths []string, queueName string, opts queue.ListOptions) ([]sourcedItem, error) {
+	var all []sourcedItem
+	for _, path := range paths {
+		items, err := listDatabase(path, queueName, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, item := range items {
+			all = append(all, sourcedItem{source: path, item: item})
+		}
+	}
+
+	// Each database is already sorted: a stable sort keeps their order on ties
+	sort.SliceStable(all, func(i, j int) bool {
+		a, b := all[i].item, all[j].item
+		if opts.Descending {
+			a, b = b, a
+		}
+		switch opts.Sort {
+		case "", "id", "created_at":
+			return a.CreatedAt.Before(b.CreatedAt)
+		case "scheduled_at":
+			return a.ScheduledAt.Before(b.ScheduledAt)
+		case "attempts":
+			return a.Attempts < b.Attempts
+		}
+		return false
+	})
+
+	if opts.Limit > 0 && len(all) > opts.Limit {
+		all = all[:opts.Limit]
+	}
+	return all, nil
+}
+
+// listDatabase lists the matching items of a single database file
+func listDatabase(path, queueName string, opts queue.ListOptions) ([]*queue.QueueItem, error) {
+	var items []*queue.QueueItem
+	err := withQueue(path, queueName, func(q *queue.LaQueue) (err error) {
+		items, err = q.List(opts)
+		return err
+	})
+	return items, err
+}
+
+// sourcedCounts is the number of items of each status of a queue in the
+// database file it was read from
+type sourcedCounts struct {
+	source string
+	counts map[string]int
+}
+
+// countFederated counts the items of each status of the queue matching the
+// filters of opts in every database file, in the order of paths
+func countFederated(paths []string, queueName string, opts queue.ListOptions) ([]sourcedCounts, error) {
+	all := make([]sourcedCounts, 0, len(paths))
+	for _, path := range paths {
+		var counts map[string]int
+		err := withQueue(path, queueName, func(q *queue.LaQueue) (err error) {
+			counts, err = q.CountsWithOptions(opts)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		all = append(all, sourcedCounts{source: path, counts: counts})
+	}
+	return all, nil
+}
+
+// withQueue calls fn with the queue of the database file at path, which is
+// closed once fn returns
+func withQueue(path, queueName string, fn func(q *queue.LaQueue) error) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := initDatabase(db); err != nil {
+		return err
+	}
+
+	q := queue.New(db, queueName)
+	defer q.Close()
+	return fn(q)
+}
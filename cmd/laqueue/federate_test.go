@@ -0,0 +1,70 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+func TestCountFederated(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i, pending := range []int{2, 3} {
+		path := filepath.Join(dir, fmt.Sprintf("service%d.db", i))
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatalf("Failed to open database: %v", err)
+		}
+		if err := queue.InitSchema(db); err != nil {
+			t.Fatalf("Failed to initialize database: %v", err)
+		}
+		q := queue.New(db, "jobs")
+		for j := 0; j < pending; j++ {
+			if _, err := q.Enqueue(j); err != nil {
+				t.Fatalf("Failed to enqueue item: %v", err)
+			}
+		}
+		// One item per database was created two days ago
+		if _, err := db.Exec(`UPDATE queue_items SET created_at = ? WHERE id = 1`, time.Now().Add(-48*time.Hour).UTC()); err != nil {
+			t.Fatalf("Failed to backdate item: %v", err)
+		}
+		if _, err := queue.New(db, "other").Enqueue("other"); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		q.Close()
+		db.Close()
+		paths = append(paths, path)
+	}
+
+	stats, err := countFederated(paths, "jobs", queue.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to count items: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("Expected counts for 2 databases, got %+v", stats)
+	}
+	for i, want := range []int{2, 3} {
+		if stats[i].source != paths[i] || stats[i].counts[queue.StatusPending] != want || len(stats[i].counts) != 1 {
+			t.Errorf("Database %d: expected %d pending items from %s, got %+v", i, want, paths[i], stats[i])
+		}
+	}
+
+	// Counts are restricted like list
+	stats, err = countFederated(paths, "jobs", queue.ListOptions{Since: time.Now().Add(-24 * time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to count items: %v", err)
+	}
+	for i, want := range []int{1, 2} {
+		if stats[i].counts[queue.StatusPending] != want {
+			t.Errorf("Database %d: expected %d recent pending items, got %+v", i, want, stats[i])
+		}
+	}
+
+	if _, err := countFederated([]string{filepath.Join(dir, "missing", "x.db")}, "jobs", queue.ListOptions{}); err == nil {
+		t.Error("Expected an error for a missing database")
+	}
+}
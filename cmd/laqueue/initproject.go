@@ -0,0 +1,75 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// scaffold holds the files of the project generated by laqueue init-project.
+// They are a regular package of this module, so they are built and vetted
+// with it and keep up with API changes.
+//
+//go:embed scaffold/*.go scaffold/config.yaml
+var scaffold embed.FS
+
+// initProject writes the scaffold into dir along with a go.mod declaring
+// module, and returns the paths of the files written. Existing files are
+// never overwritten.
+func initProject(dir, module string) ([]string, error) {
+	files := map[string][]byte{
+		"go.mod": []byte(fmt.Sprintf("module %s\n\ngo %s\n", module, goVersion())),
+	}
+	err := fs.WalkDir(scaffold, "scaffold", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := scaffold.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[strings.TrimPrefix(path, "scaffold/")] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range files {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return nil, fmt.Errorf("%s already exists", filepath.Join(dir, name))
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var written []string
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	sort.Strings(written)
+	return written, nil
+}
+
+// goVersion returns the language version of the toolchain that built
+// laqueue, e.g. 1.24, for the go directive of generated modules
+func goVersion() string {
+	parts := strings.SplitN(runtime.Version(), ".", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "go") {
+		return "1.24" // Development toolchain
+	}
+	return strings.TrimPrefix(parts[0], "go") + "." + parts[1]
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// parseTimeFilter parses the value of list -since and -before: either a
+// duration counted back from now (e.g. 1h, 30m) or a date, with an optional
+// time, in local time (2024-01-01, 2024-01-01 15:04:05 or RFC 3339). An
+// empty value returns the zero time.
+func parseTimeFilter(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := parseDate(value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration nor a date", value)
+}
+
+// parseDate parses a date, with an optional time, in local time
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date", value)
+}
+
+// listColumns lists the columns list -columns accepts, besides payload.PATH
+var listColumns = map[string]bool{
+	"id":              true,
+	"external_id":     true,
+	"queue":           true,
+	"status":          true,
+	"attempts":        true,
+	"created_at":      true,
+	"scheduled_at":    true,
+	"last_attempt_at": true,
+	"claimed_by":      true,
+	"held_for":        true,
+	"notes":           true,
+	"content_type":    true,
+	"payload":         true,
+}
+
+// parseColumns splits and validates the value of list -columns. An empty
+// spec returns nil, selecting the default table.
+func parseColumns(spec string) ([]string, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	columns := strings.Split(spec, ",")
+	for i, column := range columns {
+		column = strings.TrimSpace(column)
+		if !listColumns[column] && !strings.HasPrefix(column, "payload.") {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+		columns[i] = column
+	}
+	return columns, nil
+}
+
+// printListHeader prints the column headers of the list table. withSource
+// adds a leading DB column, used when listing several databases.
+func printListHeader(queueName string, columns []string, withSource bool) {
+	fmt.Printf("Items in queue '%s':\n", queueName)
+	prefix, underline := "", ""
+	if withSource {
+		prefix, underline = "DB\t", "--\t"
+	}
+
+	if columns == nil {
+		fmt.Println(prefix + "ID\tStatus\tAttempts\tCreated At\tScheduled At\tPayload")
+		fmt.Println(underline + "--\t------\t--------\t----------\t------------\t-------")
+		return
+	}
+
+	underlines := make([]string, len(columns))
+	for i, column := range columns {
+		underlines[i] = strings.Repeat("-", len(column))
+	}
+	fmt.Println(prefix + strings.Join(columns, "\t"))
+	fmt.Println(underline + strings.Join(underlines, "\t"))
+}
+
+// printItemRow prints an item as a row of the list table, restricted to the
+// given columns if any. A non-empty source fills the DB column. q reads the
+// notes column, which shows "-" without a queue.
+func printItemRow(q *queue.LaQueue, item *queue.QueueItem, columns []string, source string) {
+	if source != "" {
+		fmt.Print(source + "\t")
+	}
+
+	if columns != nil {
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = columnValue(q, item, column)
+		}
+		fmt.Println(strings.Join(values, "\t"))
+		return
+	}
+
+	fmt.Printf("%d\t%s\t%d\t%s\t%s\t%s\n",
+		item.ID,
+		item.Status,
+		item.Attempts,
+		item.CreatedAt.Format("2006-01-02 15:04:05"),
+		item.ScheduledAt.Format("2006-01-02 15:04:05"),
+		payloadSummary(item),
+	)
+}
+
+// columnValue formats one column of an item for the list table
+func columnValue(q *queue.LaQueue, item *queue.QueueItem, column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(item.ID, 10)
+	case "external_id":
+		if item.ExternalID == "" {
+			return "-"
+		}
+		return item.ExternalID
+	case "queue":
+		return item.QueueName
+	case "status":
+		return item.Status
+	case "attempts":
+		return strconv.Itoa(item.Attempts)
+	case "created_at":
+		return item.CreatedAt.Format("2006-01-02 15:04:05")
+	case "scheduled_at":
+		return item.ScheduledAt.Format("2006-01-02 15:04:05")
+	case "last_attempt_at":
+		if item.LastAttemptAt == nil {
+			return "-"
+		}
+		return item.LastAttemptAt.Format("2006-01-02 15:04:05")
+	case "claimed_by":
+		if item.ClaimedBy == "" {
+			return "-"
+		}
+		return item.ClaimedBy
+	case "held_for":
+		return heldFor(item, time.Now())
+	case "notes":
+		return latestNote(q, item.ID)
+	case "content_type":
+		return item.ContentType
+	case "payload":
+		if item.ContentType != queue.ContentTypeJSON && !isText(item.ContentType) {
+			return payloadSummary(item)
+		}
+		return string(item.Payload)
+	}
+	return payloadField(item.Payload, strings.TrimPrefix(column, "payload."))
+}
+
+// payloadSummary formats the payload of an item for the list table: JSON and
+// text as in show, binary payloads by their size only
+func payloadSummary(item *queue.QueueItem) string {
+	if item.ContentType == queue.ContentTypeJSON || isText(item.ContentType) {
+		return renderPayload(item)
+	}
+	return fmt.Sprintf("<%d bytes of %s>", len(item.Payload), item.ContentType)
+}
+
+// heldFor returns how long a processing item has been held by its worker,
+// or "-" for items that aren't processing
+func heldFor(item *queue.QueueItem, now time.Time) string {
+	if item.Status != queue.StatusProcessing || item.LastAttemptAt == nil {
+		return "-"
+	}
+	return now.Sub(*item.LastAttemptAt).Truncate(time.Second).String()
+}
+
+// latestNote returns the latest annotation of an item, prefixed with the
+// number of annotations when there are several, or "-" if there is none
+func latestNote(q *queue.LaQueue, id int64) string {
+	if q == nil {
+		return "-"
+	}
+	annotations, err := q.Annotations(id)
+	if err != nil || len(annotations) == 0 {
+		return "-"
+	}
+	note := annotations[len(annotations)-1].Note
+	if len(annotations) > 1 {
+		return fmt.Sprintf("(%d) %s", len(annotations), note)
+	}
+	return note
+}
+
+// payloadField extracts the value at a dot-separated path of a JSON payload,
+// e.g. "user.email" or "items.0.sku". Missing fields are shown as "-",
+// strings without quotes and other values as compact JSON.
+func payloadField(payload []byte, path string) string {
+	var value any
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return "-"
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]any:
+			field, ok := node[key]
+			if !ok {
+				return "-"
+			}
+			value = field
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return "-"
+			}
+			value = node[index]
+		default:
+			return "-"
+		}
+	}
+
+	if str, ok := value.(string); ok {
+		return str
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// jsonItem is the NDJSON representation of an item, with the payload, result
+// and checkpoint embedded as JSON rather than base64
+type jsonItem struct {
+	*queue.QueueItem
+	Payload    json.RawMessage `json:"payload"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Checkpoint json.RawMessage `json:"checkpoint,omitempty"`
+
+	// DB is the database file the item was read from, when listing several
+	DB string `json:"db,omitempty"`
+}
+
+// printItemJSON prints an item as a single line of JSON, labelled with its
+// source database if not empty
+func printItemJSON(item *queue.QueueItem, source string) error {
+	out := jsonItem{QueueItem: item, Payload: item.Payload, DB: source}
+	if len(item.Result) > 0 {
+		out.Result = item.Result
+	}
+	if len(item.Checkpoint) > 0 {
+		out.Checkpoint = item.Checkpoint
+	}
+	if isText(item.ContentType) || item.ContentType == queue.ContentTypeJSON && !json.Valid(item.Payload) {
+		// Keep the line valid JSON for non-JSON payloads
+		out.Payload, _ = json.Marshal(string(item.Payload))
+	} else if item.ContentType != queue.ContentTypeJSON {
+		// Binary payloads are embedded in base64
+		out.Payload, _ = json.Marshal(item.Payload)
+	}
+	return json.NewEncoder(os.Stdout).Encode(out)
+}
+
+// printLanes prints the priority lanes of a queue as a table
+func printLanes(queueName string, lanes []queue.Lane) {
+	fmt.Printf("Priority lanes of queue '%s':\n", queueName)
+	fmt.Println("Priority\tDepth\tOldest Wait\tClaimed\tLatency")
+	fmt.Println("--------\t-----\t-----------\t-------\t-------")
+	for _, lane := range lanes {
+		fmt.Printf("%d\t%d\t%s\t%d\t%s\n",
+			lane.Priority,
+			lane.Depth,
+			lane.OldestWait.Truncate(time.Second),
+			lane.Claimed,
+			lane.Latency.Truncate(time.Millisecond),
+		)
+	}
+}
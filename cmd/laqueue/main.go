@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/nicotsx/laqueue/queue"
@@ -14,20 +21,142 @@ import (
 
 func main() {
 	// Define command line flags
-	dbPathFlag := flag.String("db", "./laqueue.db", "Path to SQLite database file")
+	dbPathFlag := &dbPaths{paths: []string{"./laqueue.db"}}
+	flag.Var(dbPathFlag, "db", "Path to SQLite database file, repeatable with list and stats to combine several files")
 	queueNameFlag := flag.String("queue", "default", "Name of the queue to operate on")
+	redactFlag := flag.Bool("redact", false, "Mask secrets (passwords, tokens, card numbers...) in the payloads, results and checkpoints shown")
+	redactFieldsFlag := flag.String("redact-fields", "", "Comma-separated additional field names to mask, implies -redact")
 
 	// Define subcommands
 	enqueueCmd := flag.NewFlagSet("enqueue", flag.ExitOnError)
-	enqueueFile := enqueueCmd.String("file", "", "JSON file containing the payload")
+	enqueueFile := enqueueCmd.String("file", "", "JSON file containing the payload, or any file with -content-type")
 	enqueueJson := enqueueCmd.String("json", "", "JSON string containing the payload")
 	enqueueDelay := enqueueCmd.Duration("delay", 0, "Delay before processing (e.g. 5s, 1m, 1h)")
+	enqueuePriority := enqueueCmd.Int("priority", 0, "Priority for workers claiming by priority, higher first")
+	enqueueContentType := enqueueCmd.String("content-type", queue.ContentTypeJSON, "Content type of the payload, e.g. text/plain or application/protobuf; other types than JSON are enqueued from -file as they are")
+	enqueueRequires := enqueueCmd.String("requires", "", "Only let workers with these labels claim the item, as key=value[,key=value...]")
+	enqueueNDJSON := enqueueCmd.String("ndjson", "", "File of jobs to enqueue, one JSON object per line with queue, payload, delay, priority, lock_key, group_key, metadata and requires")
+	enqueueDryRun := enqueueCmd.Bool("dry-run", false, "With -ndjson, validate the jobs and report what would be enqueued without writing anything")
+	enqueueMaxSize := enqueueCmd.Int("max-size", 0, "With -ndjson, reject payloads larger than this many bytes (default: no limit)")
 
 	initCmd := flag.NewFlagSet("init", flag.ExitOnError)
 
 	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
-	listStatus := listCmd.String("status", "", "Filter by status (pending, processing, completed, failed)")
+	listStatus := listCmd.String("status", "", "Filter by status (pending, processing, completed, failed, corrupt)")
 	listLimit := listCmd.Int("limit", 10, "Maximum number of items to show")
+	listAll := listCmd.Bool("all", false, "Stream every matching item in ID order, ignoring -limit")
+	listJSON := listCmd.Bool("json", false, "Print one JSON object per line instead of a table")
+	listSort := listCmd.String("sort", "", "Sort by id, created_at, scheduled_at or attempts (default: newest first)")
+	listReverse := listCmd.Bool("reverse", false, "Reverse the sort order")
+	listColumnsFlag := listCmd.String("columns", "", "Comma-separated columns to show, e.g. id,status,claimed_by,held_for,notes,content_type,payload.user.email")
+	listSince := listCmd.String("since", "", "Only items created since a duration ago (e.g. 1h) or a date (e.g. 2024-01-01)")
+	listBefore := listCmd.String("before", "", "Only items created before a duration ago (e.g. 24h) or a date (e.g. 2024-01-01)")
+	listScheduledWithin := listCmd.Duration("scheduled-within", 0, "Only items becoming eligible within this duration (e.g. 10m)")
+
+	showCmd := flag.NewFlagSet("show", flag.ExitOnError)
+	showID := showCmd.Int64("id", 0, "ID of the item to show")
+	showExternalID := showCmd.String("external-id", "", "External ID of the item to show, instead of -id")
+
+	annotateCmd := flag.NewFlagSet("annotate", flag.ExitOnError)
+	annotateID := annotateCmd.Int64("id", 0, "ID of the item to annotate")
+	annotateNote := annotateCmd.String("note", "", "Note to attach to the item, e.g. \"waiting on vendor fix\"")
+
+	resetCmd := flag.NewFlagSet("reset-attempts", flag.ExitOnError)
+	resetIDs := resetCmd.String("ids", "", "Comma-separated IDs of the items to reset")
+	resetStatus := resetCmd.String("status", "", "Reset every item with this status instead (e.g. failed)")
+
+	pauseCmd := flag.NewFlagSet("pause", flag.ExitOnError)
+	pauseFrom := pauseCmd.String("from", "", "Start of the pause, e.g. \"2024-06-02 02:00\" (default: now)")
+	pauseFor := pauseCmd.Duration("for", 0, "Duration of the pause, e.g. 1h")
+	pauseEvery := pauseCmd.Duration("every", 0, "Repeat the pause at this period, e.g. 168h for weekly")
+	pauseList := pauseCmd.Bool("list", false, "List the pause windows of the queue")
+	pauseDelete := pauseCmd.Int64("delete", 0, "ID of the pause window to delete")
+
+	replayCmd := flag.NewFlagSet("replay", flag.ExitOnError)
+	replayFrom := replayCmd.String("from", "", "Database file to replay items from, e.g. an archived copy (default: -db)")
+	replayTo := replayCmd.String("to", "", "Queue to enqueue the items into (default: -queue)")
+	replayStatus := replayCmd.String("status", queue.StatusFailed, "Only replay items with this status (empty for all)")
+	replaySince := replayCmd.String("since", "", "Only items created since a duration ago (e.g. 24h) or a date in local time (e.g. 2024-05-01)")
+	replayBefore := replayCmd.String("before", "", "Only items created before a duration ago or a date in local time")
+	replayLimit := replayCmd.Int("limit", 0, "Maximum number of items to replay (default: no limit)")
+	replayRate := replayCmd.Float64("rate", 0, "Maximum number of items enqueued per second (default: no limit)")
+	replayDryRun := replayCmd.Bool("dry-run", false, "List the items that would be replayed without enqueueing them")
+
+	boostCmd := flag.NewFlagSet("boost", flag.ExitOnError)
+	boostID := boostCmd.Int64("id", 0, "ID of the pending item to move to the front of the queue")
+
+	doctorCmd := flag.NewFlagSet("doctor", flag.ExitOnError)
+
+	exportCmd := flag.NewFlagSet("export", flag.ExitOnError)
+	exportOut := exportCmd.String("o", "", "File to write the archive to (default: standard output)")
+	exportAll := exportCmd.Bool("all-queues", false, "Export the items of every queue, not only -queue")
+
+	importCmd := flag.NewFlagSet("import", flag.ExitOnError)
+	importIn := importCmd.String("i", "", "Archive file to read (default: standard input)")
+	importOnConflict := importCmd.String("on-conflict", "fail", "What to do with items whose external ID or dedup key is taken: fail, skip or replace")
+
+	rescheduleCmd := flag.NewFlagSet("reschedule", flag.ExitOnError)
+	rescheduleFrom := rescheduleCmd.String("from", "", "Only items scheduled at or after this date")
+	rescheduleUntil := rescheduleCmd.String("until", "", "Only items scheduled before this date")
+	rescheduleSelect := rescheduleCmd.String("select", "", "Only items with this metadata, as key=value[,key=value...]")
+	rescheduleAt := rescheduleCmd.String("at", "", "Date to reschedule the items to")
+	rescheduleShift := rescheduleCmd.Duration("shift", 0, "Duration to move the items by, e.g. 2h or -30m")
+
+	ratesCmd := flag.NewFlagSet("rates", flag.ExitOnError)
+	ratesWindow := ratesCmd.Duration("window", 5*time.Minute, "Period the rates are computed over")
+	var ratesAlarms alarmFlags
+	ratesCmd.Var(&ratesAlarms, "alarm", "Alarm such as failed>10 or completed<1, in items per minute (repeatable)")
+
+	snapshotCmd := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	snapshotList := snapshotCmd.Bool("list", false, "List the snapshots of the queue instead of taking one")
+	snapshotSince := snapshotCmd.String("since", "", "With -list, only snapshots taken since a duration ago (e.g. 168h) or a date")
+
+	statsCmd := flag.NewFlagSet("stats", flag.ExitOnError)
+	statsSince := statsCmd.String("since", "", "Only count items created since a duration ago (e.g. 1h) or a date (e.g. 2024-01-01)")
+	statsBefore := statsCmd.String("before", "", "Only count items created before a duration ago (e.g. 24h) or a date (e.g. 2024-01-01)")
+	statsScheduledWithin := statsCmd.Duration("scheduled-within", 0, "Only count items becoming eligible within this duration (e.g. 10m)")
+
+	purgeCmd := flag.NewFlagSet("purge", flag.ExitOnError)
+	purgeSince := purgeCmd.String("since", "", "Only purge items finished since a duration ago (e.g. 1h) or a date (e.g. 2024-01-01)")
+	purgeBefore := purgeCmd.String("before", "", "Only purge items finished before a duration ago (e.g. 24h) or a date (e.g. 2024-01-01)")
+	purgeStatus := purgeCmd.String("status", "", "Only purge completed or failed items (default: both)")
+
+	lanesCmd := flag.NewFlagSet("lanes", flag.ExitOnError)
+	lanesWindow := lanesCmd.Duration("window", time.Hour, "Period over which the claim latency is averaged")
+
+	diffCmd := flag.NewFlagSet("diff", flag.ExitOnError)
+	diffQueue := diffCmd.String("queue", "", "Only compare items of this queue (default: all queues)")
+	diffIDs := diffCmd.Bool("ids", false, "List the items of each category")
+
+	applyCmd := flag.NewFlagSet("apply", flag.ExitOnError)
+	applyFile := applyCmd.String("f", "queues.yaml", "YAML file defining the queues to configure")
+
+	relayCmd := flag.NewFlagSet("relay", flag.ExitOnError)
+	relayTable := relayCmd.String("table", "", "Outbox table to relay rows from")
+	relayMapFile := relayCmd.String("map", "relay.yaml", "YAML file mapping the outbox columns to queue items")
+	relayInterval := relayCmd.Duration("interval", time.Second, "How often to check for new rows once the outbox is drained")
+	relayBatch := relayCmd.Int("batch", 100, "Maximum number of rows relayed per transaction")
+	relayOnce := relayCmd.Bool("once", false, "Relay the rows present and exit")
+
+	replicateCmd := flag.NewFlagSet("replicate", flag.ExitOnError)
+	replicateTo := replicateCmd.String("to", "", "Standby SQLite database file, or URL of a standby served with -listen, to replicate the queues to")
+	replicateListen := replicateCmd.String("listen", "", "Serve this database as a standby on the given address, e.g. :8090")
+	replicateInterval := replicateCmd.Duration("interval", time.Second, "How often to check for new changes")
+	replicateBatch := replicateCmd.Int("batch", 500, "Maximum number of changes applied per transaction")
+	replicateOnceFlag := replicateCmd.Bool("once", false, "Replicate the pending changes and exit")
+	replicateDisable := replicateCmd.Bool("disable", false, "Stop recording changes for replication and exit")
+
+	daemonCmd := flag.NewFlagSet("daemon", flag.ExitOnError)
+	daemonConfigFile := daemonCmd.String("config", "workers.yaml", "YAML file defining the workers to run and the enqueue endpoint")
+
+	initProjectCmd := flag.NewFlagSet("init-project", flag.ExitOnError)
+	initProjectModule := initProjectCmd.String("module", "example.com/app", "Module path of the generated project")
+
+	benchCmd := flag.NewFlagSet("bench", flag.ExitOnError)
+	benchProducers := benchCmd.Int("producers", 1, "Number of concurrent producers")
+	benchWorkers := benchCmd.Int("workers", 1, "Number of concurrent workers")
+	benchItems := benchCmd.Int("n", 10000, "Number of items to enqueue and process")
+	benchDir := benchCmd.String("dir", os.TempDir(), "Directory in which to create the scratch database")
 
 	// Parse top-level flags
 	flag.Parse()
@@ -38,8 +167,59 @@ func main() {
 		os.Exit(1)
 	}
 
+	if len(dbPathFlag.paths) > 1 && flag.Args()[0] != "list" && flag.Args()[0] != "stats" {
+		log.Fatal("Multiple -db flags are only supported by the list and stats commands")
+	}
+	dbPath := dbPathFlag.paths[0]
+	redactor := newRedactor(*redactFlag, *redactFieldsFlag)
+
+	// Generating a project doesn't involve a database
+	if flag.Args()[0] == "init-project" {
+		initProjectCmd.Parse(flag.Args()[1:])
+		if initProjectCmd.NArg() != 1 {
+			log.Fatal("Usage: laqueue init-project [-module PATH] DIR")
+		}
+
+		dir := initProjectCmd.Arg(0)
+		written, err := initProject(dir, *initProjectModule)
+		if err != nil {
+			log.Fatalf("Failed to generate project: %v", err)
+		}
+		for _, path := range written {
+			fmt.Printf("Created %s\n", path)
+		}
+		fmt.Printf("\nNext steps:\n  cd %s\n  go mod tidy\n  go run . worker\n", dir)
+		return
+	}
+
+	// The daemon configuration may point to another database
+	if flag.Args()[0] == "daemon" {
+		daemonCmd.Parse(flag.Args()[1:])
+
+		config, err := loadDaemonConfig(*daemonConfigFile)
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		if config.DB != "" {
+			dbPath = config.DB
+		}
+
+		db, err := sql.Open("sqlite3", dbPath)
+		if err != nil {
+			log.Fatalf("Failed to open database: %v", err)
+		}
+		defer db.Close()
+
+		if err := initDatabase(db); err != nil {
+			log.Fatalf("Failed to initialize database: %v", err)
+		}
+
+		runDaemon(context.Background(), db, config)
+		return
+	}
+
 	// Open the database
-	db, err := sql.Open("sqlite3", *dbPathFlag)
+	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
@@ -59,10 +239,59 @@ func main() {
 	case "enqueue":
 		enqueueCmd.Parse(flag.Args()[1:])
 
+		if *enqueueNDJSON != "" {
+			file, err := os.Open(*enqueueNDJSON)
+			if err != nil {
+				log.Fatalf("Failed to read file: %v", err)
+			}
+			entries, problems, err := readBatch(file, *queueNameFlag, *enqueueMaxSize)
+			file.Close()
+			if err != nil {
+				log.Fatalf("Failed to read file: %v", err)
+			}
+
+			// Nothing is written unless every job is valid
+			if *enqueueDryRun || len(problems) > 0 {
+				printBatchPreview(entries, problems)
+				if len(problems) > 0 {
+					os.Exit(1)
+				}
+				break
+			}
+
+			queues := make(map[string]*queue.LaQueue)
+			n, err := enqueueBatch(func(name string) *queue.LaQueue {
+				if queues[name] == nil {
+					queues[name] = queue.New(db, name)
+				}
+				return queues[name]
+			}, entries)
+			for _, q := range queues {
+				q.Close()
+			}
+			if err != nil {
+				log.Fatalf("Failed to enqueue item after %d items: %v", n, err)
+			}
+			fmt.Printf("Enqueued %d items to %d queues\n", n, len(queues))
+			break
+		}
+		if *enqueueDryRun {
+			log.Fatal("-dry-run requires -ndjson")
+		}
+
 		var payload any
 
 		// Parse the payload from file or command line
-		if *enqueueFile != "" {
+		if *enqueueContentType != queue.ContentTypeJSON {
+			if *enqueueFile == "" {
+				log.Fatalf("-content-type %s requires -file", *enqueueContentType)
+			}
+			data, err := os.ReadFile(*enqueueFile)
+			if err != nil {
+				log.Fatalf("Failed to read file: %v", err)
+			}
+			payload = data
+		} else if *enqueueFile != "" {
 			data, err := os.ReadFile(*enqueueFile)
 			if err != nil {
 				log.Fatalf("Failed to read file: %v", err)
@@ -78,18 +307,20 @@ func main() {
 			log.Fatal("Either -file or -json must be provided")
 		}
 
+		requires, err := parsePairs(*enqueueRequires)
+		if err != nil {
+			log.Fatalf("Invalid -requires: %v", err)
+		}
+
 		// Create a queue and enqueue the item
 		q := queue.New(db, *queueNameFlag)
 
-		var id int64
-		var err error
-
-		if *enqueueDelay > 0 {
-			id, err = q.EnqueueWithDelay(payload, *enqueueDelay)
-		} else {
-			id, err = q.Enqueue(payload)
-		}
-
+		id, err := q.EnqueueWithOptions(payload, queue.EnqueueOptions{
+			Delay:       *enqueueDelay,
+			Priority:    *enqueuePriority,
+			Requires:    requires,
+			ContentType: *enqueueContentType,
+		})
 		if err != nil {
 			log.Fatalf("Failed to enqueue item: %v", err)
 		}
@@ -99,61 +330,511 @@ func main() {
 	case "list":
 		listCmd.Parse(flag.Args()[1:])
 
-		// Build the query
-		query := `
-			SELECT id, queue_name, payload, created_at, scheduled_at, status, attempts, last_attempt_at
-			FROM queue_items
-			WHERE queue_name = ?
-		`
-		args := []any{*queueNameFlag}
+		columns, err := parseColumns(*listColumnsFlag)
+		if err != nil {
+			log.Fatalf("Invalid -columns: %v", err)
+		}
+		if columns != nil && *listJSON {
+			log.Fatal("-columns cannot be combined with -json")
+		}
+
+		now := time.Now()
+		since, err := parseTimeFilter(*listSince, now)
+		if err != nil {
+			log.Fatalf("Invalid -since: %v", err)
+		}
+		before, err := parseTimeFilter(*listBefore, now)
+		if err != nil {
+			log.Fatalf("Invalid -before: %v", err)
+		}
+
+		q := queue.New(db, *queueNameFlag)
+
+		if *listAll {
+			if len(dbPathFlag.paths) > 1 {
+				log.Fatal("-all cannot be combined with multiple -db flags")
+			}
+			if *listSort != "" || *listReverse {
+				log.Fatal("-sort and -reverse cannot be combined with -all")
+			}
+			if *listSince != "" || *listBefore != "" || *listScheduledWithin != 0 {
+				log.Fatal("-since, -before and -scheduled-within cannot be combined with -all")
+			}
 
-		if *listStatus != "" {
-			query += " AND status = ?"
-			args = append(args, *listStatus)
+			// Stream through every item without loading them all in memory
+			if !*listJSON {
+				printListHeader(*queueNameFlag, columns, false)
+			}
+			err := q.Each(*listStatus, func(item *queue.QueueItem) error {
+				item = redactItem(redactor, item)
+				if *listJSON {
+					return printItemJSON(item, "")
+				}
+				printItemRow(q, item, columns, "")
+				return nil
+			})
+			if err != nil {
+				log.Fatalf("Failed to list items: %v", err)
+			}
+			break
 		}
 
-		query += " ORDER BY id DESC LIMIT ?"
-		args = append(args, *listLimit)
+		// Newest items first unless sorting by another column
+		opts := queue.ListOptions{
+			Status:          *listStatus,
+			Since:           since,
+			Before:          before,
+			ScheduledWithin: *listScheduledWithin,
+			Sort:            *listSort,
+			Descending:      *listSort == "" || *listSort == "id",
+			Limit:           *listLimit,
+		}
+		if *listReverse {
+			opts.Descending = !opts.Descending
+		}
 
-		// Execute the query
-		rows, err := db.Query(query, args...)
+		if len(dbPathFlag.paths) > 1 {
+			// Combine the databases into one listing labelled by source
+			items, err := listFederated(dbPathFlag.paths, *queueNameFlag, opts)
+			if err != nil {
+				log.Fatalf("Failed to list items: %v", err)
+			}
+			if !*listJSON {
+				printListHeader(*queueNameFlag, columns, true)
+			}
+			for _, sourced := range items {
+				sourced.item = redactItem(redactor, sourced.item)
+				if *listJSON {
+					if err := printItemJSON(sourced.item, sourced.source); err != nil {
+						log.Fatalf("Failed to print item: %v", err)
+					}
+					continue
+				}
+				// The databases are closed by now, so notes are not shown
+				printItemRow(nil, sourced.item, columns, sourced.source)
+			}
+			break
+		}
+
+		items, err := q.List(opts)
 		if err != nil {
-			log.Fatalf("Failed to query database: %v", err)
+			log.Fatalf("Failed to list items: %v", err)
 		}
-		defer rows.Close()
 
 		// Print the results
-		fmt.Printf("Items in queue '%s':\n", *queueNameFlag)
-		fmt.Println("ID\tStatus\tAttempts\tCreated At\tScheduled At\tPayload")
-		fmt.Println("--\t------\t--------\t----------\t------------\t-------")
+		if !*listJSON {
+			printListHeader(*queueNameFlag, columns, false)
+		}
+		for _, item := range items {
+			item = redactItem(redactor, item)
+			if *listJSON {
+				if err := printItemJSON(item, ""); err != nil {
+					log.Fatalf("Failed to print item: %v", err)
+				}
+				continue
+			}
+			printItemRow(q, item, columns, "")
+		}
+
+	case "show":
+		showCmd.Parse(flag.Args()[1:])
+
+		q := queue.New(db, *queueNameFlag)
+		var (
+			item *queue.QueueItem
+			err  error
+		)
+		if *showExternalID != "" {
+			item, err = q.GetByExternalID(*showExternalID)
+		} else {
+			item, err = q.Get(*showID)
+		}
+		if err != nil {
+			log.Fatalf("Failed to get item: %v", err)
+		}
+		annotations, err := q.Annotations(item.ID)
+		if err != nil {
+			log.Fatalf("Failed to get annotations: %v", err)
+		}
+		printItemDetails(redactItem(redactor, item), annotations)
+
+	case "annotate":
+		annotateCmd.Parse(flag.Args()[1:])
+
+		if *annotateID == 0 || *annotateNote == "" {
+			log.Fatal("-id and -note are required")
+		}
+		q := queue.New(db, *queueNameFlag)
+		if err := q.Annotate(*annotateID, *annotateNote); err != nil {
+			log.Fatalf("Failed to annotate item: %v", err)
+		}
+		fmt.Printf("Annotated item %d\n", *annotateID)
 
-		for rows.Next() {
-			var item queue.QueueItem
-			if err := rows.Scan(
-				&item.ID, &item.QueueName, &item.Payload, &item.CreatedAt,
-				&item.ScheduledAt, &item.Status, &item.Attempts, &item.LastAttemptAt,
-			); err != nil {
-				log.Fatalf("Failed to scan row: %v", err)
+	case "reset-attempts":
+		resetCmd.Parse(flag.Args()[1:])
+
+		q := queue.New(db, *queueNameFlag)
+		switch {
+		case *resetStatus != "" && *resetIDs == "":
+			n, err := q.ResetAttemptsByStatus(*resetStatus)
+			if err != nil {
+				log.Fatalf("Failed to reset attempts: %v", err)
+			}
+			fmt.Printf("Reset the attempts of %d %s items in queue '%s'\n", n, *resetStatus, *queueNameFlag)
+		case *resetIDs != "" && *resetStatus == "":
+			ids, err := parseIDs(*resetIDs)
+			if err != nil {
+				log.Fatalf("Invalid -ids: %v", err)
+			}
+			if err := q.ResetAttempts(ids...); err != nil {
+				log.Fatalf("Failed to reset attempts: %v", err)
 			}
+			fmt.Printf("Reset the attempts of %d items in queue '%s'\n", len(ids), *queueNameFlag)
+		default:
+			log.Fatal("Usage: laqueue reset-attempts -ids ID[,ID...] | -status STATUS")
+		}
 
-			// Pretty print the payload
-			var prettyPayload interface{}
-			json.Unmarshal(item.Payload, &prettyPayload)
-			payloadBytes, _ := json.MarshalIndent(prettyPayload, "", "  ")
+	case "pause":
+		pauseCmd.Parse(flag.Args()[1:])
 
-			fmt.Printf("%d\t%s\t%d\t%s\t%s\t%s\n",
-				item.ID,
-				item.Status,
-				item.Attempts,
-				item.CreatedAt.Format("2006-01-02 15:04:05"),
-				item.ScheduledAt.Format("2006-01-02 15:04:05"),
-				string(payloadBytes),
-			)
+		q := queue.New(db, *queueNameFlag)
+		switch {
+		case *pauseList:
+			pauses, err := q.Pauses()
+			if err != nil {
+				log.Fatalf("Failed to list pause windows: %v", err)
+			}
+			printPauses(*queueNameFlag, pauses)
+		case *pauseDelete != 0:
+			if err := q.DeletePause(*pauseDelete); err != nil {
+				log.Fatalf("Failed to delete pause window: %v", err)
+			}
+			fmt.Printf("Deleted pause window %d of queue '%s'\n", *pauseDelete, *queueNameFlag)
+		default:
+			pause, err := newPauseWindow(*pauseFrom, *pauseFor, *pauseEvery)
+			if err != nil {
+				log.Fatalf("Invalid pause window: %v", err)
+			}
+			id, err := q.SchedulePause(pause)
+			if err != nil {
+				log.Fatalf("Failed to schedule pause window: %v", err)
+			}
+			fmt.Printf("Scheduled pause window %d of queue '%s'\n", id, *queueNameFlag)
+		}
+
+	case "replay":
+		replayCmd.Parse(flag.Args()[1:])
+
+		now := time.Now()
+		since, err := parseTimeFilter(*replaySince, now)
+		if err != nil {
+			log.Fatalf("Invalid -since: %v", err)
+		}
+		before, err := parseTimeFilter(*replayBefore, now)
+		if err != nil {
+			log.Fatalf("Invalid -before: %v", err)
+		}
+		target := *replayTo
+		if target == "" {
+			target = *queueNameFlag
 		}
 
-		if err := rows.Err(); err != nil {
-			log.Fatalf("Error iterating rows: %v", err)
+		n, err := replay(db, replayOptions{
+			Source: *replayFrom,
+			From:   *queueNameFlag,
+			To:     target,
+			List: queue.ListOptions{
+				Status: *replayStatus,
+				Since:  since,
+				Before: before,
+				Limit:  *replayLimit,
+			},
+			Rate:   *replayRate,
+			DryRun: *replayDryRun,
+		})
+		if err != nil {
+			log.Fatalf("Failed to replay items: %v", err)
 		}
+		if *replayDryRun {
+			fmt.Printf("%d items would be replayed into queue '%s'\n", n, target)
+		} else {
+			fmt.Printf("Replayed %d items into queue '%s'\n", n, target)
+		}
+
+	case "boost":
+		boostCmd.Parse(flag.Args()[1:])
+
+		q := queue.New(db, *queueNameFlag)
+		if err := q.Boost(*boostID); err != nil {
+			log.Fatalf("Failed to boost item: %v", err)
+		}
+
+		fmt.Printf("Item %d moved to the front of queue '%s'\n", *boostID, *queueNameFlag)
+
+	case "doctor":
+		doctorCmd.Parse(flag.Args()[1:])
+
+		violations, err := queue.Invariants(db)
+		if err != nil {
+			log.Fatalf("Failed to check database: %v", err)
+		}
+		if len(violations) == 0 {
+			fmt.Println("No problems found")
+			break
+		}
+		for _, v := range violations {
+			fmt.Println(v)
+		}
+		fmt.Printf("%d problems found\n", len(violations))
+		os.Exit(1)
+
+	case "export":
+		exportCmd.Parse(flag.Args()[1:])
+
+		queueName := *queueNameFlag
+		if *exportAll {
+			queueName = ""
+		}
+		n, err := exportArchive(db, queueName, *exportOut)
+		if err != nil {
+			log.Fatalf("Failed to export items: %v", err)
+		}
+		log.Printf("Exported %d items", n)
+
+	case "import":
+		importCmd.Parse(flag.Args()[1:])
+
+		n, err := importArchive(db, *importIn, queue.OnConflict(*importOnConflict))
+		if err != nil {
+			log.Fatalf("Failed to import items: %v", err)
+		}
+		fmt.Printf("Imported %d items\n", n)
+
+	case "reschedule":
+		rescheduleCmd.Parse(flag.Args()[1:])
+
+		filter, change, err := rescheduleArgs(*rescheduleFrom, *rescheduleUntil, *rescheduleSelect, *rescheduleAt, *rescheduleShift)
+		if err != nil {
+			log.Fatalf("Invalid reschedule: %v", err)
+		}
+		n, err := queue.New(db, *queueNameFlag).RescheduleWhere(filter, change)
+		if err != nil {
+			log.Fatalf("Failed to reschedule items: %v", err)
+		}
+		fmt.Printf("Rescheduled %d items of queue '%s'\n", n, *queueNameFlag)
+
+	case "rates":
+		ratesCmd.Parse(flag.Args()[1:])
+
+		rates, err := queue.New(db, *queueNameFlag).Rates(*ratesWindow)
+		if err != nil {
+			log.Fatalf("Failed to compute rates: %v", err)
+		}
+		printRates(*queueNameFlag, rates)
+
+		firing := false
+		for _, state := range rates.Evaluate(ratesAlarms...) {
+			fmt.Println(state)
+			firing = firing || state.Firing
+		}
+		if firing {
+			os.Exit(1)
+		}
+
+	case "snapshot":
+		snapshotCmd.Parse(flag.Args()[1:])
+
+		q := queue.New(db, *queueNameFlag)
+		if *snapshotList {
+			since, err := parseTimeFilter(*snapshotSince, time.Now())
+			if err != nil {
+				log.Fatalf("Invalid -since: %v", err)
+			}
+			snapshots, err := q.Snapshots(since)
+			if err != nil {
+				log.Fatalf("Failed to list snapshots: %v", err)
+			}
+			printSnapshots(*queueNameFlag, snapshots)
+		} else {
+			snapshot, err := q.FreezeSnapshot()
+			if err != nil {
+				log.Fatalf("Failed to freeze snapshot: %v", err)
+			}
+			printSnapshots(*queueNameFlag, []queue.Snapshot{*snapshot})
+		}
+
+	case "stats":
+		statsCmd.Parse(flag.Args()[1:])
+
+		now := time.Now()
+		since, err := parseTimeFilter(*statsSince, now)
+		if err != nil {
+			log.Fatalf("Invalid -since: %v", err)
+		}
+		before, err := parseTimeFilter(*statsBefore, now)
+		if err != nil {
+			log.Fatalf("Invalid -before: %v", err)
+		}
+
+		stats, err := countFederated(dbPathFlag.paths, *queueNameFlag, queue.ListOptions{
+			Since:           since,
+			Before:          before,
+			ScheduledWithin: *statsScheduledWithin,
+		})
+		if err != nil {
+			log.Fatalf("Failed to count items: %v", err)
+		}
+		printStats(*queueNameFlag, stats)
+
+	case "purge":
+		purgeCmd.Parse(flag.Args()[1:])
+
+		opts, err := purgeOptions(*purgeSince, *purgeBefore, *purgeStatus, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, err := queue.New(db, *queueNameFlag).PurgeWithOptions(opts)
+		if err != nil {
+			log.Fatalf("Failed to purge items: %v", err)
+		}
+		fmt.Printf("Purged %d items from queue '%s'\n", n, *queueNameFlag)
+
+	case "lanes":
+		lanesCmd.Parse(flag.Args()[1:])
+
+		lanes, err := queue.New(db, *queueNameFlag).Lanes(time.Now().Add(-*lanesWindow))
+		if err != nil {
+			log.Fatalf("Failed to read lanes: %v", err)
+		}
+		printLanes(*queueNameFlag, lanes)
+
+	case "apply":
+		applyCmd.Parse(flag.Args()[1:])
+
+		file, err := loadQueuesFile(*applyFile)
+		if err != nil {
+			log.Fatalf("Failed to load queue definitions: %v", err)
+		}
+		if err := applyQueues(db, file); err != nil {
+			log.Fatalf("Failed to apply queue definitions: %v", err)
+		}
+
+	case "diff":
+		diffCmd.Parse(flag.Args()[1:])
+
+		if diffCmd.NArg() != 2 {
+			log.Fatal("Usage: laqueue diff [-queue NAME] [-ids] BEFORE.db AFTER.db")
+		}
+
+		diff, err := diffSnapshots(diffCmd.Arg(0), diffCmd.Arg(1), *diffQueue)
+		if err != nil {
+			log.Fatalf("Failed to compare snapshots: %v", err)
+		}
+		printDiff(diff, *diffIDs)
+
+	case "bench":
+		benchCmd.Parse(flag.Args()[1:])
+
+		fmt.Printf("Running benchmark: %d items, %d producers, %d workers\n", *benchItems, *benchProducers, *benchWorkers)
+
+		result, err := runBench(*benchDir, *benchProducers, *benchWorkers, *benchItems)
+		if err != nil {
+			log.Fatalf("Benchmark failed: %v", err)
+		}
+
+		fmt.Printf("Enqueue:    %v (%.0f items/s)\n", result.EnqueueTime.Round(time.Millisecond), result.EnqueueRate)
+		fmt.Printf("End-to-end: %v (%.0f items/s)\n", result.TotalTime.Round(time.Millisecond), result.ProcessRate)
+		if result.EnqueueError > 0 || result.DequeueError > 0 || result.ProcessError > 0 {
+			fmt.Printf("Errors:     %d enqueue, %d dequeue (retried), %d processing\n", result.EnqueueError, result.DequeueError, result.ProcessError)
+		}
+
+	case "relay":
+		relayCmd.Parse(flag.Args()[1:])
+		if *relayTable == "" {
+			log.Fatal("-table is required")
+		}
+		if *relayBatch < 1 {
+			log.Fatal("-batch must be at least 1")
+		}
+
+		m, err := loadRelayMap(*relayMapFile)
+		if err != nil {
+			log.Fatalf("Failed to load mapping: %v", err)
+		}
+		r, err := newRelay(db, *relayTable, m)
+		if err != nil {
+			log.Fatalf("Failed to set up relay: %v", err)
+		}
+		defer r.close()
+
+		if *relayOnce {
+			total := 0
+			for {
+				n, err := r.relayOnce(*relayBatch)
+				total += n
+				if err != nil {
+					log.Fatalf("Failed to relay rows after %d rows: %v", total, err)
+				}
+				if n < *relayBatch {
+					break
+				}
+			}
+			fmt.Printf("Relayed %d rows from %s\n", total, *relayTable)
+			break
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		log.Printf("Relaying %s, press Ctrl+C to stop", *relayTable)
+		r.run(ctx, *relayInterval, *relayBatch)
+
+	case "replicate":
+		replicateCmd.Parse(flag.Args()[1:])
+		if *replicateDisable {
+			if err := queue.DisableChangeLog(db); err != nil {
+				log.Fatalf("Failed to disable replication: %v", err)
+			}
+			fmt.Println("Disabled replication")
+			break
+		}
+		if *replicateListen != "" {
+			replica, err := queue.NewSQLiteReplica(db)
+			if err != nil {
+				log.Fatalf("Failed to set up standby database: %v", err)
+			}
+			log.Printf("Serving standby on %s", *replicateListen)
+			log.Fatal(http.ListenAndServe(*replicateListen, queue.ReplicaHandler(replica)))
+		}
+		if *replicateTo == "" {
+			log.Fatal("-to or -listen is required")
+		}
+		if *replicateBatch < 1 {
+			log.Fatal("-batch must be at least 1")
+		}
+
+		replica, closeReplica, err := openReplica(*replicateTo)
+		if err != nil {
+			log.Fatalf("Failed to set up standby: %v", err)
+		}
+		defer closeReplica()
+		r, err := queue.NewReplicator(db, replica)
+		if err != nil {
+			log.Fatalf("Failed to set up replication: %v", err)
+		}
+
+		if *replicateOnceFlag {
+			n, err := replicateOnce(r, *replicateBatch)
+			if err != nil {
+				log.Fatalf("Failed to replicate after %d changes: %v", n, err)
+			}
+			fmt.Printf("Replicated %d changes to %s\n", n, *replicateTo)
+			break
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		log.Printf("Replicating to %s, press Ctrl+C to stop", *replicateTo)
+		replicate(ctx, r, *replicateInterval, *replicateBatch)
 
 	default:
 		printUsage()
@@ -170,24 +851,70 @@ func printUsage() {
 	fmt.Println("  init                   Initialize the database")
 	fmt.Println("  enqueue -file FILE     Enqueue an item from a JSON file")
 	fmt.Println("  enqueue -json JSON     Enqueue an item from a JSON string")
+	fmt.Println("  enqueue -ndjson FILE [-dry-run] [-max-size N]")
+	fmt.Println("                         Enqueue one job per line of FILE, or only validate them")
 	fmt.Println("  list                   List items in the queue")
+	fmt.Println("  list -all -json        Stream every item of the queue as JSON lines")
+	fmt.Println("  list -sort attempts -columns id,status,payload.user")
+	fmt.Println("                         Sort the listing and pick its columns")
+	fmt.Println("  -db A.db -db B.db list Combine the listings of several database files")
+	fmt.Println("  list -since 1h -scheduled-within 10m")
+	fmt.Println("                         Filter the listing by creation or schedule time")
+	fmt.Println("  show -id ID            Show an item, including the worker holding it")
+	fmt.Println("  show -external-id ID   Show an item by its external ID")
+	fmt.Println("  annotate -id ID -note TEXT")
+	fmt.Println("                         Attach a note to an item, shown by show and list -columns notes")
+	fmt.Println("  reset-attempts -ids ID[,ID...]")
+	fmt.Println("                         Give items their full retry budget again")
+	fmt.Println("  pause -from DATE -for DURATION [-every PERIOD]")
+	fmt.Println("                         Schedule a window during which no item is claimed")
+	fmt.Println("  pause -list | -delete ID")
+	fmt.Println("                         List or delete the pause windows of the queue")
+	fmt.Println("  replay -since DATE [-from FILE] [-to QUEUE] [-rate N] [-dry-run]")
+	fmt.Println("                         Re-enqueue historical items for reprocessing")
+	fmt.Println("  boost -id ID           Move a pending item to the front of the queue")
+	fmt.Println("  doctor                 Check the consistency of the database")
+	fmt.Println("  export [-o FILE] [-all-queues]")
+	fmt.Println("                         Write the items to a compact binary archive")
+	fmt.Println("  import [-i FILE] [-on-conflict fail|skip|replace]")
+	fmt.Println("                         Insert the items of an archive, with new IDs")
+	fmt.Println("  reschedule [-from DATE] [-until DATE] [-select k=v] -shift 2h | -at DATE")
+	fmt.Println("                         Move the scheduled time of many pending items at once")
+	fmt.Println("  rates [-window 5m] [-alarm failed>10]")
+	fmt.Println("                         Show enqueue, completion and failure rates, and check alarms")
+	fmt.Println("  stats [-since 1h] [-before 24h] [-scheduled-within 10m]")
+	fmt.Println("                         Count the items of each status, per -db file and in total")
+	fmt.Println("  purge [-since 168h] [-before 24h] [-status failed]")
+	fmt.Println("                         Delete the items finished in a period, with their attempts and notes")
+	fmt.Println("  lanes [-window 1h]     Show the depth and claim latency of each priority")
+	fmt.Println("  snapshot               Record the item counts per status and the highest item ID")
+	fmt.Println("  snapshot -list [-since 168h]")
+	fmt.Println("                         Show the recorded snapshots, for trends and reconciliation")
+	fmt.Println("  diff BEFORE.db AFTER.db Compare the items of two database snapshots")
+	fmt.Println("  apply -f FILE          Store the queue settings defined in a YAML file")
+	fmt.Println("  init-project [-module PATH] DIR")
+	fmt.Println("                         Generate a runnable project using laqueue")
+	fmt.Println("  daemon -config FILE    Run the workers and enqueue endpoint defined in a YAML file")
+	fmt.Println("  relay -table TABLE -map FILE [-once]")
+	fmt.Println("                         Move the rows of an outbox table into queues as they are added")
+	fmt.Println("  replicate -to FILE|URL [-once] | -listen ADDR | -disable")
+	fmt.Println("                         Stream the items, settings and pauses to a warm standby database")
+	fmt.Println("  bench                  Measure queue throughput on this machine")
 }
 
-func initDatabase(db *sql.DB) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS queue_items (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			queue_name TEXT NOT NULL,
-			payload BLOB NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			scheduled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			status TEXT DEFAULT 'pending',
-			attempts INTEGER DEFAULT 0,
-			last_attempt_at TIMESTAMP,
-			UNIQUE(id, queue_name)
-		);
-		CREATE INDEX IF NOT EXISTS idx_queue_status ON queue_items (queue_name, status, scheduled_at);
-	`)
-	return err
+// parseIDs parses a comma-separated list of item IDs
+func parseIDs(value string) ([]int64, error) {
+	var ids []int64
+	for _, field := range strings.Split(value, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
 
+func initDatabase(db *sql.DB) error {
+	return queue.InitSchema(db)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// printPauses prints the pause windows of a queue as a table
+func printPauses(queueName string, pauses []queue.PauseWindow) {
+	fmt.Printf("Pause windows of queue '%s':\n", queueName)
+	fmt.Println("ID\tStart\tEnd\tEvery")
+	fmt.Println("--\t-----\t---\t-----")
+	for _, pause := range pauses {
+		every := "-"
+		if pause.Every > 0 {
+			every = pause.Every.String()
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\n",
+			pause.ID,
+			pause.Start.Format("2006-01-02 15:04:05"),
+			pause.End.Format("2006-01-02 15:04:05"),
+			every,
+		)
+	}
+}
+
+// newPauseWindow builds the pause window described by the flags of laqueue
+// pause: it starts at from (now if empty) and lasts for the given duration
+func newPauseWindow(from string, duration, every time.Duration) (queue.PauseWindow, error) {
+	start := time.Now()
+	if from != "" {
+		var err error
+		if start, err = parseDate(from); err != nil {
+			return queue.PauseWindow{}, err
+		}
+	}
+	if duration <= 0 {
+		return queue.PauseWindow{}, fmt.Errorf("-for is required")
+	}
+	return queue.PauseWindow{Start: start, End: start.Add(duration), Every: every}, nil
+}
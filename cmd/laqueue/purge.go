@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// purgeOptions returns the options of laqueue purge for its -since, -before
+// and -status flags, relative to now
+func purgeOptions(since, before, status string, now time.Time) (queue.PurgeOptions, error) {
+	// Purging the whole history takes an explicit bound
+	if since == "" && before == "" {
+		return queue.PurgeOptions{}, errors.New("-since or -before is required")
+	}
+
+	opts := queue.PurgeOptions{Status: status}
+	var err error
+	if opts.Since, err = parseTimeFilter(since, now); err != nil {
+		return opts, fmt.Errorf("invalid -since: %w", err)
+	}
+	if opts.Before, err = parseTimeFilter(before, now); err != nil {
+		return opts, fmt.Errorf("invalid -before: %w", err)
+	}
+	return opts, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+func TestPurgeOptions(t *testing.T) {
+	now := time.Now()
+
+	if _, err := purgeOptions("", "", "", now); err == nil {
+		t.Error("Expected an error without -since or -before")
+	}
+	if _, err := purgeOptions("yesterday", "", "", now); err == nil {
+		t.Error("Expected an error for an invalid -since")
+	}
+	if _, err := purgeOptions("", "soon", "", now); err == nil {
+		t.Error("Expected an error for an invalid -before")
+	}
+
+	opts, err := purgeOptions("48h", "24h", queue.StatusFailed, now)
+	if err != nil {
+		t.Fatalf("Failed to parse options: %v", err)
+	}
+	if !opts.Since.Equal(now.Add(-48*time.Hour)) || !opts.Before.Equal(now.Add(-24*time.Hour)) || opts.Status != queue.StatusFailed {
+		t.Errorf("Unexpected options: %+v", opts)
+	}
+
+	// The options select items like laqueue list does
+	db := setupTestDB(t)
+	q := queue.New(db, "jobs")
+	defer q.Close()
+	for _, fail := range []bool{false, true} {
+		id, err := q.Enqueue("job")
+		if err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		if _, err := q.Dequeue(); err != nil {
+			t.Fatalf("Failed to dequeue item: %v", err)
+		}
+		if fail {
+			err = q.Fail(id)
+		} else {
+			err = q.Complete(id)
+		}
+		if err != nil {
+			t.Fatalf("Failed to finish item: %v", err)
+		}
+	}
+
+	opts, err = purgeOptions("1h", "", queue.StatusFailed, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to parse options: %v", err)
+	}
+	n, err := q.PurgeWithOptions(opts)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 item purged, got %d (err=%v)", n, err)
+	}
+	counts, err := q.Counts()
+	if err != nil {
+		t.Fatalf("Failed to count items: %v", err)
+	}
+	if len(counts) != 1 || counts[queue.StatusCompleted] != 1 {
+		t.Errorf("Expected only the completed item left, got %v", counts)
+	}
+}
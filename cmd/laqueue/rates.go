@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// alarmFlags collects the repeatable -alarm flag of laqueue rates
+type alarmFlags []queue.Alarm
+
+func (a *alarmFlags) String() string {
+	return fmt.Sprint(*a)
+}
+
+// Set parses an alarm of the form RATE>N or RATE<N, in items per minute,
+// e.g. failed>10
+func (a *alarmFlags) Set(value string) error {
+	i := strings.IndexAny(value, "<>")
+	if i < 0 {
+		return fmt.Errorf("%q is not of the form RATE>N or RATE<N", value)
+	}
+
+	rate := queue.Rate(strings.TrimSpace(value[:i]))
+	switch rate {
+	case queue.RateEnqueued, queue.RateCompleted, queue.RateFailed, queue.RateErrors:
+	default:
+		return fmt.Errorf("unknown rate %q", rate)
+	}
+	bound, err := strconv.ParseFloat(strings.TrimSpace(value[i+1:]), 64)
+	if err != nil || bound <= 0 {
+		return fmt.Errorf("%q is not a positive rate per minute", value[i+1:])
+	}
+
+	alarm := queue.Alarm{Name: value, Rate: rate}
+	if value[i] == '>' {
+		alarm.Above = bound
+	} else {
+		alarm.Below = bound
+	}
+	*a = append(*a, alarm)
+	return nil
+}
+
+// printRates prints the rates of a queue per minute
+func printRates(queueName string, rates queue.Rates) {
+	fmt.Printf("Rates of queue '%s' over the last %v:\n", queueName, rates.Window)
+	for _, rate := range []queue.Rate{queue.RateEnqueued, queue.RateCompleted, queue.RateFailed, queue.RateErrors} {
+		fmt.Printf("  %-10s %.2f/min\n", rate, rates.PerMinute(rate))
+	}
+}
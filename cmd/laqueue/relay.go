@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+	"gopkg.in/yaml.v3"
+)
+
+// relayMap maps the columns of an outbox table to queue items, as read from
+// the -map file of laqueue relay, e.g.
+//
+//	queue_column: topic
+//	payload: body
+//	metadata:
+//	  trace_id: trace
+type relayMap struct {
+	// ID is the column ordering the rows, an increasing integer. Defaults to
+	// the rowid.
+	ID string `yaml:"id"`
+
+	// Queue is the queue of every row, unless QueueColumn names the column
+	// holding the queue of each row
+	Queue       string `yaml:"queue"`
+	QueueColumn string `yaml:"queue_column"`
+
+	// Payload is the column holding the JSON payload. Defaults to payload.
+	Payload string `yaml:"payload"`
+
+	// Optional columns holding the delay in seconds and the options of the
+	// item. NULL values leave the option unset.
+	Delay    string `yaml:"delay"`
+	Priority string `yaml:"priority"`
+	LockKey  string `yaml:"lock_key"`
+	GroupKey string `yaml:"group_key"`
+	DedupKey string `yaml:"dedup_key"`
+
+	// Metadata maps metadata keys to the columns holding their value
+	Metadata map[string]string `yaml:"metadata"`
+
+	// Delete removes the relayed rows from the outbox. Otherwise, the last
+	// relayed ID is remembered in the queue_relays table.
+	Delete bool `yaml:"delete"`
+}
+
+// loadRelayMap reads and validates a relay mapping file
+func loadRelayMap(path string) (*relayMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var m relayMap
+	if err := decoder.Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if (m.Queue == "") == (m.QueueColumn == "") {
+		return nil, fmt.Errorf("%s: exactly one of queue and queue_column is required", path)
+	}
+	if m.ID == "" {
+		m.ID = "rowid"
+	}
+	if m.Payload == "" {
+		m.Payload = "payload"
+	}
+	return &m, nil
+}
+
+// relaySchema holds the positions of the outboxes relayed without Delete
+const relaySchema = `
+	CREATE TABLE IF NOT EXISTS queue_relays (
+		outbox TEXT PRIMARY KEY,
+		position INTEGER NOT NULL
+	)
+`
+
+// relay moves the rows of an outbox table into queues
+type relay struct {
+	db     *sql.DB
+	table  string
+	m      *relayMap
+	query  string
+	queues map[string]*queue.LaQueue
+}
+
+// newRelay returns a relay of table according to m
+func newRelay(db *sql.DB, table string, m *relayMap) (*relay, error) {
+	if _, err := db.Exec(relaySchema); err != nil {
+		return nil, err
+	}
+
+	queueExpr := quoteString(m.Queue)
+	if m.QueueColumn != "" {
+		queueExpr = quoteIdent(m.QueueColumn)
+	}
+	columns := []string{quoteIdent(m.ID), queueExpr, quoteIdent(m.Payload)}
+	for _, column := range []string{m.Delay, m.Priority, m.LockKey, m.GroupKey, m.DedupKey} {
+		columns = append(columns, optionalColumn(column))
+	}
+	for _, key := range metadataKeys(m) {
+		columns = append(columns, quoteIdent(m.Metadata[key]))
+	}
+
+	query := `SELECT ` + strings.Join(columns, ", ") + ` FROM ` + quoteIdent(table) +
+		` WHERE ` + quoteIdent(m.ID) + ` > ? ORDER BY ` + quoteIdent(m.ID) + ` LIMIT ?`
+	return &relay{db: db, table: table, m: m, query: query, queues: make(map[string]*queue.LaQueue)}, nil
+}
+
+// relayOnce relays up to limit rows in a single transaction, so that rows
+// are enqueued exactly once, and returns how many it relayed. Rows whose
+// dedup key is already taken in their queue, e.g. relayed by an earlier run
+// that lost its position, count as relayed. A row that can't be enqueued,
+// e.g. because its payload isn't JSON, stops the relay until it is fixed.
+func (r *relay) relayOnce(limit int) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var position int64
+	if !r.m.Delete {
+		err := tx.QueryRow(`SELECT position FROM queue_relays WHERE outbox = ?`, r.table).Scan(&position)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
+
+	rows, err := tx.Query(r.query, position, limit)
+	if err != nil {
+		return 0, err
+	}
+	type outboxRow struct {
+		id      int64
+		queue   string
+		payload []byte
+		opts    queue.EnqueueOptions
+	}
+	var batch []outboxRow
+	keys := metadataKeys(r.m)
+	for rows.Next() {
+		var (
+			row                         outboxRow
+			queueName                   sql.NullString
+			delay, priority             sql.NullInt64
+			lockKey, groupKey, dedupKey sql.NullString
+			values                      = make([]sql.NullString, len(keys))
+		)
+		dest := []any{&row.id, &queueName, &row.payload, &delay, &priority, &lockKey, &groupKey, &dedupKey}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		row.queue = queueName.String
+		row.opts = queue.EnqueueOptions{
+			Delay:    time.Duration(delay.Int64) * time.Second,
+			Priority: int(priority.Int64),
+			LockKey:  lockKey.String,
+			GroupKey: groupKey.String,
+			DedupKey: dedupKey.String,
+		}
+		for i, key := range keys {
+			if values[i].Valid {
+				if row.opts.Metadata == nil {
+					row.opts.Metadata = make(map[string]string)
+				}
+				row.opts.Metadata[key] = values[i].String
+			}
+		}
+		batch = append(batch, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	for _, row := range batch {
+		if row.queue == "" {
+			return 0, fmt.Errorf("row %d: no queue", row.id)
+		}
+		if !json.Valid(row.payload) {
+			return 0, fmt.Errorf("row %d: payload is not valid JSON", row.id)
+		}
+		q := r.queues[row.queue]
+		if q == nil {
+			q = queue.New(r.db, row.queue)
+			r.queues[row.queue] = q
+		}
+		_, err := q.EnqueueTx(tx, json.RawMessage(row.payload), row.opts)
+		if err != nil && !errors.Is(err, queue.ErrDuplicate) {
+			return 0, fmt.Errorf("row %d: %w", row.id, err)
+		}
+	}
+
+	last := batch[len(batch)-1].id
+	if r.m.Delete {
+		_, err = tx.Exec(`DELETE FROM `+quoteIdent(r.table)+` WHERE `+quoteIdent(r.m.ID)+` <= ?`, last)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO queue_relays (outbox, position) VALUES (?, ?)
+			ON CONFLICT (outbox) DO UPDATE SET position = excluded.position
+		`, r.table, last)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(batch), tx.Commit()
+}
+
+// run relays rows as they are added to the outbox until ctx is done,
+// checking for new rows every interval once the outbox is drained
+func (r *relay) run(ctx context.Context, interval time.Duration, limit int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.relayOnce(limit)
+		if err != nil {
+			log.Printf("Error relaying %s: %v", r.table, err)
+		} else if n > 0 {
+			log.Printf("Relayed %d rows from %s", n, r.table)
+		}
+
+		if err != nil || n < limit {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// close releases the resources of the queues the relay enqueued into
+func (r *relay) close() {
+	for _, q := range r.queues {
+		q.Close()
+	}
+}
+
+// metadataKeys returns the metadata keys of m, sorted
+func metadataKeys(m *relayMap) []string {
+	keys := make([]string, 0, len(m.Metadata))
+	for key := range m.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// optionalColumn returns the quoted column, or NULL if it isn't mapped
+func optionalColumn(column string) string {
+	if column == "" {
+		return "NULL"
+	}
+	return quoteIdent(column)
+}
+
+// quoteIdent quotes an SQL identifier
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteString quotes an SQL string literal
+func quoteString(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
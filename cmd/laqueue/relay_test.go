@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+func setupTestDB(t testing.TB) *sql.DB {
+	f, err := os.CreateTemp("", "laqueue_cli_test_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	db, err := sql.Open("sqlite3", f.Name())
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := queue.InitSchema(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	return db
+}
+
+func TestRelay(t *testing.T) {
+	db := setupTestDB(t)
+	if _, err := db.Exec(`CREATE TABLE outbox (id INTEGER PRIMARY KEY, body TEXT NOT NULL, dedup TEXT)`); err != nil {
+		t.Fatalf("Failed to create outbox: %v", err)
+	}
+	insert := func(body, dedup string) {
+		if _, err := db.Exec(`INSERT INTO outbox (body, dedup) VALUES (?, NULLIF(?, ''))`, body, dedup); err != nil {
+			t.Fatalf("Failed to insert row: %v", err)
+		}
+	}
+	m := &relayMap{ID: "id", Queue: "emails", Payload: "body", DedupKey: "dedup"}
+	relayOnce := func(want int) {
+		t.Helper()
+		r, err := newRelay(db, "outbox", m)
+		if err != nil {
+			t.Fatalf("Failed to set up relay: %v", err)
+		}
+		defer r.close()
+		if n, err := r.relayOnce(10); err != nil || n != want {
+			t.Fatalf("Expected %d rows relayed, got %d, %v", want, n, err)
+		}
+	}
+	q := queue.New(db, "emails")
+	defer q.Close()
+
+	insert(`{"to": "a"}`, "a")
+	insert(`{"to": "b"}`, "")
+	relayOnce(2)
+	if size, _ := q.Size(); size != 2 {
+		t.Errorf("Expected 2 items, got %d", size)
+	}
+
+	// A new relay resumes after the rows already relayed
+	insert(`{"to": "c"}`, "")
+	relayOnce(1)
+	relayOnce(0)
+	if size, _ := q.Size(); size != 3 {
+		t.Errorf("Expected 3 items, got %d", size)
+	}
+
+	// A row whose dedup key was already enqueued is skipped, not retried
+	insert(`{"to": "a"}`, "a")
+	relayOnce(1)
+	relayOnce(0)
+	if size, _ := q.Size(); size != 3 {
+		t.Errorf("Expected the duplicate to be skipped, got %d items", size)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// replayOptions configures laqueue replay
+type replayOptions struct {
+	// Source is the database holding the historical items, e.g. an archived
+	// copy, upgraded to the current schema if needed. Items are replayed from
+	// the target database when empty.
+	Source string
+	From   string
+	To     string
+	List   queue.ListOptions
+
+	// Rate caps the number of items enqueued per second, zero means no limit
+	Rate   float64
+	DryRun bool
+}
+
+// replay re-enqueues historical items of opts.From into opts.To, keeping
+// their metadata, lock and group keys, priority and retry schedule. It
+// returns the number of items replayed, or that would be in a dry run.
+func replay(db *sql.DB, opts replayOptions) (int, error) {
+	source := db
+	if opts.Source != "" {
+		var err error
+		if source, err = sql.Open("sqlite3", opts.Source); err != nil {
+			return 0, err
+		}
+		defer source.Close()
+
+		// Archives written by older versions lack the newer columns
+		if err := initDatabase(source); err != nil {
+			return 0, err
+		}
+	}
+
+	from := queue.New(source, opts.From)
+	defer from.Close()
+	items, err := from.List(opts.List)
+	if err != nil {
+		return 0, err
+	}
+
+	to := queue.New(db, opts.To)
+	defer to.Close()
+
+	var throttle <-chan time.Time
+	if opts.Rate > 0 && !opts.DryRun {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	for i, item := range items {
+		if opts.DryRun {
+			fmt.Printf("Would replay item %d (%s, created %s)\n", item.ID, item.Status, item.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+			continue
+		}
+		if throttle != nil && i > 0 {
+			<-throttle
+		}
+
+		var payload any = json.RawMessage(item.Payload)
+		if item.ContentType != queue.ContentTypeJSON {
+			payload = item.Payload
+		}
+		id, err := to.EnqueueWithOptions(payload, queue.EnqueueOptions{
+			ContentType:   item.ContentType,
+			RetrySchedule: item.RetrySchedule,
+			LockKey:       item.LockKey,
+			Metadata:      item.Metadata,
+			Priority:      item.Priority,
+			GroupKey:      item.GroupKey,
+		})
+		if err != nil {
+			return i, fmt.Errorf("item %d: %w", item.ID, err)
+		}
+		fmt.Printf("Replayed item %d as %d\n", item.ID, id)
+	}
+
+	return len(items), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// openReplica returns the standby to replicate to: the standby served at to
+// if it is an HTTP URL, or else the database file at to, along with a
+// function releasing it
+func openReplica(to string) (queue.Replica, func(), error) {
+	if strings.HasPrefix(to, "http://") || strings.HasPrefix(to, "https://") {
+		return queue.NewHTTPReplica(to, nil), func() {}, nil
+	}
+
+	standby, err := sql.Open("sqlite3", to)
+	if err != nil {
+		return nil, nil, err
+	}
+	replica, err := queue.NewSQLiteReplica(standby)
+	if err != nil {
+		standby.Close()
+		return nil, nil, err
+	}
+	return replica, func() { standby.Close() }, nil
+}
+
+// replicateOnce sends the pending changes of the primary to the replica in
+// batches of limit, and returns how many were sent
+func replicateOnce(r *queue.Replicator, limit int) (int, error) {
+	total := 0
+	for {
+		n, err := r.ReplicateOnce(limit)
+		total += n
+		if err != nil || n < limit {
+			return total, err
+		}
+	}
+}
+
+// replicate streams the changes of the primary to the replica until ctx is
+// done, checking for new changes every interval
+func replicate(ctx context.Context, r *queue.Replicator, interval time.Duration, limit int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := replicateOnce(r, limit)
+		if err != nil {
+			log.Printf("Error replicating: %v", err)
+		} else if n > 0 {
+			log.Printf("Replicated %d changes", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
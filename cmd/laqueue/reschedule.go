@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// rescheduleArgs builds the filter and change of laqueue reschedule from its
+// flags
+func rescheduleArgs(from, until, selector, at string, shift time.Duration) (queue.RescheduleFilter, queue.Reschedule, error) {
+	var (
+		filter queue.RescheduleFilter
+		change queue.Reschedule
+		err    error
+	)
+	if from != "" {
+		if filter.From, err = parseDate(from); err != nil {
+			return filter, change, err
+		}
+	}
+	if until != "" {
+		if filter.Until, err = parseDate(until); err != nil {
+			return filter, change, err
+		}
+	}
+	if filter.Selector, err = parsePairs(selector); err != nil {
+		return filter, change, err
+	}
+
+	if (at == "") == (shift == 0) {
+		return filter, change, fmt.Errorf("either -at or -shift is required")
+	}
+	if at != "" {
+		if change.At, err = parseDate(at); err != nil {
+			return filter, change, err
+		}
+	}
+	change.Shift = shift
+	return filter, change, nil
+}
+
+// parsePairs parses key=value[,key=value...] into a map, or nil if s is empty
+func parsePairs(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not of the form key=value", pair)
+		}
+		pairs[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return pairs, nil
+}
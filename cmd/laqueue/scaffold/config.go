@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration file of the application
+type Config struct {
+	DB             string        `yaml:"db"`
+	Queue          string        `yaml:"queue"`
+	Interval       time.Duration `yaml:"interval"`
+	MinConcurrency int           `yaml:"min_concurrency"`
+	MaxConcurrency int           `yaml:"max_concurrency"`
+	MaxRetries     int           `yaml:"max_retries"`
+}
+
+// loadConfig reads the configuration file
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if config.DB == "" || config.Queue == "" {
+		return nil, fmt.Errorf("%s: db and queue are required", path)
+	}
+	return &config, nil
+}
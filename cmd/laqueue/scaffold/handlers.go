@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/nicotsx/laqueue/worker"
+)
+
+// Job is the payload of the items of the queue. Kind selects its handler.
+type Job struct {
+	Kind string `json:"kind"`
+	To   string `json:"to,omitempty"`
+}
+
+// Kinds of jobs
+const (
+	KindWelcomeEmail = "welcome_email"
+)
+
+// handlers maps each kind of job to its handler. Add new kinds here.
+var handlers = map[string]func(ctx context.Context, job Job) error{
+	KindWelcomeEmail: sendWelcomeEmail,
+}
+
+// handle decodes a job and runs the handler of its kind. Returning an error
+// retries the job with backoff, up to the configured max_retries.
+func handle(ctx context.Context, payload []byte) error {
+	var job Job
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return fmt.Errorf("invalid job: %w", err)
+	}
+	handler, ok := handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+	return handler(ctx, job)
+}
+
+// sendWelcomeEmail is an example handler
+func sendWelcomeEmail(ctx context.Context, job Job) error {
+	id, _ := worker.JobIDFromContext(ctx)
+	attempt, _ := worker.AttemptFromContext(ctx)
+	log.Printf("Sending welcome email to %s (job %d, attempt %d)", job.To, id, attempt)
+	return nil
+}
@@ -0,0 +1,78 @@
+// Command app is a starting point for a laqueue application, generated by
+// laqueue init-project. Run the worker with
+//
+//	go run . worker
+//
+// and enqueue jobs from another terminal with
+//
+//	go run . enqueue -to someone@example.com
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/nicotsx/laqueue/producer"
+	"github.com/nicotsx/laqueue/queue"
+	"github.com/nicotsx/laqueue/worker"
+)
+
+func main() {
+	configFile := flag.String("config", "config.yaml", "Configuration file")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Println("Usage: app [-config FILE] worker|enqueue [options]")
+		os.Exit(1)
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", config.DB)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if err := queue.InitSchema(db); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	switch flag.Arg(0) {
+	case "worker":
+		w := worker.NewContext(db, worker.Config{
+			QueueName:      config.Queue,
+			Interval:       config.Interval,
+			MinConcurrency: config.MinConcurrency,
+			MaxConcurrency: config.MaxConcurrency,
+			MaxRetries:     config.MaxRetries,
+		}, handle)
+
+		// Run until SIGINT/SIGTERM, then let in-flight jobs finish
+		worker.Run(context.Background(), w)
+
+	case "enqueue":
+		enqueueCmd := flag.NewFlagSet("enqueue", flag.ExitOnError)
+		to := enqueueCmd.String("to", "", "Recipient of the welcome email")
+		enqueueCmd.Parse(flag.Args()[1:])
+		if *to == "" {
+			log.Fatal("-to is required")
+		}
+
+		p := producer.NewLocal(db, config.Queue)
+		id, err := p.Enqueue(context.Background(), Job{Kind: KindWelcomeEmail, To: *to}, producer.Options{})
+		if err != nil {
+			log.Fatalf("Failed to enqueue job: %v", err)
+		}
+		fmt.Printf("Enqueued job %d\n", id)
+
+	default:
+		log.Fatalf("Unknown command %q", flag.Arg(0))
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// printItemDetails prints every field of an item, one per line, including
+// which worker holds it when it is processing, followed by its annotations
+func printItemDetails(item *queue.QueueItem, annotations []queue.Annotation) {
+	timestamp := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05")
+	}
+
+	fmt.Printf("ID:            %d\n", item.ID)
+	if item.ExternalID != "" {
+		fmt.Printf("External ID:   %s\n", item.ExternalID)
+	}
+	fmt.Printf("Queue:         %s\n", item.QueueName)
+	fmt.Printf("Status:        %s\n", item.Status)
+	fmt.Printf("Attempts:      %d\n", item.Attempts)
+	fmt.Printf("Created at:    %s\n", timestamp(&item.CreatedAt))
+	fmt.Printf("Scheduled at:  %s\n", timestamp(&item.ScheduledAt))
+	fmt.Printf("Last attempt:  %s\n", timestamp(item.LastAttemptAt))
+	fmt.Printf("Finished at:   %s\n", timestamp(item.FinishedAt))
+	if item.ClaimedBy != "" {
+		fmt.Printf("Claimed by:    %s\n", item.ClaimedBy)
+	}
+	if item.Status == queue.StatusProcessing {
+		fmt.Printf("Held for:      %s\n", heldFor(item, time.Now()))
+	}
+	if item.LockKey != "" {
+		fmt.Printf("Lock key:      %s\n", item.LockKey)
+	}
+	keys := make([]string, 0, len(item.Metadata))
+	for key := range item.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("Metadata:      %s=%s\n", key, item.Metadata[key])
+	}
+
+	if item.ContentType != queue.ContentTypeJSON {
+		fmt.Printf("Content type:  %s\n", item.ContentType)
+	}
+	fmt.Printf("Payload:\n%s\n", renderPayload(item))
+
+	if len(item.Checkpoint) > 0 {
+		fmt.Printf("Checkpoint:\n%s\n", item.Checkpoint)
+	}
+	if len(item.Result) > 0 {
+		fmt.Printf("Result:\n%s\n", item.Result)
+	}
+	for _, a := range annotations {
+		fmt.Printf("Note:          %s %s\n", timestamp(&a.CreatedAt), a.Note)
+	}
+}
+
+// renderPayload formats the payload of an item according to its content
+// type: JSON is indented, text is shown as is and anything else is dumped
+// in hexadecimal
+func renderPayload(item *queue.QueueItem) string {
+	switch {
+	case item.ContentType == queue.ContentTypeJSON:
+		var payload any
+		json.Unmarshal(item.Payload, &payload)
+		payloadBytes, _ := json.MarshalIndent(payload, "", "  ")
+		return string(payloadBytes)
+	case isText(item.ContentType):
+		return string(item.Payload)
+	}
+	return strings.TrimSuffix(hex.Dump(item.Payload), "\n")
+}
+
+// isText reports whether payloads of contentType are readable text
+func isText(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/")
+}
+
+// newRedactor returns the redactor selected by the -redact and
+// -redact-fields flags, or nil if redaction is disabled
+func newRedactor(enabled bool, fields string) queue.Redactor {
+	if !enabled && fields == "" {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(fields, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return queue.RedactFields(names...)
+}
+
+// redactItem returns a copy of item whose payload, result and checkpoint
+// went through redact, or item itself if redact is nil
+func redactItem(redact queue.Redactor, item *queue.QueueItem) *queue.QueueItem {
+	if redact == nil {
+		return item
+	}
+	redacted := *item
+	redacted.Payload = redact(item.Payload)
+	if len(item.Result) > 0 {
+		redacted.Result = redact(item.Result)
+	}
+	if len(item.Checkpoint) > 0 {
+		redacted.Checkpoint = redact(item.Checkpoint)
+	}
+	return &redacted
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// snapshotStatuses are the statuses shown by laqueue snapshot, in order
+var snapshotStatuses = []string{
+	queue.StatusPending,
+	queue.StatusProcessing,
+	queue.StatusCompleted,
+	queue.StatusFailed,
+	queue.StatusDraft,
+	queue.StatusCorrupt,
+}
+
+// printSnapshots prints the snapshots of a queue as a table, one column per
+// status
+func printSnapshots(queueName string, snapshots []queue.Snapshot) {
+	fmt.Printf("Snapshots of queue '%s':\n", queueName)
+	underlines := make([]string, len(snapshotStatuses))
+	for i, status := range snapshotStatuses {
+		underlines[i] = strings.Repeat("-", len(status))
+	}
+	fmt.Println("ID\tTaken At\tMax ID\t" + strings.Join(snapshotStatuses, "\t"))
+	fmt.Println("--\t--------\t------\t" + strings.Join(underlines, "\t"))
+	for _, snapshot := range snapshots {
+		counts := make([]string, len(snapshotStatuses))
+		for i, status := range snapshotStatuses {
+			counts[i] = strconv.Itoa(snapshot.Counts[status])
+		}
+		fmt.Printf("%d\t%s\t%d\t%s\n",
+			snapshot.ID,
+			snapshot.TakenAt.Format("2006-01-02 15:04:05"),
+			snapshot.MaxID,
+			strings.Join(counts, "\t"),
+		)
+	}
+}
+
+// printStats prints the number of items of each status of a queue, one row
+// per database file, followed by their total if there are several
+func printStats(queueName string, stats []sourcedCounts) {
+	fmt.Printf("Items in queue '%s' by status:\n", queueName)
+	underlines := make([]string, len(snapshotStatuses))
+	for i, status := range snapshotStatuses {
+		underlines[i] = strings.Repeat("-", len(status))
+	}
+	fmt.Println("DB\t" + strings.Join(snapshotStatuses, "\t") + "\ttotal")
+	fmt.Println("--\t" + strings.Join(underlines, "\t") + "\t-----")
+
+	totals := make(map[string]int)
+	printRow := func(source string, counts map[string]int) {
+		row := make([]string, len(snapshotStatuses))
+		total := 0
+		for i, status := range snapshotStatuses {
+			row[i] = strconv.Itoa(counts[status])
+			total += counts[status]
+		}
+		fmt.Printf("%s\t%s\t%d\n", source, strings.Join(row, "\t"), total)
+	}
+	for _, s := range stats {
+		printRow(s.source, s.counts)
+		for status, count := range s.counts {
+			totals[status] += count
+		}
+	}
+	if len(stats) > 1 {
+		printRow("total", totals)
+	}
+}
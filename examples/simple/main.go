@@ -6,12 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/nicotsx/laqueue/queue"
 	"github.com/nicotsx/laqueue/worker"
 )
 
@@ -30,38 +28,17 @@ func main() {
 	defer db.Close()
 
 	// Initialize the database tables
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS queue_items (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			queue_name TEXT NOT NULL,
-			payload BLOB NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			scheduled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			status TEXT DEFAULT 'pending',
-			attempts INTEGER DEFAULT 0,
-			last_attempt_at TIMESTAMP,
-			UNIQUE(id, queue_name)
-		);
-		CREATE INDEX IF NOT EXISTS idx_queue_status ON queue_items (queue_name, status, scheduled_at);
-	`)
-	if err != nil {
+	if err := queue.InitSchema(db); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
-	// Create a context that can be cancelled
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	// Set up a worker to process jobs from the "example" queue
-	w := worker.New(db, worker.Config{
+	w := worker.NewContext(db, worker.Config{
 		QueueName:  "example",
 		Interval:   2 * time.Second,
 		MaxRetries: 3,
 	}, processJob)
 
-	// Start the worker in a goroutine
-	go w.Start(ctx)
-
 	// Add some jobs to the queue
 	for i := 0; i < 5; i++ {
 		job := Job{
@@ -78,28 +55,19 @@ func main() {
 		}
 	}
 
-	// Set up signal handling for graceful shutdown
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
-
-	// Wait for signals
-	<-signalChan
-	log.Println("Received interrupt signal, shutting down...")
-	cancel()
-
-	// Allow some time for worker to finish processing
-	time.Sleep(1 * time.Second)
-	log.Println("Shutdown complete")
+	// Run the worker until interrupted, then let it finish in-flight jobs
+	worker.Run(context.Background(), w)
 }
 
 // processJob handles the job payload
-func processJob(payload []byte) error {
+func processJob(ctx context.Context, payload []byte) error {
 	var job Job
 	if err := json.Unmarshal(payload, &job); err != nil {
 		return fmt.Errorf("failed to unmarshal job: %w", err)
 	}
 
-	log.Printf("Processing job %s: %s", job.ID, job.Message)
+	attempt, _ := worker.AttemptFromContext(ctx)
+	log.Printf("Processing job %s (attempt %d): %s", job.ID, attempt, job.Message)
 
 	// Simulate some work
 	time.Sleep(500 * time.Millisecond)
@@ -112,4 +80,3 @@ func processJob(payload []byte) error {
 	log.Printf("Successfully processed job %s", job.ID)
 	return nil
 }
-
@@ -2,4 +2,7 @@ module github.com/nicotsx/laqueue
 
 go 1.24.1
 
-require github.com/mattn/go-sqlite3 v1.14.24
+require (
+	github.com/mattn/go-sqlite3 v1.14.24
+	gopkg.in/yaml.v3 v3.0.1
+)
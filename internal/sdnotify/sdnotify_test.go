@@ -0,0 +1,58 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Fatalf("Expected no notification without NOTIFY_SOCKET, got sent=%v err=%v", sent, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	if sent, err := Notify(Ready); !sent || err != nil {
+		t.Fatalf("Expected notification to be sent, got sent=%v err=%v", sent, err)
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read notification: %v", err)
+	}
+	if got := string(buf[:n]); got != Ready {
+		t.Errorf("Expected %q, got %q", Ready, got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	if _, ok := WatchdogInterval(); ok {
+		t.Error("Expected watchdog to be disabled")
+	}
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if d, ok := WatchdogInterval(); !ok || d != 30*time.Second {
+		t.Errorf("Expected 30s watchdog, got %v (enabled=%v)", d, ok)
+	}
+
+	// The watchdog is meant for another process
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if _, ok := WatchdogInterval(); ok {
+		t.Error("Expected watchdog to be disabled for another PID")
+	}
+}
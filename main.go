@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/nicotsx/laqueue/queue"
 )
 
 const (
@@ -43,20 +44,5 @@ func main() {
 
 // initDB creates the necessary tables if they don't exist
 func initDB(db *sql.DB) error {
-	// Create the queue table
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS queue_items (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			queue_name TEXT NOT NULL,
-			payload BLOB NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			scheduled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			status TEXT DEFAULT 'pending',
-			attempts INTEGER DEFAULT 0,
-			last_attempt_at TIMESTAMP,
-			UNIQUE(id, queue_name)
-		);
-		CREATE INDEX IF NOT EXISTS idx_queue_status ON queue_items (queue_name, status, scheduled_at);
-	`)
-	return err
+	return queue.InitSchema(db)
 } 
\ No newline at end of file
@@ -0,0 +1,73 @@
+// Package metrics defines the sink through which workers push their metrics,
+// with StatsD and OTLP implementations.
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sink receives metrics as they are produced
+type Sink interface {
+	// Count adds value to a counter
+	Count(name string, value int64, tags map[string]string)
+	// Gauge records the current value of a gauge
+	Gauge(name string, value float64, tags map[string]string)
+	// Timing records the duration of an operation
+	Timing(name string, d time.Duration, tags map[string]string)
+}
+
+// Metric names emitted by workers
+const (
+	// ItemsProcessed counts processed items, tagged with their outcome
+	// (completed, retried, failed, released or corrupt)
+	ItemsProcessed = "laqueue.items.processed"
+	// ItemDuration is the time spent in the handler
+	ItemDuration = "laqueue.item.duration"
+	// QueueDepth is the number of items ready to be claimed
+	QueueDepth = "laqueue.queue.depth"
+	// SlowItems counts items that ran much longer than expected
+	SlowItems = "laqueue.items.slow"
+	// LaneDepth is the number of items ready to be claimed, tagged with
+	// their priority
+	LaneDepth = "laqueue.lane.depth"
+	// LaneLatency is the average time, in seconds, that recently claimed
+	// items of a priority waited once eligible
+	LaneLatency = "laqueue.lane.latency"
+)
+
+// Nop is a Sink that discards all metrics
+type Nop struct{}
+
+// Count implements Sink
+func (Nop) Count(string, int64, map[string]string) {}
+
+// Gauge implements Sink
+func (Nop) Gauge(string, float64, map[string]string) {}
+
+// Timing implements Sink
+func (Nop) Timing(string, time.Duration, map[string]string) {}
+
+// sortedKeys returns the keys of tags in a stable order
+func sortedKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// seriesKey identifies a metric series by name and tags
+func seriesKey(name string, tags map[string]string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, k := range sortedKeys(tags) {
+		b.WriteByte('|')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(tags[k])
+	}
+	return b.String()
+}
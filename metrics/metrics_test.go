@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	sink, err := NewStatsD(conn.LocalAddr().String(), "app")
+	if err != nil {
+		t.Fatalf("Failed to create StatsD sink: %v", err)
+	}
+	defer sink.Close()
+
+	tests := []struct {
+		send func()
+		want string
+	}{
+		{func() { sink.Count(ItemsProcessed, 2, map[string]string{"queue": "emails", "outcome": "completed"}) },
+			"app.laqueue.items.processed:2|c|#outcome:completed,queue:emails"},
+		{func() { sink.Gauge(QueueDepth, 12, map[string]string{"queue": "emails"}) },
+			"app.laqueue.queue.depth:12|g|#queue:emails"},
+		{func() { sink.Timing(ItemDuration, 1500*time.Microsecond, nil) },
+			"app.laqueue.item.duration:1.5|ms"},
+	}
+
+	buf := make([]byte, 1024)
+	for _, tt := range tests {
+		tt.send()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Failed to read metric: %v", err)
+		}
+		if got := string(buf[:n]); got != tt.want {
+			t.Errorf("Expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestOTLPFlush(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode body: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	sink := NewOTLP(server.URL, "worker")
+	tags := map[string]string{"queue": "emails"}
+	sink.Count(ItemsProcessed, 1, tags)
+	sink.Count(ItemsProcessed, 2, tags)
+	sink.Gauge(QueueDepth, 5, tags)
+	sink.Timing(ItemDuration, 30*time.Millisecond, tags)
+
+	if err := sink.Flush(context.Background()); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	encoded, _ := json.Marshal(body)
+	for _, want := range []string{
+		`"name":"laqueue.items.processed"`,
+		`"asInt":"3"`,
+		`"asDouble":5`,
+		`"stringValue":"worker"`,
+		`"count":"1"`,
+	} {
+		if !strings.Contains(string(encoded), want) {
+			t.Errorf("Expected export to contain %s, got %s", want, encoded)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// timingBounds are the histogram bucket boundaries for timings, in seconds
+var timingBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// OTLP aggregates metrics in memory and periodically pushes them to an
+// OpenTelemetry collector using OTLP over HTTP with JSON encoding. Counters
+// and timings are exported as cumulative sums and histograms.
+type OTLP struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	start       time.Time
+
+	mu         sync.Mutex
+	counters   map[string]*counterPoint
+	gauges     map[string]*gaugePoint
+	histograms map[string]*histogramPoint
+}
+
+type counterPoint struct {
+	name  string
+	tags  map[string]string
+	value int64
+}
+
+type gaugePoint struct {
+	name  string
+	tags  map[string]string
+	value float64
+}
+
+type histogramPoint struct {
+	name    string
+	tags    map[string]string
+	count   uint64
+	sum     float64
+	buckets []uint64
+}
+
+// NewOTLP returns a sink pushing to the OTLP/HTTP metrics endpoint of a
+// collector, e.g. "http://localhost:4318/v1/metrics". Call Run to push
+// periodically, or Flush to push on demand.
+func NewOTLP(endpoint, serviceName string) *OTLP {
+	return &OTLP{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		start:       time.Now(),
+		counters:    make(map[string]*counterPoint),
+		gauges:      make(map[string]*gaugePoint),
+		histograms:  make(map[string]*histogramPoint),
+	}
+}
+
+// Count implements Sink
+func (o *OTLP) Count(name string, value int64, tags map[string]string) {
+	key := seriesKey(name, tags)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	p, ok := o.counters[key]
+	if !ok {
+		p = &counterPoint{name: name, tags: tags}
+		o.counters[key] = p
+	}
+	p.value += value
+}
+
+// Gauge implements Sink
+func (o *OTLP) Gauge(name string, value float64, tags map[string]string) {
+	key := seriesKey(name, tags)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.gauges[key] = &gaugePoint{name: name, tags: tags, value: value}
+}
+
+// Timing implements Sink
+func (o *OTLP) Timing(name string, d time.Duration, tags map[string]string) {
+	key := seriesKey(name, tags)
+	seconds := d.Seconds()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	p, ok := o.histograms[key]
+	if !ok {
+		p = &histogramPoint{name: name, tags: tags, buckets: make([]uint64, len(timingBounds)+1)}
+		o.histograms[key] = p
+	}
+	p.count++
+	p.sum += seconds
+
+	bucket := len(timingBounds)
+	for i, bound := range timingBounds {
+		if seconds <= bound {
+			bucket = i
+			break
+		}
+	}
+	p.buckets[bucket]++
+}
+
+// Run pushes metrics every interval until ctx is done, then pushes a last time
+func (o *OTLP) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			o.Flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			o.Flush(ctx)
+		}
+	}
+}
+
+// Flush pushes the current state of all metrics to the collector
+func (o *OTLP) Flush(ctx context.Context) error {
+	body, err := json.Marshal(o.export(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON encoding, see opentelemetry-proto's metrics.proto. 64-bit
+// integers are encoded as strings.
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsInt             *string         `json:"asInt,omitempty"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+	Count             string          `json:"count,omitempty"`
+	Sum               *float64        `json:"sum,omitempty"`
+	BucketCounts      []string        `json:"bucketCounts,omitempty"`
+	ExplicitBounds    []float64       `json:"explicitBounds,omitempty"`
+}
+
+type otlpMetric struct {
+	Name      string         `json:"name"`
+	Unit      string         `json:"unit,omitempty"`
+	Sum       map[string]any `json:"sum,omitempty"`
+	Gauge     map[string]any `json:"gauge,omitempty"`
+	Histogram map[string]any `json:"histogram,omitempty"`
+}
+
+const cumulativeTemporality = 2
+
+// export builds the OTLP request body for the current state of the metrics
+func (o *OTLP) export(now time.Time) map[string]any {
+	startNano := strconv.FormatInt(o.start.UnixNano(), 10)
+	nowNano := strconv.FormatInt(now.UnixNano(), 10)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var metrics []otlpMetric
+
+	for _, p := range o.counters {
+		value := strconv.FormatInt(p.value, 10)
+		metrics = append(metrics, otlpMetric{
+			Name: p.name,
+			Sum: map[string]any{
+				"aggregationTemporality": cumulativeTemporality,
+				"isMonotonic":            true,
+				"dataPoints": []otlpDataPoint{{
+					Attributes:        attributes(p.tags),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      nowNano,
+					AsInt:             &value,
+				}},
+			},
+		})
+	}
+
+	for _, p := range o.gauges {
+		value := p.value
+		metrics = append(metrics, otlpMetric{
+			Name: p.name,
+			Gauge: map[string]any{
+				"dataPoints": []otlpDataPoint{{
+					Attributes:   attributes(p.tags),
+					TimeUnixNano: nowNano,
+					AsDouble:     &value,
+				}},
+			},
+		})
+	}
+
+	for _, p := range o.histograms {
+		sum := p.sum
+		buckets := make([]string, len(p.buckets))
+		for i, c := range p.buckets {
+			buckets[i] = strconv.FormatUint(c, 10)
+		}
+		metrics = append(metrics, otlpMetric{
+			Name: p.name,
+			Unit: "s",
+			Histogram: map[string]any{
+				"aggregationTemporality": cumulativeTemporality,
+				"dataPoints": []otlpDataPoint{{
+					Attributes:        attributes(p.tags),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      nowNano,
+					Count:             strconv.FormatUint(p.count, 10),
+					Sum:               &sum,
+					BucketCounts:      buckets,
+					ExplicitBounds:    timingBounds,
+				}},
+			},
+		})
+	}
+
+	return map[string]any{
+		"resourceMetrics": []map[string]any{{
+			"resource": map[string]any{
+				"attributes": attributes(map[string]string{"service.name": o.serviceName}),
+			},
+			"scopeMetrics": []map[string]any{{
+				"scope":   map[string]any{"name": "github.com/nicotsx/laqueue"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
+
+// attributes converts tags to OTLP string attributes
+func attributes(tags map[string]string) []otlpAttribute {
+	attrs := make([]otlpAttribute, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		attrs = append(attrs, otlpAttribute{Key: k, Value: map[string]any{"stringValue": tags[k]}})
+	}
+	return attrs
+}
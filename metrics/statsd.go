@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsD sends metrics over UDP using the StatsD line protocol, with tags in
+// the DogStatsD format understood by the Datadog agent
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsD returns a sink sending metrics to the StatsD server at addr
+// (e.g. "127.0.0.1:8125"). prefix, if not empty, is prepended to every name.
+func NewStatsD(addr, prefix string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &StatsD{conn: conn, prefix: prefix}, nil
+}
+
+// Count implements Sink
+func (s *StatsD) Count(name string, value int64, tags map[string]string) {
+	s.send(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+// Gauge implements Sink
+func (s *StatsD) Gauge(name string, value float64, tags map[string]string) {
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+// Timing implements Sink
+func (s *StatsD) Timing(name string, d time.Duration, tags map[string]string) {
+	s.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// Close closes the UDP connection
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+// send writes a single metric line. Errors are ignored: metrics are best effort.
+func (s *StatsD) send(name, value, kind string, tags map[string]string) {
+	line := fmt.Sprintf("%s%s:%s|%s", s.prefix, name, value, kind)
+	if len(tags) > 0 {
+		pairs := make([]string, 0, len(tags))
+		for _, k := range sortedKeys(tags) {
+			pairs = append(pairs, k+":"+tags[k])
+		}
+		line += "|#" + strings.Join(pairs, ",")
+	}
+	s.conn.Write([]byte(line))
+}
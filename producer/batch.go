@@ -0,0 +1,120 @@
+package producer
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// HandlerOptions tunes the enqueue endpoint served by HandlerWithOptions
+type HandlerOptions struct {
+	// BatchWindow, if set, coalesces the enqueue requests received within
+	// this long of each other into a single transaction, so that hundreds of
+	// producers hitting the endpoint at once don't cost a write each. Each
+	// request is held until its batch commits, then answered with the ID of
+	// its own item. A few milliseconds are usually enough. Zero enqueues
+	// each request on its own.
+	BatchWindow time.Duration
+
+	// MaxBatch caps the number of requests per batch: a full batch commits
+	// without waiting for the end of its window. Defaults to 100.
+	MaxBatch int
+}
+
+// batchRequest is an enqueue request waiting for its batch to commit
+type batchRequest struct {
+	item queue.BatchItem
+	done chan batchResult
+}
+
+// batchResult is the answer to a batchRequest
+type batchResult struct {
+	id     int64
+	status string
+	err    error
+}
+
+// batch collects the requests received during a window
+type batch struct {
+	requests []*batchRequest
+	full     chan struct{}
+}
+
+// batcher coalesces concurrent enqueue requests. The first request of a
+// batch waits for the window to end or the batch to fill, then commits the
+// whole batch on behalf of the others.
+type batcher struct {
+	db       *sql.DB
+	window   time.Duration
+	maxBatch int
+
+	mu      sync.Mutex
+	pending *batch
+}
+
+// enqueue adds item to the pending batch and waits for it to commit
+func (b *batcher) enqueue(item queue.BatchItem) (int64, string, error) {
+	req := &batchRequest{item: item, done: make(chan batchResult, 1)}
+
+	b.mu.Lock()
+	current := b.pending
+	leader := current == nil
+	if leader {
+		current = &batch{full: make(chan struct{})}
+		b.pending = current
+	}
+	current.requests = append(current.requests, req)
+	if len(current.requests) >= b.maxBatch {
+		// Later requests start a new batch
+		b.pending = nil
+		close(current.full)
+	}
+	b.mu.Unlock()
+
+	if leader {
+		timer := time.NewTimer(b.window)
+		select {
+		case <-timer.C:
+		case <-current.full:
+			timer.Stop()
+		}
+
+		b.mu.Lock()
+		if b.pending == current {
+			b.pending = nil
+		}
+		b.mu.Unlock()
+		b.flush(current.requests)
+	}
+
+	result := <-req.done
+	return result.id, result.status, result.err
+}
+
+// flush enqueues the requests of a batch and answers them
+func (b *batcher) flush(requests []*batchRequest) {
+	items := make([]queue.BatchItem, len(requests))
+	for i, req := range requests {
+		items[i] = req.item
+	}
+
+	results, err := queue.EnqueueBatch(b.db, items, queue.Options{})
+	for i, req := range requests {
+		if err != nil {
+			req.done <- batchResult{err: err}
+			continue
+		}
+
+		result := batchResult{id: results[i].ID, status: queue.StatusPending, err: results[i].Err}
+		if errors.Is(result.err, queue.ErrDuplicate) {
+			// Answer with the existing item, now that the batch committed
+			q := queue.New(b.db, req.item.Queue)
+			result.id, result.status, result.err = q.EnqueueOrGetWithOptions(req.item.Payload, req.item.Options)
+			q.Close()
+		}
+		req.done <- result
+	}
+}
@@ -0,0 +1,234 @@
+package producer
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// enqueueRequest is the body of an enqueue request
+type enqueueRequest struct {
+	Payload json.RawMessage `json:"payload"`
+	Options
+}
+
+// enqueueResponse is the body of a successful enqueue response
+type enqueueResponse struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+}
+
+// waitResponse is the body of a wait response
+type waitResponse struct {
+	ID     int64           `json:"id"`
+	Status string          `json:"status"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// maxRequestSize bounds the size of an enqueue request accepted by Handler
+const maxRequestSize = 1 << 20
+
+// defaultWaitTimeout and maxWaitTimeout bound how long a wait request is held
+// when it doesn't finish, if the request gives no timeout and at most
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 5 * time.Minute
+)
+
+// Handler returns an HTTP handler enqueueing the items posted by NewHTTP
+// producers, as POST /queues/{queue}/items. Requests with a dedup key respond
+// with the existing item, if any, along with its status.
+//
+// GET /queues/{queue}/items/{id}/wait?timeout=30s long-polls an item: it
+// responds once the item is completed, failed or quarantined as corrupt, with
+// its status and result, or with its current status once the timeout, 5m at
+// most, is over, so that the caller can wait again. See queue.LaQueue.Watch.
+//
+// It exposes nothing but these paths; authentication is left to the
+// application wrapping it.
+func Handler(db *sql.DB) http.Handler {
+	return HandlerWithOptions(db, HandlerOptions{})
+}
+
+// HandlerWithOptions returns a Handler tuned by opts
+func HandlerWithOptions(db *sql.DB, opts HandlerOptions) http.Handler {
+	var b *batcher
+	if opts.BatchWindow > 0 {
+		if opts.MaxBatch <= 0 {
+			opts.MaxBatch = 100
+		}
+		b = &batcher{db: db, window: opts.BatchWindow, maxBatch: opts.MaxBatch}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /queues/{queue}/items", func(w http.ResponseWriter, r *http.Request) {
+		var req enqueueRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Payload) == 0 {
+			http.Error(w, "invalid request: missing payload", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := enqueue(db, b, queue.BatchItem{
+			Queue:   r.PathValue("queue"),
+			Payload: req.Payload,
+			Options: req.enqueueOptions(),
+		})
+		if err != nil {
+			http.Error(w, "failed to enqueue item", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("GET /queues/{queue}/items/{id}/wait", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid item ID", http.StatusBadRequest)
+			return
+		}
+		timeout := defaultWaitTimeout
+		if value := r.URL.Query().Get("timeout"); value != "" {
+			if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+				http.Error(w, "invalid timeout", http.StatusBadRequest)
+				return
+			}
+		}
+
+		resp, err := wait(r.Context(), db, r.PathValue("queue"), id, min(timeout, maxWaitTimeout))
+		if errors.Is(err, queue.ErrNotFound) {
+			http.Error(w, "item not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			if r.Context().Err() == nil {
+				http.Error(w, "failed to wait for item", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+	return mux
+}
+
+// wait waits up to timeout for an item to finish, and returns its status,
+// along with its result once finished
+func wait(ctx context.Context, db *sql.DB, queueName string, id int64, timeout time.Duration) (waitResponse, error) {
+	q := queue.New(db, queueName)
+	defer q.Close()
+
+	watchCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	result := <-q.Watch(watchCtx, id)
+	if result.Err != nil && (!errors.Is(result.Err, context.DeadlineExceeded) || ctx.Err() != nil) {
+		return waitResponse{}, result.Err
+	}
+
+	// Read the item again for its result, or its status on timeout
+	item, err := q.Get(id)
+	if err != nil {
+		return waitResponse{}, err
+	}
+	resp := waitResponse{ID: item.ID, Status: item.Status}
+	if len(item.Result) > 0 {
+		resp.Result = item.Result
+	}
+	return resp, nil
+}
+
+// enqueue adds item through the batcher, if any, and returns the response
+// to the request
+func enqueue(db *sql.DB, b *batcher, item queue.BatchItem) (enqueueResponse, error) {
+	var (
+		resp enqueueResponse
+		err  error
+	)
+	if b != nil {
+		resp.ID, resp.Status, err = b.enqueue(item)
+		return resp, err
+	}
+
+	q := queue.New(db, item.Queue)
+	defer q.Close()
+	if item.Options.DedupKey != "" {
+		resp.ID, resp.Status, err = q.EnqueueOrGetWithOptions(item.Payload, item.Options)
+	} else {
+		resp.ID, err = q.EnqueueWithOptions(item.Payload, item.Options)
+		resp.Status = queue.StatusPending
+	}
+	return resp, err
+}
+
+// remote enqueues through a Handler served by another process
+type remote struct {
+	url         string
+	client      *http.Client
+	propagators []queue.Propagator
+}
+
+// NewHTTP returns a Producer posting items to the Handler served at baseURL.
+// A nil client uses http.DefaultClient. Values of the context given to
+// Enqueue are captured by the propagators, if any, see queue.Propagator.
+func NewHTTP(baseURL, queueName string, client *http.Client, propagators ...queue.Propagator) Producer {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &remote{
+		url:         strings.TrimSuffix(baseURL, "/") + "/queues/" + url.PathEscape(queueName) + "/items",
+		client:      client,
+		propagators: propagators,
+	}
+}
+
+func (p *remote) Enqueue(ctx context.Context, payload any, opts Options) (int64, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	opts.Metadata = queue.CaptureContext(ctx, p.propagators, opts.Metadata)
+	body, err := json.Marshal(enqueueRequest{Payload: payloadBytes, Options: opts})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("producer: enqueue failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var out enqueueResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, err
+	}
+	return out.ID, nil
+}
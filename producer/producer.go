@@ -0,0 +1,77 @@
+// Package producer submits items to laqueue queues. It depends on neither the
+// worker package nor a SQLite driver, so services that only enqueue stay
+// light: they either write to the database directly (NewLocal, with the
+// driver registered by the application) or go through the enqueue endpoint
+// of another process (NewHTTP and Handler), needing no database access at all.
+package producer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// Producer enqueues items into a queue
+type Producer interface {
+	Enqueue(ctx context.Context, payload any, opts Options) (int64, error)
+}
+
+// Options holds per-item options, see queue.EnqueueOptions
+type Options struct {
+	Delay    time.Duration     `json:"delay,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	LockKey  string            `json:"lock_key,omitempty"`
+	Priority int               `json:"priority,omitempty"`
+	GroupKey string            `json:"group_key,omitempty"`
+
+	// DedupKey makes the enqueue idempotent: if an item was already enqueued
+	// with the same key, e.g. by a previous attempt of a retried request, no
+	// item is added and the ID of the existing item is returned
+	DedupKey string `json:"dedup_key,omitempty"`
+
+	// Requires pins the item to the workers with these labels
+	Requires map[string]string `json:"requires,omitempty"`
+}
+
+// enqueueOptions converts opts to the queue's options
+func (opts Options) enqueueOptions() queue.EnqueueOptions {
+	return queue.EnqueueOptions{
+		Delay:    opts.Delay,
+		Metadata: opts.Metadata,
+		LockKey:  opts.LockKey,
+		Priority: opts.Priority,
+		GroupKey: opts.GroupKey,
+		DedupKey: opts.DedupKey,
+		Requires: opts.Requires,
+	}
+}
+
+// local enqueues directly into the database
+type local struct {
+	queue       *queue.LaQueue
+	propagators []queue.Propagator
+}
+
+// NewLocal returns a Producer writing to the queue's database. Values of
+// the context given to Enqueue are captured by the propagators, if any, see
+// queue.Propagator.
+func NewLocal(db *sql.DB, queueName string, propagators ...queue.Propagator) Producer {
+	return &local{
+		queue:       queue.NewWithOptions(db, queueName, queue.Options{Propagators: propagators}),
+		propagators: propagators,
+	}
+}
+
+func (p *local) Enqueue(ctx context.Context, payload any, opts Options) (int64, error) {
+	if opts.DedupKey == "" {
+		return p.queue.EnqueueContext(ctx, payload, opts.enqueueOptions())
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	opts.Metadata = queue.CaptureContext(ctx, p.propagators, opts.Metadata)
+	id, _, err := p.queue.EnqueueOrGetWithOptions(payload, opts.enqueueOptions())
+	return id, err
+}
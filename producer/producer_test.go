@@ -0,0 +1,218 @@
+package producer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/nicotsx/laqueue/queue"
+)
+
+func setupTestDB(t testing.TB) *sql.DB {
+	f, err := os.CreateTemp("", "laqueue_producer_test_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	db, err := sql.Open("sqlite3", f.Name())
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := queue.InitSchema(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	return db
+}
+
+func TestProducers(t *testing.T) {
+	db := setupTestDB(t)
+
+	server := httptest.NewServer(Handler(db))
+	defer server.Close()
+
+	producers := map[string]Producer{
+		"local": NewLocal(db, "emails"),
+		"http":  NewHTTP(server.URL, "emails", nil),
+	}
+	q := queue.New(db, "emails")
+
+	for name, p := range producers {
+		id, err := p.Enqueue(context.Background(), map[string]string{"to": name}, Options{
+			Delay:    time.Hour,
+			Metadata: map[string]string{"via": name},
+			Priority: 2,
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to enqueue item: %v", name, err)
+		}
+
+		item, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("%s: failed to get item: %v", name, err)
+		}
+		if string(item.Payload) != `{"to":"`+name+`"}` || item.Metadata["via"] != name || item.Priority != 2 {
+			t.Errorf("%s: unexpected item %+v", name, item)
+		}
+		if time.Until(item.ScheduledAt) < 50*time.Minute {
+			t.Errorf("%s: expected the item to be delayed, scheduled at %v", name, item.ScheduledAt)
+		}
+	}
+
+	// Dedup keys make retried requests return the original item
+	for name, p := range producers {
+		first, err := p.Enqueue(context.Background(), "report", Options{DedupKey: "report-" + name})
+		if err != nil {
+			t.Fatalf("%s: failed to enqueue item: %v", name, err)
+		}
+		retried, err := p.Enqueue(context.Background(), "report", Options{DedupKey: "report-" + name})
+		if err != nil {
+			t.Fatalf("%s: failed to enqueue item again: %v", name, err)
+		}
+		if retried != first {
+			t.Errorf("%s: expected the retry to return item %d, got %d", name, first, retried)
+		}
+	}
+
+	// Invalid requests are rejected
+	resp, err := server.Client().Post(server.URL+"/queues/emails/items", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Failed to post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("Expected an empty request to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestBatchingHandler(t *testing.T) {
+	db := setupTestDB(t)
+
+	server := httptest.NewServer(HandlerWithOptions(db, HandlerOptions{BatchWindow: 20 * time.Millisecond, MaxBatch: 8}))
+	defer server.Close()
+	p := NewHTTP(server.URL, "emails", nil)
+
+	const n = 50
+	var (
+		wg  sync.WaitGroup
+		ids = make([]int64, n)
+	)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts := Options{}
+			if i%10 == 0 {
+				opts.DedupKey = "burst"
+			}
+			id, err := p.Enqueue(context.Background(), i, opts)
+			if err != nil {
+				t.Errorf("Failed to enqueue item %d: %v", i, err)
+			}
+			ids[i] = id
+		}()
+	}
+	wg.Wait()
+
+	// Each request gets its own item, except those sharing a dedup key
+	seen := make(map[int64]int)
+	for i, id := range ids {
+		if i%10 == 0 {
+			if id != ids[0] {
+				t.Errorf("Expected requests with the same dedup key to share item %d, got %d", ids[0], id)
+			}
+			continue
+		}
+		if prev, ok := seen[id]; ok {
+			t.Errorf("Requests %d and %d got the same item %d", prev, i, id)
+		}
+		seen[id] = i
+	}
+
+	q := queue.New(db, "emails")
+	if size, err := q.Size(); err != nil || size != n-n/10+1 {
+		t.Errorf("Expected %d items, got %d, %v", n-n/10+1, size, err)
+	}
+	item, err := q.Get(ids[1])
+	if err != nil || string(item.Payload) != "1" {
+		t.Errorf("Expected the item of request 1, got %+v, %v", item, err)
+	}
+}
+
+func TestWaitHandler(t *testing.T) {
+	db := setupTestDB(t)
+
+	server := httptest.NewServer(Handler(db))
+	defer server.Close()
+	q := queue.New(db, "emails")
+
+	get := func(path string) (int, waitResponse) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to wait for item: %v", err)
+		}
+		defer resp.Body.Close()
+		var out waitResponse
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return resp.StatusCode, out
+	}
+
+	// The request is held until the item finishes
+	id, err := q.Enqueue("welcome")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		item, err := q.Dequeue()
+		if err != nil || item == nil {
+			t.Errorf("Failed to dequeue item: %+v, %v", item, err)
+			return
+		}
+		if err := q.CompleteWithResult(item.ID, map[string]bool{"sent": true}); err != nil {
+			t.Errorf("Failed to complete item: %v", err)
+		}
+	}()
+	start := time.Now()
+	status, out := get(fmt.Sprintf("/queues/emails/items/%d/wait?timeout=5s", id))
+	if status != http.StatusOK || out.ID != id || out.Status != queue.StatusCompleted || string(out.Result) != `{"sent":true}` {
+		t.Errorf("Expected the completed item with its result, got %d %+v", status, out)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected the response once the item completed, took %v", elapsed)
+	}
+
+	// Unfinished items are reported as they are once the timeout is over
+	pending, err := q.Enqueue("later")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if status, out := get(fmt.Sprintf("/queues/emails/items/%d/wait?timeout=50ms", pending)); status != http.StatusOK || out.Status != queue.StatusPending || out.Result != nil {
+		t.Errorf("Expected the pending item, got %d %+v", status, out)
+	}
+
+	for path, want := range map[string]int{
+		fmt.Sprintf("/queues/other/items/%d/wait", id):               http.StatusNotFound,
+		"/queues/emails/items/999/wait":                              http.StatusNotFound,
+		"/queues/emails/items/abc/wait":                              http.StatusBadRequest,
+		fmt.Sprintf("/queues/emails/items/%d/wait?timeout=soon", id): http.StatusBadRequest,
+	} {
+		if status, _ := get(path); status != want {
+			t.Errorf("%s: expected status %d, got %d", path, want, status)
+		}
+	}
+}
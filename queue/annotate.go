@@ -0,0 +1,65 @@
+package queue
+
+import "time"
+
+// Annotation is a note attached to an item by an operator, e.g. "waiting on
+// vendor fix, re-drive after Tuesday"
+type Annotation struct {
+	ItemID    int64     `json:"item_id"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Annotate attaches a note to an item, whatever its status. Notes are kept
+// until the item is deleted. It returns ErrNotFound if the item doesn't exist.
+func (q *LaQueue) Annotate(id int64, note string) error {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	result, err := q.exec(`
+		INSERT INTO queue_annotations (queue_name, item_id, note, created_at)
+		SELECT queue_name, id, ?, ?
+		FROM queue_items
+		WHERE id = ? AND queue_name = ?
+	`, note, q.clock.Now(), id, q.queueName)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Annotations returns the notes attached to an item, oldest first
+func (q *LaQueue) Annotations(id int64) ([]Annotation, error) {
+	stmt, err := q.stmt(`
+		SELECT item_id, note, created_at
+		FROM queue_annotations
+		WHERE queue_name = ? AND item_id = ?
+		ORDER BY id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(q.queueName, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var annotations []Annotation
+	for rows.Next() {
+		var a Annotation
+		if err := rows.Scan(&a.ItemID, &a.Note, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, rows.Err()
+}
@@ -0,0 +1,552 @@
+package queue
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveMagic starts every archive, followed by the format version
+const (
+	archiveMagic   = "LAQA"
+	archiveVersion = 3
+)
+
+// archiveBatchSize is the number of items written per transaction by Import
+const archiveBatchSize = 1000
+
+// maxArchiveFrame bounds the size of an item frame, so that a corrupt size
+// prefix can't make the reader allocate unbounded memory
+const maxArchiveFrame = 256 << 20
+
+// ErrBadArchive is returned when reading data that isn't a valid archive
+var ErrBadArchive = errors.New("queue: not a valid archive")
+
+// ArchiveWriter writes items to a compact binary archive: a header followed
+// by a gzip stream of length-prefixed item frames. Payloads and results are
+// stored as they are in the database, i.e. encoded by the queue's codecs.
+type ArchiveWriter struct {
+	gz    *gzip.Writer
+	frame []byte
+}
+
+// NewArchiveWriter writes the archive header to w and returns a writer for
+// the items. Close must be called to flush the archive.
+func NewArchiveWriter(w io.Writer) (*ArchiveWriter, error) {
+	if _, err := io.WriteString(w, archiveMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte{archiveVersion}); err != nil {
+		return nil, err
+	}
+	return &ArchiveWriter{gz: gzip.NewWriter(w)}, nil
+}
+
+// Write appends an item to the archive
+func (a *ArchiveWriter) Write(item *QueueItem) error {
+	body := appendItem(a.frame[:0], item)
+	a.frame = body
+
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(body)))
+	if _, err := a.gz.Write(size[:n]); err != nil {
+		return err
+	}
+	_, err := a.gz.Write(body)
+	return err
+}
+
+// Close flushes the archive. It doesn't close the underlying writer.
+func (a *ArchiveWriter) Close() error {
+	return a.gz.Close()
+}
+
+// ArchiveReader reads the items of an archive written by ArchiveWriter, one
+// at a time
+type ArchiveReader struct {
+	r       *bufio.Reader
+	frame   []byte
+	version byte
+}
+
+// NewArchiveReader checks the archive header and returns a reader for the
+// items of the archive
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	header := make([]byte, len(archiveMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrBadArchive
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return nil, ErrBadArchive
+	}
+	// Version 1 archives predate content types, and version 2 ones dedup
+	// keys and requirements
+	version := header[len(archiveMagic)]
+	if version < 1 || version > archiveVersion {
+		return nil, fmt.Errorf("queue: unsupported archive version %d", version)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadArchive, err)
+	}
+	return &ArchiveReader{r: bufio.NewReader(gz), version: version}, nil
+}
+
+// Next returns the next item of the archive, or io.EOF once all were read
+func (a *ArchiveReader) Next() (*QueueItem, error) {
+	size, err := binary.ReadUvarint(a.r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadArchive, err)
+	}
+	if size > maxArchiveFrame {
+		return nil, fmt.Errorf("%w: frame of %d bytes", ErrBadArchive, size)
+	}
+
+	if uint64(cap(a.frame)) < size {
+		a.frame = make([]byte, size)
+	}
+	a.frame = a.frame[:size]
+	if _, err := io.ReadFull(a.r, a.frame); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadArchive, err)
+	}
+
+	item, err := readItem(a.frame, a.version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadArchive, err)
+	}
+	return item, nil
+}
+
+// Export writes the items of a queue, or of all queues if queueName is
+// empty, to the archive in ID order. Items are read a page at a time, so
+// memory use stays flat. It returns the number of items written.
+func Export(db *sql.DB, queueName string, a *ArchiveWriter) (int, error) {
+	var (
+		lastID int64
+		count  int
+	)
+	for {
+		rows, err := db.Query(`
+			SELECT `+itemColumns+`
+			FROM queue_items
+			WHERE (? = '' OR queue_name = ?) AND id > ?
+			ORDER BY id ASC
+			LIMIT ?
+		`, queueName, queueName, lastID, eachPageSize)
+		if err != nil {
+			return count, err
+		}
+
+		var page []*QueueItem
+		for rows.Next() {
+			item, err := scanItem(rows)
+			if err != nil {
+				rows.Close()
+				return count, err
+			}
+			page = append(page, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return count, err
+		}
+
+		for _, item := range page {
+			if err := a.Write(item); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if len(page) < eachPageSize {
+			return count, nil
+		}
+		lastID = page[len(page)-1].ID
+	}
+}
+
+// OnConflict decides what Import does with an item whose external ID or
+// dedup key is already used by an item of its queue, e.g. when an archive is
+// imported back into the database it was exported from
+type OnConflict string
+
+const (
+	// ConflictFail stops the import with an error. Items of the batches
+	// before the conflicting one stay imported.
+	ConflictFail OnConflict = "fail"
+	// ConflictSkip keeps the existing item and leaves out the archived one
+	ConflictSkip OnConflict = "skip"
+	// ConflictReplace deletes the existing item and imports the archived one
+	ConflictReplace OnConflict = "replace"
+)
+
+// ImportOptions holds the optional settings of ImportWithOptions
+type ImportOptions struct {
+	// OnConflict defaults to ConflictFail
+	OnConflict OnConflict
+}
+
+// Import inserts the items of an archive into db, keeping their queue,
+// status, times and other attributes. Items get new IDs, so that an archive
+// can be imported into a database that already has items. It returns the
+// number of items imported.
+func Import(db *sql.DB, a *ArchiveReader) (int, error) {
+	return ImportWithOptions(db, a, ImportOptions{})
+}
+
+// ImportWithOptions is Import with options. Skipped items aren't counted.
+func ImportWithOptions(db *sql.DB, a *ArchiveReader, opts ImportOptions) (int, error) {
+	switch opts.OnConflict {
+	case "", ConflictFail, ConflictSkip, ConflictReplace:
+	default:
+		return 0, fmt.Errorf("queue: unknown import conflict behavior %q", opts.OnConflict)
+	}
+
+	mu := writeLock(db)
+	count := 0
+	for {
+		batch := make([]*QueueItem, 0, archiveBatchSize)
+		for len(batch) < archiveBatchSize {
+			item, err := a.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return count, err
+			}
+			batch = append(batch, item)
+		}
+		if len(batch) == 0 {
+			return count, nil
+		}
+
+		mu.Lock()
+		n, err := importBatch(db, batch, opts.OnConflict)
+		mu.Unlock()
+		if err != nil {
+			return count, dbError(err)
+		}
+		count += n
+	}
+}
+
+// importColumns lists the columns set by Import, in importBatch order
+var importColumns = []string{
+	"queue_name", "payload", "created_at", "scheduled_at", "status", "attempts",
+	"last_attempt_at", "result", "retry_schedule", "lock_key", "finished_at",
+	"metadata", "checksum", "claimed_by", "payload_version", "priority",
+	"group_key", "checkpoint", "external_id", "content_type", "dedup_key", "requires",
+}
+
+// importBatch inserts items in a single transaction, resolving conflicts on
+// external IDs and dedup keys as told, and returns how many were inserted
+func importBatch(db *sql.DB, items []*QueueItem, onConflict OnConflict) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	insert := `INSERT INTO queue_items (` + strings.Join(importColumns, ", ") + `) VALUES (` + placeholders(len(importColumns)) + `)`
+	if onConflict == ConflictSkip {
+		insert += ` ON CONFLICT DO NOTHING`
+	}
+	stmt, err := tx.Prepare(insert)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	count := 0
+	for _, item := range items {
+		schedule, err := nullJSON(item.RetrySchedule, len(item.RetrySchedule))
+		if err != nil {
+			return 0, err
+		}
+		metadata, err := nullJSON(item.Metadata, len(item.Metadata))
+		if err != nil {
+			return 0, err
+		}
+		requires, err := nullJSON(item.Requires, len(item.Requires))
+		if err != nil {
+			return 0, err
+		}
+
+		// Deleting rather than INSERT OR REPLACE fires the delete triggers,
+		// e.g. of the change log
+		if onConflict == ConflictReplace && (item.ExternalID != "" || item.DedupKey != "") {
+			_, err := tx.Exec(`
+				DELETE FROM queue_items
+				WHERE queue_name = ? AND (external_id = ? OR dedup_key = ?)
+			`, item.QueueName, nullString(item.ExternalID), nullString(item.DedupKey))
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		res, err := stmt.Exec(
+			item.QueueName, item.Payload, item.CreatedAt.UTC(), item.ScheduledAt.UTC(), item.Status, item.Attempts,
+			utcTime(item.LastAttemptAt), item.Result, schedule, nullString(item.LockKey), utcTime(item.FinishedAt),
+			metadata, nullString(item.Checksum), nullString(item.ClaimedBy), item.PayloadVersion, item.Priority,
+			nullString(item.GroupKey), item.Checkpoint, nullString(item.ExternalID), contentType(item.ContentType),
+			nullString(item.DedupKey), requires,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("item %d: %w", item.ID, err)
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			count += int(n)
+		}
+	}
+	return count, tx.Commit()
+}
+
+// nullJSON returns the JSON encoding of v, or NULL if it has no elements
+func nullJSON(v any, length int) (any, error) {
+	if length == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// contentType returns the stored form of a content type: NULL for JSON
+func contentType(s string) any {
+	if s == ContentTypeJSON {
+		return nil
+	}
+	return nullString(s)
+}
+
+// nullString returns s, or NULL if it is empty
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// appendItem appends the binary encoding of an item to buf. Fields are
+// written in a fixed order; optional ones are prefixed or zero when absent.
+func appendItem(buf []byte, item *QueueItem) []byte {
+	buf = binary.AppendVarint(buf, item.ID)
+	buf = appendString(buf, item.QueueName)
+	buf = appendBytes(buf, item.Payload)
+	buf = appendTime(buf, &item.CreatedAt)
+	buf = appendTime(buf, &item.ScheduledAt)
+	buf = appendString(buf, item.Status)
+	buf = binary.AppendVarint(buf, int64(item.Attempts))
+	buf = appendTime(buf, item.LastAttemptAt)
+	buf = appendBytes(buf, item.Result)
+	buf = binary.AppendUvarint(buf, uint64(len(item.RetrySchedule)))
+	for _, d := range item.RetrySchedule {
+		buf = binary.AppendVarint(buf, int64(d))
+	}
+	buf = appendString(buf, item.LockKey)
+	buf = appendTime(buf, item.FinishedAt)
+
+	buf = appendStrings(buf, item.Metadata)
+	buf = appendString(buf, item.Checksum)
+	buf = appendString(buf, item.ClaimedBy)
+	buf = binary.AppendVarint(buf, int64(item.PayloadVersion))
+	buf = binary.AppendVarint(buf, int64(item.Priority))
+	buf = appendString(buf, item.GroupKey)
+	buf = appendBytes(buf, item.Checkpoint)
+	buf = appendString(buf, item.ExternalID)
+	buf = appendString(buf, item.ContentType)
+	buf = appendString(buf, item.DedupKey)
+	buf = appendStrings(buf, item.Requires)
+	return buf
+}
+
+// appendString appends a length-prefixed string
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// appendStrings appends a count-prefixed map, in key order
+func appendStrings(buf []byte, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	buf = binary.AppendUvarint(buf, uint64(len(keys)))
+	for _, key := range keys {
+		buf = appendString(buf, key)
+		buf = appendString(buf, m[key])
+	}
+	return buf
+}
+
+// appendBytes appends length+1-prefixed bytes, so that nil is told apart
+// from empty
+func appendBytes(buf []byte, b []byte) []byte {
+	if b == nil {
+		return binary.AppendUvarint(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(b))+1)
+	return append(buf, b...)
+}
+
+// appendTime appends a time as Unix nanoseconds, or 0 for nil
+func appendTime(buf []byte, t *time.Time) []byte {
+	if t == nil {
+		return binary.AppendVarint(buf, 0)
+	}
+	return binary.AppendVarint(buf, t.UnixNano())
+}
+
+// itemDecoder reads the fields written by appendItem
+type itemDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *itemDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("truncated frame")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *itemDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("truncated frame")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// count reads the number of elements of a list, each taking at least a byte
+func (d *itemDecoder) count() uint64 {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.err = errors.New("invalid element count")
+		return 0
+	}
+	return n
+}
+
+func (d *itemDecoder) take(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = errors.New("truncated frame")
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *itemDecoder) string() string {
+	return string(d.take(d.uvarint()))
+}
+
+// strings reads a map written by appendStrings, or nil if it is empty
+func (d *itemDecoder) strings() map[string]string {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	m := make(map[string]string, n)
+	for i := uint64(0); i < n; i++ {
+		key := d.string()
+		m[key] = d.string()
+	}
+	return m
+}
+
+func (d *itemDecoder) bytes() []byte {
+	n := d.uvarint()
+	if n == 0 {
+		return nil
+	}
+	return append([]byte{}, d.take(n-1)...)
+}
+
+func (d *itemDecoder) time() *time.Time {
+	nanos := d.varint()
+	if nanos == 0 {
+		return nil
+	}
+	t := time.Unix(0, nanos)
+	return &t
+}
+
+// readItem decodes an item written by appendItem in an archive of the given
+// version
+func readItem(frame []byte, version byte) (*QueueItem, error) {
+	d := &itemDecoder{buf: frame}
+	item := &QueueItem{}
+	item.ID = d.varint()
+	item.QueueName = d.string()
+	item.Payload = d.bytes()
+	if t := d.time(); t != nil {
+		item.CreatedAt = *t
+	}
+	if t := d.time(); t != nil {
+		item.ScheduledAt = *t
+	}
+	item.Status = d.string()
+	item.Attempts = int(d.varint())
+	item.LastAttemptAt = d.time()
+	item.Result = d.bytes()
+	if n := d.count(); n > 0 {
+		item.RetrySchedule = make([]time.Duration, n)
+		for i := range item.RetrySchedule {
+			item.RetrySchedule[i] = time.Duration(d.varint())
+		}
+	}
+	item.LockKey = d.string()
+	item.FinishedAt = d.time()
+	item.Metadata = d.strings()
+	item.Checksum = d.string()
+	item.ClaimedBy = d.string()
+	item.PayloadVersion = int(d.varint())
+	item.Priority = int(d.varint())
+	item.GroupKey = d.string()
+	item.Checkpoint = d.bytes()
+	item.ExternalID = d.string()
+	item.ContentType = ContentTypeJSON
+	if version >= 2 {
+		item.ContentType = d.string()
+	}
+	if version >= 3 {
+		item.DedupKey = d.string()
+		item.Requires = d.strings()
+	}
+
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = errors.New("trailing data in frame")
+	}
+	return item, d.err
+}
@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Attempt records one execution of an item by a worker
+type Attempt struct {
+	ItemID     int64         `json:"item_id"`
+	Attempt    int           `json:"attempt"`
+	WorkerID   string        `json:"worker_id,omitempty"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"duration"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// RecordAttempt appends an attempt to the item's execution log. Workers call
+// it once the handler returns.
+func (q *LaQueue) RecordAttempt(a Attempt) error {
+	var errMsg sql.NullString
+	if a.Error != "" {
+		errMsg = sql.NullString{String: a.Error, Valid: true}
+	}
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	_, err := q.exec(`
+		INSERT INTO queue_attempts (item_id, queue_name, attempt, worker_id, started_at, finished_at, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, a.ItemID, q.queueName, a.Attempt, a.WorkerID, a.StartedAt.UTC(), a.FinishedAt.UTC(), errMsg)
+	return err
+}
+
+// AttemptStats returns the recorded attempts of an item, oldest first
+func (q *LaQueue) AttemptStats(id int64) ([]Attempt, error) {
+	stmt, err := q.stmt(`
+		SELECT item_id, attempt, worker_id, started_at, finished_at, error
+		FROM queue_attempts
+		WHERE queue_name = ? AND item_id = ?
+		ORDER BY attempt ASC, id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(q.queueName, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []Attempt
+	for rows.Next() {
+		var (
+			a        Attempt
+			workerID sql.NullString
+			errMsg   sql.NullString
+		)
+		if err := rows.Scan(&a.ItemID, &a.Attempt, &workerID, &a.StartedAt, &a.FinishedAt, &errMsg); err != nil {
+			return nil, err
+		}
+		a.WorkerID = workerID.String
+		a.Error = errMsg.String
+		a.Duration = a.FinishedAt.Sub(a.StartedAt)
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
@@ -0,0 +1,102 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// BatchItem is an item enqueued by EnqueueBatch
+type BatchItem struct {
+	Queue   string
+	Payload any
+	Options EnqueueOptions
+}
+
+// BatchResult is the outcome of enqueueing a BatchItem: the ID of the new
+// item, or the error that prevented enqueueing it
+type BatchResult struct {
+	ID  int64
+	Err error
+}
+
+// EnqueueBatch adds items to their queues in a single transaction, so that a
+// burst of enqueues costs one write instead of one each. Every queue uses the
+// settings of opts. An item that can't be enqueued, e.g. with ErrDuplicate,
+// doesn't prevent the others from being enqueued: the results report the
+// outcome of each item, in order. The error is only set if the transaction
+// as a whole fails, in which case no item is enqueued.
+func EnqueueBatch(db *sql.DB, items []BatchItem, opts Options) ([]BatchResult, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	queues := make(map[string]*LaQueue)
+	defer func() {
+		for _, q := range queues {
+			q.Close()
+		}
+	}()
+
+	type insert struct {
+		q *LaQueue
+		*itemInsert
+	}
+	results := make([]BatchResult, len(items))
+	inserts := make([]*insert, len(items))
+	var lead *LaQueue
+	for i, item := range items {
+		q, ok := queues[item.Queue]
+		if !ok {
+			q = NewWithOptions(db, item.Queue, opts)
+			queues[item.Queue] = q
+		}
+		itemInsert, err := q.insertQuery(item.Payload, item.Options)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		inserts[i] = &insert{q: q, itemInsert: itemInsert}
+		lead = q
+	}
+	if lead == nil {
+		return results, nil
+	}
+
+	// Queues of the same database share the write lock
+	lead.writeMu.Lock()
+	defer lead.writeMu.Unlock()
+
+	err := lead.writeTx(func(tx *sql.Tx) error {
+		for i, insert := range inserts {
+			if insert == nil {
+				continue
+			}
+			results[i] = BatchResult{}
+
+			// A failed statement is undone alone, leaving the transaction
+			// usable for the rest of the batch
+			result, err := tx.Exec(insert.query, insert.args...)
+			if err != nil {
+				err = duplicateError(dbError(err), items[i].Options.DedupKey)
+				if errors.Is(err, ErrBusy) {
+					return err
+				}
+				results[i].Err = err
+				continue
+			}
+			if results[i].ID, err = result.LastInsertId(); err != nil {
+				return err
+			}
+			if insert.q.mirror.sampled() {
+				if _, err := tx.Exec(insert.query, insert.mirrorArgs(insert.q.mirror.Queue)...); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,54 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrNotPending is returned when an operation requires a pending item
+var ErrNotPending = errors.New("queue: item is not pending")
+
+// Boost moves a pending item to the front of its queue by scheduling it just
+// before the earliest pending item, making it the next one to be claimed
+func (q *LaQueue) Boost(id int64) error {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	return q.writeTx(func(tx *sql.Tx) error {
+		var status string
+		err := tx.QueryRow(`SELECT status FROM queue_items WHERE id = ? AND queue_name = ?`, id, q.queueName).Scan(&status)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+		if status != StatusPending {
+			return ErrNotPending
+		}
+
+		// Select the column rather than MIN() so the driver parses it as a time
+		front := q.clock.Now()
+		var earliest time.Time
+		err = tx.QueryRow(`
+			SELECT scheduled_at FROM queue_items
+			WHERE queue_name = ? AND status = 'pending'
+			ORDER BY scheduled_at ASC
+			LIMIT 1
+		`, q.queueName).Scan(&earliest)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil && earliest.Before(front) {
+			front = earliest.UTC()
+		}
+
+		_, err = tx.Exec(`
+			UPDATE queue_items
+			SET scheduled_at = ?
+			WHERE id = ? AND queue_name = ?
+		`, front.Add(-time.Second), id, q.queueName)
+		return err
+	})
+}
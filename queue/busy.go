@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// Bounds of the exponential backoff between attempts of a busy write
+const (
+	minBusyBackoff = 5 * time.Millisecond
+	maxBusyBackoff = 250 * time.Millisecond
+)
+
+// retryBusy runs fn, and runs it again while it fails with ErrBusy until the
+// queue's BusyRetry budget is spent, waiting a jittered exponential backoff
+// between attempts so that competing processes don't retry in lockstep. fn
+// must be safe to run again after a failure, e.g. a single statement or a
+// whole transaction. Waits use the real time, whatever the queue's Clock.
+func (q *LaQueue) retryBusy(fn func() error) error {
+	err := fn()
+	if q.busyRetry <= 0 {
+		return err
+	}
+
+	deadline := time.Now().Add(q.busyRetry)
+	backoff := minBusyBackoff
+	for errors.Is(dbError(err), ErrBusy) {
+		wait := min(backoff/2+rand.N(backoff/2+1), time.Until(deadline))
+		if wait <= 0 {
+			break
+		}
+		time.Sleep(wait)
+		backoff = min(2*backoff, maxBusyBackoff)
+		err = fn()
+	}
+	return err
+}
+
+// writeTx runs fn in a transaction, committing it if fn succeeds. The whole
+// transaction is retried while the database is busy (see retryBusy), so fn
+// must only have effects through tx. The caller holds the write lock.
+func (q *LaQueue) writeTx(fn func(tx *sql.Tx) error) error {
+	err := q.retryBusy(func() error {
+		tx, err := q.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	return dbError(err)
+}
@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// checkpointQuery stores the checkpoint of an item
+const checkpointQuery = `
+	UPDATE queue_items SET checkpoint = ?
+	WHERE id = ? AND queue_name = ?
+`
+
+// SaveCheckpoint stores the progress of a long-running item, e.g. the last
+// page of an export, so that an attempt retried after a crash can resume
+// from it rather than start over. The checkpoint is returned with the item
+// when it is claimed again, and replaced by every call. It returns
+// ErrNotFound if the item doesn't exist.
+func (q *LaQueue) SaveCheckpoint(id int64, state any) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	result, err := q.exec(checkpointQuery, data, id, q.queueName)
+	return checkpointSaved(result, err)
+}
+
+// SaveCheckpointTx is SaveCheckpoint within tx, e.g. the transaction of a
+// transactional handler, so that the checkpoint commits with the progress it
+// describes, and is rolled back with it. Like CompleteTx, the write lock is
+// not taken: the caller owns tx and its commit.
+func (q *LaQueue) SaveCheckpointTx(tx *sql.Tx, id int64, state any) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(checkpointQuery, data, id, q.queueName)
+	return checkpointSaved(result, dbError(err))
+}
+
+// checkpointSaved returns the error of a checkpoint update, or ErrNotFound
+// if it matched no item
+func checkpointSaved(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Checkpoint decodes the last checkpoint saved for an item into v. It
+// returns false if none was saved, and ErrNotFound if the item doesn't exist.
+func (q *LaQueue) Checkpoint(id int64, v any) (bool, error) {
+	item, err := q.Get(id)
+	if err != nil {
+		return false, err
+	}
+	return item.LoadCheckpoint(v)
+}
+
+// LoadCheckpoint decodes the item's checkpoint into v, or returns false if
+// none was saved
+func (item *QueueItem) LoadCheckpoint(v any) (bool, error) {
+	if item.Checkpoint == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(item.Checkpoint, v)
+}
@@ -0,0 +1,45 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrCorrupt is matched by errors.Is for CorruptItemError
+var ErrCorrupt = errors.New("queue: item payload is corrupt")
+
+// CorruptItemError is returned by DequeueWithOptions when the next item
+// cannot be read: its payload no longer matches the checksum stored at
+// enqueue time, e.g. after file corruption or a manual edit, or the queue's
+// codecs fail to decode it. The item is moved to StatusCorrupt instead of
+// being claimed; dequeueing again moves on to the next item.
+type CorruptItemError struct {
+	ID int64
+
+	// Err is the codec error, nil for a checksum mismatch
+	Err error
+}
+
+func (e *CorruptItemError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("queue: payload of item %d cannot be decoded: %v", e.ID, e.Err)
+	}
+	return fmt.Sprintf("queue: payload of item %d does not match its checksum", e.ID)
+}
+
+// Is reports whether target is ErrCorrupt
+func (e *CorruptItemError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// checksum returns the checksum stored alongside a payload
+func checksum(payload []byte) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(payload))
+}
+
+// verify reports whether the item's payload matches its checksum. Items
+// without one, e.g. enqueued by older versions, are trusted.
+func (item *QueueItem) verify() bool {
+	return item.Checksum == "" || item.Checksum == checksum(item.Payload)
+}
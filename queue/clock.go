@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of queues and workers. Tests can replace the
+// system clock with a ManualClock to exercise delays, backoff and retention
+// without sleeping.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// NewTicker returns a ticker delivering ticks every d
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals, like time.Ticker
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered
+	C() <-chan time.Time
+
+	// Stop turns off the ticker
+	Stop()
+}
+
+// SystemClock is the Clock reading the system time, used by default
+var SystemClock Clock = systemClock{}
+
+// systemClock implements Clock with the time package
+type systemClock struct{}
+
+// Now implements Clock
+func (systemClock) Now() time.Time { return time.Now() }
+
+// NewTicker implements Clock
+func (systemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+// utcClock reports the time of a Clock in UTC. The driver stores times as
+// text in their own zone and SQLite compares them as strings, so every time
+// written or compared by the queue must be in the zone of the
+// CURRENT_TIMESTAMP defaults.
+type utcClock struct{ Clock }
+
+// Now implements Clock
+func (c utcClock) Now() time.Time { return c.Clock.Now().UTC() }
+
+// utcTime returns t in UTC, or nil if t is nil, for nullable time columns
+func utcTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// systemTicker adapts a time.Ticker to Ticker
+type systemTicker struct{ *time.Ticker }
+
+// C implements Ticker
+func (t systemTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// ManualClock is a Clock whose time only moves when told to, for tests. Its
+// tickers fire as Advance moves the time past their next tick.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+// NewManualClock returns a ManualClock set to now
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now implements Clock
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker implements Clock
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("queue: non-positive interval for NewTicker")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &manualTicker{clock: c, interval: d, next: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the tickers due meanwhile.
+// Like time.Ticker, a ticker whose receiver lags behind drops ticks.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if t.next.After(c.now) {
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.interval)
+		}
+	}
+}
+
+// manualTicker is a Ticker driven by a ManualClock
+type manualTicker struct {
+	clock    *ManualClock
+	interval time.Duration
+	next     time.Time
+	c        chan time.Time
+}
+
+// C implements Ticker
+func (t *manualTicker) C() <-chan time.Time { return t.c }
+
+// Stop implements Ticker
+func (t *manualTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, other := range t.clock.tickers {
+		if other == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
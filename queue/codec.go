@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Codec transforms payloads on their way in and out of the database, e.g. to
+// compress or encrypt them. Encode is applied to the JSON payload at enqueue
+// time and Decode must reverse it.
+type Codec interface {
+	Encode(payload []byte) ([]byte, error)
+	Decode(payload []byte) ([]byte, error)
+}
+
+// Upgrade converts a payload to the next version
+type Upgrade func(payload []byte) ([]byte, error)
+
+// encode applies the queue's codecs to a payload being enqueued
+func (q *LaQueue) encode(payload []byte) ([]byte, error) {
+	for _, codec := range q.codecs {
+		var err error
+		if payload, err = codec.Encode(payload); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// decode reverses the queue's codecs on the payload of an item read back,
+// then upgrades it to the queue's payload version
+func (q *LaQueue) decode(item *QueueItem) error {
+	payload := item.Payload
+	for i := len(q.codecs) - 1; i >= 0; i-- {
+		var err error
+		if payload, err = q.codecs[i].Decode(payload); err != nil {
+			return err
+		}
+	}
+
+	for item.PayloadVersion < q.payloadVersion {
+		upgrade, ok := q.upgrades[item.PayloadVersion]
+		if !ok {
+			return fmt.Errorf("no upgrade from payload version %d", item.PayloadVersion)
+		}
+		var err error
+		if payload, err = upgrade(payload); err != nil {
+			return fmt.Errorf("upgrading payload version %d: %w", item.PayloadVersion, err)
+		}
+		item.PayloadVersion++
+	}
+
+	item.Payload = payload
+	return nil
+}
+
+// Gzip is a Codec compressing payloads with gzip, for queues of large,
+// repetitive payloads. Decode passes payloads that aren't gzipped through,
+// so it can be added to a queue with items in flight.
+type Gzip struct{}
+
+// gzipMagic starts every gzip stream, and no JSON document
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Encode compresses payload
+func (Gzip) Encode(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decompresses payload if it is gzipped
+func (Gzip) Decode(payload []byte) ([]byte, error) {
+	if !bytes.HasPrefix(payload, gzipMagic) {
+		return payload, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// QueueConfig holds the settings stored for a queue in the database, so that
+// every worker of the queue shares them. Zero values leave the worker's own
+// configuration or defaults in effect.
+type QueueConfig struct {
+	MaxRetries      int
+	Retention       time.Duration
+	ResultRetention time.Duration
+
+	// DefaultDelay is the minimum time every item of the queue waits before
+	// becoming eligible, e.g. a grace period during which a notification can
+	// still be cancelled. Longer delays given at enqueue time take precedence.
+	DefaultDelay time.Duration
+
+	// DeadLetterQueue, if set, is the queue that receives a copy of the items
+	// failing for good, e.g. for manual review, see DeadLetter
+	DeadLetterQueue string
+
+	// DiscardFailed deletes items failing for good instead of keeping them
+	// with the failed status, see DeadLetter
+	DiscardFailed bool
+}
+
+// SetConfig stores the queue's settings, replacing any previous ones
+func (q *LaQueue) SetConfig(config QueueConfig) error {
+	if config.DeadLetterQueue != "" && config.DiscardFailed {
+		return fmt.Errorf("queue: DeadLetterQueue and DiscardFailed are exclusive")
+	}
+	if config.DeadLetterQueue == q.queueName {
+		return fmt.Errorf("queue: queue %s can't be its own dead-letter queue", q.queueName)
+	}
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	_, err := q.exec(`
+		INSERT INTO queue_configs (queue_name, max_retries, retention, result_retention, default_delay, dead_letter_queue, discard_failed, updated_at)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?)
+		ON CONFLICT (queue_name) DO UPDATE SET
+			max_retries = excluded.max_retries,
+			retention = excluded.retention,
+			result_retention = excluded.result_retention,
+			default_delay = excluded.default_delay,
+			dead_letter_queue = excluded.dead_letter_queue,
+			discard_failed = excluded.discard_failed,
+			updated_at = excluded.updated_at
+	`, q.queueName, config.MaxRetries, int64(config.Retention), int64(config.ResultRetention), int64(config.DefaultDelay),
+		config.DeadLetterQueue, config.DiscardFailed, q.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	q.delayMu.Lock()
+	q.delayRead = time.Time{}
+	q.delayMu.Unlock()
+	return nil
+}
+
+// Config returns the queue's stored settings, or a zero QueueConfig if none
+// were stored
+func (q *LaQueue) Config() (QueueConfig, error) {
+	stmt, err := q.stmt(`
+		SELECT max_retries, retention, result_retention, default_delay, COALESCE(dead_letter_queue, ''), discard_failed
+		FROM queue_configs
+		WHERE queue_name = ?
+	`)
+	if err != nil {
+		return QueueConfig{}, err
+	}
+
+	var (
+		config                                   QueueConfig
+		retention, resultRetention, defaultDelay int64
+	)
+	err = stmt.QueryRow(q.queueName).Scan(&config.MaxRetries, &retention, &resultRetention, &defaultDelay,
+		&config.DeadLetterQueue, &config.DiscardFailed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return QueueConfig{}, nil
+	}
+	if err != nil {
+		return QueueConfig{}, err
+	}
+	config.Retention = time.Duration(retention)
+	config.ResultRetention = time.Duration(resultRetention)
+	config.DefaultDelay = time.Duration(defaultDelay)
+	return config, nil
+}
+
+// defaultDelayTTL is how long defaultDelay caches the stored DefaultDelay,
+// so that enqueueing doesn't read the config every time, while changes made
+// by other processes still apply without restarting producers
+const defaultDelayTTL = 5 * time.Second
+
+// defaultDelay returns the stored DefaultDelay of the queue. Changes made
+// through SetConfig apply at once, others within defaultDelayTTL.
+func (q *LaQueue) defaultDelay() (time.Duration, error) {
+	q.delayMu.Lock()
+	defer q.delayMu.Unlock()
+
+	now := q.clock.Now()
+	if !q.delayRead.IsZero() && now.Sub(q.delayRead) < defaultDelayTTL {
+		return q.delay, nil
+	}
+	config, err := q.Config()
+	if err != nil {
+		return 0, err
+	}
+	q.delay, q.delayRead = config.DefaultDelay, now
+	return q.delay, nil
+}
@@ -0,0 +1,83 @@
+package queue
+
+import "database/sql"
+
+// DeadLetter marks an item as failed for good, as workers do once its
+// retries are exhausted, and hands it to the queue's dead-letter target:
+//   - by default, the item stays in the queue with the failed status
+//   - with QueueConfig.DeadLetterQueue, the item is marked as failed and a
+//     pending copy is enqueued into that queue, with the dead_letter_queue
+//     and dead_letter_id metadata naming the original
+//   - with QueueConfig.DiscardFailed, the item, its attempt log and its
+//     annotations are deleted, and watchers of the item receive ErrNotFound
+//
+// The target is read at every call, so changes apply without restarting
+// workers.
+func (q *LaQueue) DeadLetter(id int64) error {
+	config, err := q.Config()
+	if err != nil {
+		return err
+	}
+	switch {
+	case config.DiscardFailed:
+		err = q.discardFailed(id)
+	case config.DeadLetterQueue != "":
+		err = q.forwardFailed(id, config.DeadLetterQueue)
+	default:
+		return q.Fail(id)
+	}
+	if err == nil {
+		notifyWatchers(q.db, id)
+	}
+	return err
+}
+
+// discardFailed deletes a failed item along with its attempt log and
+// annotations
+func (q *LaQueue) discardFailed(id int64) error {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	return q.writeTx(func(tx *sql.Tx) error {
+		for _, table := range []string{"queue_attempts", "queue_annotations"} {
+			_, err := tx.Exec(`DELETE FROM `+table+` WHERE queue_name = ? AND item_id = ?`, q.queueName, id)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(`DELETE FROM queue_items WHERE id = ? AND queue_name = ?`, id, q.queueName)
+		return err
+	})
+}
+
+// forwardFailed marks an item as failed and enqueues a copy of it into the
+// dead-letter queue, in a single transaction
+func (q *LaQueue) forwardFailed(id int64, deadLetterQueue string) error {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	now := q.clock.Now()
+	return q.writeTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`
+			UPDATE queue_items
+			SET status = 'failed', finished_at = ?
+			WHERE id = ? AND queue_name = ?
+		`, now, id, q.queueName)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err // Nothing to forward
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO queue_items (queue_name, payload, checksum, payload_version, priority, content_type, metadata, created_at, scheduled_at)
+			SELECT ?, payload, checksum, payload_version, priority, content_type,
+				json_set(COALESCE(metadata, '{}'), '$.dead_letter_queue', queue_name, '$.dead_letter_id', CAST(id AS TEXT)),
+				?, ?
+			FROM queue_items
+			WHERE id = ? AND queue_name = ?
+		`, deadLetterQueue, now, now, id, q.queueName)
+		return err
+	})
+}
@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrDuplicate is returned when enqueueing an item with the DedupKey of an
+// item already in the queue
+var ErrDuplicate = errors.New("queue: duplicate dedup key")
+
+// duplicateError reports err as ErrDuplicate if it is the violation of the
+// uniqueness of key. Other errors are returned unchanged.
+func duplicateError(err error, key string) error {
+	if key == "" || err == nil {
+		return err
+	}
+	if code, _ := sqliteCode(err); code != sqliteConstraintUnique || !strings.Contains(err.Error(), "dedup_key") {
+		return err
+	}
+	return fmt.Errorf("%w %q: %w", ErrDuplicate, key, err)
+}
+
+// EnqueueOrGet enqueues payload with the given dedup key, or, if an item of
+// the queue was already enqueued with that key, returns the ID and status of
+// that item instead, e.g. so that a retried producer request reports the job
+// created by the first attempt. A single item is enqueued even when calls
+// with the same key race, from any process. New items report StatusPending.
+func (q *LaQueue) EnqueueOrGet(key string, payload any) (int64, string, error) {
+	return q.EnqueueOrGetWithOptions(payload, EnqueueOptions{DedupKey: key})
+}
+
+// EnqueueOrGetWithOptions is EnqueueOrGet with per-item options, whose
+// DedupKey is required. The options of an existing item are left as they are.
+func (q *LaQueue) EnqueueOrGetWithOptions(payload any, opts EnqueueOptions) (int64, string, error) {
+	if opts.DedupKey == "" {
+		return 0, "", errors.New("queue: EnqueueOrGet requires a dedup key")
+	}
+
+	// The existing item may be deleted between the two steps: enqueue again
+	for {
+		id, err := q.EnqueueWithOptions(payload, opts)
+		if err == nil {
+			if opts.Draft {
+				return id, StatusDraft, nil
+			}
+			return id, StatusPending, nil
+		}
+		if !errors.Is(err, ErrDuplicate) {
+			return 0, "", err
+		}
+
+		id, status, err := q.getByDedupKey(opts.DedupKey)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return id, status, err
+	}
+}
+
+// getByDedupKey returns the ID and status of the item enqueued with key
+func (q *LaQueue) getByDedupKey(key string) (int64, string, error) {
+	stmt, err := q.stmt(`SELECT id, status FROM queue_items WHERE queue_name = ? AND dedup_key = ?`)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var (
+		id     int64
+		status string
+	)
+	err = stmt.QueryRow(q.queueName, key).Scan(&id, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", ErrNotFound
+	}
+	return id, status, err
+}
@@ -0,0 +1,287 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DequeueOptions restricts which items DequeueWithOptions may claim
+type DequeueOptions struct {
+	// Selector limits claiming to items whose metadata contains all of these
+	// key/value pairs, e.g. {"region": "eu"}
+	Selector map[string]string
+
+	// Labels describe the claiming worker, e.g. {"host": "gpu-1"}. Items
+	// pinned with EnqueueOptions.Requires are only claimed when all their
+	// required pairs are among the labels.
+	Labels map[string]string
+
+	// WorkerID, if set, is recorded on the claimed item as its ClaimedBy
+	WorkerID string
+
+	// Order decides which eligible item is claimed first. Defaults to OrderScheduled.
+	Order Order
+
+	// Policy, if set, picks the item to claim among the first Candidates
+	// eligible items in Order (16 by default), e.g. to share the queue fairly
+	// between tenants
+	Policy     ClaimPolicy
+	Candidates int
+
+	// Shards, if greater than 1, splits the queue between that many workers:
+	// only items whose ID modulo Shards equals Shard are claimed, so that
+	// worker hosts don't contend for the same rows
+	Shard  int
+	Shards int
+}
+
+// defaultCandidates is the number of items offered to a ClaimPolicy by default
+const defaultCandidates = 16
+
+// ClaimPolicy chooses which of the eligible candidates to claim, by
+// returning its index, or -1 to claim none of them this time. It runs while
+// the queue's write lock is held, so it must be quick. Payloads are passed
+// as stored, before codecs and upgrades: policies should rely on metadata,
+// priority and other attributes.
+type ClaimPolicy func(candidates []*QueueItem) int
+
+// Order is a strategy for picking the next item to claim among eligible ones
+type Order string
+
+const (
+	// OrderScheduled claims the item that became eligible first
+	OrderScheduled Order = "scheduled"
+	// OrderFIFO claims the oldest item first, regardless of delays and retries
+	OrderFIFO Order = "fifo"
+	// OrderLIFO claims the newest item first, e.g. for cache refreshes where
+	// the freshest request wins
+	OrderLIFO Order = "lifo"
+	// OrderRandom claims any eligible item
+	OrderRandom Order = "random"
+	// OrderPriority claims the item with the highest EnqueueOptions.Priority
+	// first, then the one that became eligible first
+	OrderPriority Order = "priority"
+)
+
+// orderBy maps each Order to its ORDER BY clause
+var orderBy = map[Order]string{
+	"":             "scheduled_at ASC",
+	OrderScheduled: "scheduled_at ASC",
+	OrderFIFO:      "created_at ASC, id ASC",
+	OrderLIFO:      "created_at DESC, id DESC",
+	OrderRandom:    "RANDOM()",
+	OrderPriority:  "priority DESC, scheduled_at ASC",
+}
+
+// DequeueWithOptions retrieves and claims the next available item matching the options
+func (q *LaQueue) DequeueWithOptions(opts DequeueOptions) (*QueueItem, error) {
+	var item *QueueItem
+	err := q.retryBusy(func() (err error) {
+		item, err = q.dequeue(opts)
+		return err
+	})
+	return item, dbError(err)
+}
+
+// dequeue implements DequeueWithOptions
+func (q *LaQueue) dequeue(opts DequeueOptions) (*QueueItem, error) {
+	order, ok := orderBy[opts.Order]
+	if !ok {
+		return nil, fmt.Errorf("queue: unknown order %q", opts.Order)
+	}
+	now := q.clock.Now()
+	conditions, args, err := q.claimConditions(opts, now)
+	if err != nil {
+		return nil, err
+	}
+	conditions = append(conditions, `(group_key IS NULL OR NOT EXISTS (
+			SELECT 1 FROM queue_items AS running
+			WHERE running.queue_name = queue_items.queue_name
+			AND running.group_key = queue_items.group_key
+			AND running.status = 'processing'
+		))`)
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	selectStmt, err := q.stmt(`
+		SELECT ` + itemColumns + `
+		FROM queue_items
+		WHERE ` + strings.Join(conditions, "\n\t\tAND ") + `
+		ORDER BY ` + order + `
+		LIMIT ?
+	`)
+	if err != nil {
+		return nil, err
+	}
+	claimStmt, err := q.stmt(`
+		UPDATE queue_items
+		SET status = 'processing', attempts = attempts + 1, last_attempt_at = ?, claimed_by = NULLIF(?, '')
+		WHERE id = ? AND queue_name = ?
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var item *QueueItem
+	if opts.Policy == nil {
+		item, err = scanItem(tx.Stmt(selectStmt).QueryRow(append(args, 1)...))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // No items in queue
+		}
+	} else {
+		item, err = q.choose(tx.Stmt(selectStmt), args, opts)
+	}
+	if err != nil || item == nil {
+		return nil, err
+	}
+
+	var corrupt *CorruptItemError
+	if !item.verify() {
+		corrupt = &CorruptItemError{ID: item.ID}
+	} else if err := q.decode(item); err != nil {
+		corrupt = &CorruptItemError{ID: item.ID, Err: err}
+	}
+	if corrupt != nil {
+		// Quarantine the item rather than handing garbage to a handler
+		_, err = tx.Exec(`
+			UPDATE queue_items SET status = 'corrupt', finished_at = ?
+			WHERE id = ? AND queue_name = ?
+		`, now, item.ID, q.queueName)
+		if err != nil {
+			return nil, err
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+		notifyWatchers(q.db, item.ID)
+		return nil, corrupt
+	}
+
+	// Mark the item as processing
+	_, err = tx.Stmt(claimStmt).Exec(now, opts.WorkerID, item.ID, q.queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	item.Status = StatusProcessing
+	item.Attempts++
+	item.LastAttemptAt = &now
+	item.ClaimedBy = opts.WorkerID
+
+	return item, nil
+}
+
+// claimConditions returns the conditions a pending item must meet to be
+// claimed with opts at now, and their arguments. Conditions about groups are
+// left to the callers.
+func (q *LaQueue) claimConditions(opts DequeueOptions, now time.Time) ([]string, []any, error) {
+	if opts.Shards > 1 && (opts.Shard < 0 || opts.Shard >= opts.Shards) {
+		return nil, nil, fmt.Errorf("queue: shard %d out of range for %d shards", opts.Shard, opts.Shards)
+	}
+
+	conditions := []string{
+		"queue_name = ?",
+		"status = 'pending'",
+		"scheduled_at <= ?",
+		`(lock_key IS NULL OR NOT EXISTS (
+			SELECT 1 FROM queue_items AS running
+			WHERE running.queue_name = queue_items.queue_name
+			AND running.lock_key = queue_items.lock_key
+			AND running.status = 'processing'
+		))`,
+		pausedCondition,
+		`(requires IS NULL OR NOT EXISTS (
+			SELECT 1 FROM json_each(queue_items.requires) AS required
+			WHERE required.value IS NOT (SELECT label.value FROM json_each(?) AS label WHERE label.key = required.key)
+		))`,
+	}
+	labels, err := json.Marshal(opts.Labels)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.Labels == nil {
+		labels = []byte("{}")
+	}
+	unix := now.Unix()
+	args := []any{q.queueName, now, unix, unix, unix, string(labels)}
+
+	if q.payloadVersion > 0 {
+		// Leave items of a newer release to the workers that can read them
+		conditions = append(conditions, "payload_version <= ?")
+		args = append(args, q.payloadVersion)
+	}
+
+	if opts.Shards > 1 {
+		conditions = append(conditions, "id % ? = ?")
+		args = append(args, opts.Shards, opts.Shard)
+	}
+
+	// Sort the selector keys so equivalent selectors share a cached statement
+	keys := make([]string, 0, len(opts.Selector))
+	for key := range opts.Selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		conditions = append(conditions, "json_extract(metadata, ?) = ?")
+		args = append(args, jsonPath(key), opts.Selector[key])
+	}
+	return conditions, args, nil
+}
+
+// choose reads the candidates of a claim and returns the one picked by the
+// options' policy, or nil if it picked none
+func (q *LaQueue) choose(stmt *sql.Stmt, args []any, opts DequeueOptions) (*QueueItem, error) {
+	limit := opts.Candidates
+	if limit <= 0 {
+		limit = defaultCandidates
+	}
+
+	rows, err := stmt.Query(append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []*QueueItem
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, item)
+	}
+	if err := rows.Err(); err != nil || len(candidates) == 0 {
+		return nil, err
+	}
+
+	i := opts.Policy(candidates)
+	if i < 0 {
+		return nil, nil
+	}
+	if i >= len(candidates) {
+		return nil, fmt.Errorf("queue: claim policy chose candidate %d of %d", i, len(candidates))
+	}
+	return candidates[i], nil
+}
+
+// jsonPath returns the SQLite JSON path addressing a top-level key
+func jsonPath(key string) string {
+	return `$."` + strings.ReplaceAll(key, `"`, `\"`) + `"`
+}
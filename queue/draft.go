@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrNotDraft is returned when publishing or discarding an item that is not a draft
+var ErrNotDraft = errors.New("queue: item is not a draft")
+
+// Publish makes draft items available for processing. Either all of them are
+// published or, if one of them is not a draft of this queue, none is.
+func (q *LaQueue) Publish(ids ...int64) error {
+	return q.updateItems(`UPDATE queue_items SET status = 'pending' WHERE id = ? AND queue_name = ? AND status = 'draft'`, ids, ErrNotDraft)
+}
+
+// Discard deletes draft items. Either all of them are deleted or, if one of
+// them is not a draft of this queue, none is.
+func (q *LaQueue) Discard(ids ...int64) error {
+	return q.updateItems(`DELETE FROM queue_items WHERE id = ? AND queue_name = ? AND status = 'draft'`, ids, ErrNotDraft)
+}
+
+// updateItems runs query for each ID in a single transaction, rolling back
+// with errMissing if the query doesn't affect one of the items
+func (q *LaQueue) updateItems(query string, ids []int64, errMissing error) error {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	return q.writeTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, id := range ids {
+			result, err := stmt.Exec(id, q.queueName)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return fmt.Errorf("%w: item %d", errMissing, id)
+			}
+		}
+		return nil
+	})
+}
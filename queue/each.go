@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// eachPageSize is the number of items loaded per page by Each
+const eachPageSize = 500
+
+// Each calls fn for every item of the queue with the given status (all
+// statuses if empty), in ID order. Items are loaded a page at a time using
+// keyset pagination, so memory use stays flat regardless of queue size, and
+// fn may write to the queue. Iteration stops at the first error from fn,
+// which is returned.
+func (q *LaQueue) Each(status string, fn func(*QueueItem) error) error {
+	stmt, err := q.stmt(`
+		SELECT ` + itemColumns + `
+		FROM queue_items
+		WHERE queue_name = ? AND (? = '' OR status = ?) AND id > ?
+		ORDER BY id ASC
+		LIMIT ?
+	`)
+	if err != nil {
+		return err
+	}
+
+	var lastID int64
+	for {
+		page, err := q.readPage(stmt, q.queueName, status, status, lastID, eachPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range page {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < eachPageSize {
+			return nil
+		}
+		lastID = page[len(page)-1].ID
+	}
+}
+
+// readPage reads a page of items, closing the rows before returning so that
+// callbacks don't run while a read is in progress
+func (q *LaQueue) readPage(stmt *sql.Stmt, args ...any) ([]*QueueItem, error) {
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var page []*QueueItem
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		if err := q.decode(item); err != nil {
+			return nil, fmt.Errorf("item %d: %w", item.ID, err)
+		}
+		page = append(page, item)
+	}
+	return page, rows.Err()
+}
@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"errors"
+	"strings"
+)
+
+// Errors reported by the database, independent of the driver. Errors
+// returned by the queue match them with errors.Is, and still unwrap to the
+// driver's error for callers needing the details.
+var (
+	// ErrBusy reports that the database was locked by another connection or
+	// process for longer than the busy timeout. The operation may succeed
+	// if retried.
+	ErrBusy = errors.New("queue: database is busy")
+
+	// ErrConstraint reports that a write violated a constraint of the schema
+	ErrConstraint = errors.New("queue: constraint violation")
+
+	// ErrReadOnly reports a write to a database opened read-only or stored
+	// on a read-only file
+	ErrReadOnly = errors.New("queue: database is read-only")
+)
+
+// DBError is a database error classified as one of ErrBusy, ErrConstraint
+// or ErrReadOnly
+type DBError struct {
+	// Kind is the library error the driver error was classified as
+	Kind error
+	// Err is the error returned by the driver
+	Err error
+}
+
+func (e *DBError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns both the kind and the driver error, so that errors.Is and
+// errors.As match either
+func (e *DBError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// SQLite result codes classified by dbError, see https://sqlite.org/rescode.html
+const (
+	sqliteBusy             = 5
+	sqliteLocked           = 6
+	sqliteReadOnly         = 8
+	sqliteConstraint       = 19
+	sqliteConstraintUnique = 2067
+)
+
+// sqliteMessages maps the messages SQLite reports with result codes to the
+// codes, for drivers such as mattn/go-sqlite3 whose errors don't expose them
+// through a method. More specific messages come first.
+var sqliteMessages = []struct {
+	message string
+	code    int
+}{
+	{"UNIQUE constraint failed", sqliteConstraintUnique},
+	{"constraint failed", sqliteConstraint},
+	{"database is locked", sqliteBusy},
+	{"database table is locked", sqliteLocked},
+	{"database schema is locked", sqliteLocked},
+	{"attempt to write a readonly database", sqliteReadOnly},
+}
+
+// sqliteCode returns the extended SQLite result code of a driver error. The
+// queue doesn't import a driver, so that packages such as producer don't
+// link one: the code is read from drivers whose errors have a Code method,
+// e.g. modernc.org/sqlite, and recognized from the message otherwise.
+func sqliteCode(err error) (int, bool) {
+	var coder interface{ Code() int }
+	if errors.As(err, &coder) {
+		return coder.Code(), true
+	}
+	msg := err.Error()
+	for _, m := range sqliteMessages {
+		if strings.Contains(msg, m.message) {
+			return m.code, true
+		}
+	}
+	return 0, false
+}
+
+// dbError classifies a driver error as a DBError. Other errors, including
+// nil and errors already classified, are returned unchanged.
+func dbError(err error) error {
+	var classified *DBError
+	if err == nil || errors.As(err, &classified) {
+		return err
+	}
+	code, ok := sqliteCode(err)
+	if !ok {
+		return err
+	}
+
+	var kind error
+	switch code & 0xff { // primary code
+	case sqliteBusy, sqliteLocked:
+		kind = ErrBusy
+	case sqliteConstraint:
+		kind = ErrConstraint
+	case sqliteReadOnly:
+		kind = ErrReadOnly
+	default:
+		return err
+	}
+	return &DBError{Kind: kind, Err: err}
+}
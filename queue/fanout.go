@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// EnqueueFanout adds a batch of items in a single transaction, spreading
+// their scheduled times evenly across the given window: the first item is
+// eligible immediately and the others follow at regular intervals. It
+// returns the IDs of the items in payload order. The queue's DefaultDelay
+// postpones the whole window.
+func (q *LaQueue) EnqueueFanout(payloads []any, spread time.Duration) ([]int64, error) {
+	encoded := make([][]byte, len(payloads))
+	for i, payload := range payloads {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		if payloadBytes, err = q.encode(payloadBytes); err != nil {
+			return nil, err
+		}
+		encoded[i] = payloadBytes
+	}
+
+	floor, err := q.defaultDelay()
+	if err != nil {
+		return nil, err
+	}
+
+	insertStmt, err := q.stmt(`INSERT INTO queue_items (queue_name, payload, checksum, payload_version, scheduled_at, external_id) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	start := q.clock.Now().Add(floor)
+	ids := make([]int64, len(encoded))
+
+	err = q.writeTx(func(tx *sql.Tx) error {
+		stmt := tx.Stmt(insertStmt)
+		for i, payloadBytes := range encoded {
+			offset := time.Duration(int64(spread) * int64(i) / int64(len(encoded)))
+
+			var externalID any // NULL without a generator
+			if q.externalIDs != nil {
+				externalID = q.externalIDs()
+			}
+			result, err := stmt.Exec(q.queueName, payloadBytes, checksum(payloadBytes), q.payloadVersion, start.Add(offset), externalID)
+			if err != nil {
+				return err
+			}
+			if ids[i], err = result.LastInsertId(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// DequeueGroup claims the next available item along with every other pending
+// item of the queue sharing its GroupKey, so that they can be processed as a
+// unit, e.g. all the line items of one invoice. Grouped items are claimed
+// only while no item of the group is processing, and members only if
+// DequeueWithOptions could claim them too, e.g. once they are scheduled and
+// if they match the options. An item without a group key is returned alone.
+// The items are returned in ID order, or nil if none is available. Members
+// that fail verification are quarantined, as DequeueWithOptions does.
+func (q *LaQueue) DequeueGroup(opts DequeueOptions) ([]*QueueItem, error) {
+	items, err := q.dequeueGroup(opts)
+	return items, dbError(err)
+}
+
+// dequeueGroup implements DequeueGroup
+func (q *LaQueue) dequeueGroup(opts DequeueOptions) ([]*QueueItem, error) {
+	lead, err := q.DequeueWithOptions(opts)
+	if err != nil || lead == nil || lead.GroupKey == "" {
+		if lead == nil {
+			return nil, err
+		}
+		return []*QueueItem{lead}, err
+	}
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	items, quarantined, err := q.claimMembers(lead, opts)
+	if err != nil {
+		// Don't leave the lead processing without a worker handling it
+		return nil, errors.Join(err, q.releaseLocked(lead.ID))
+	}
+	for _, id := range quarantined {
+		notifyWatchers(q.db, id)
+	}
+	return items, nil
+}
+
+// claimMembers claims the eligible members of the group of lead, which is
+// processing, in a single transaction, and returns the group along with the
+// IDs of the members quarantined. The caller holds the write lock.
+func (q *LaQueue) claimMembers(lead *QueueItem, opts DequeueOptions) ([]*QueueItem, []int64, error) {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	now := q.clock.Now()
+	conditions, args, err := q.claimConditions(opts, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The lead is processing, so no other worker claims members meanwhile
+	rows, err := tx.Query(`
+		SELECT `+itemColumns+`
+		FROM queue_items
+		WHERE id = ? OR (group_key = ? AND `+strings.Join(conditions, " AND ")+`)
+		ORDER BY id ASC
+	`, append([]any{lead.ID, lead.GroupKey}, args...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	var items []*QueueItem
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	claimed := items[:0]
+	var quarantined []int64
+	for _, item := range items {
+		if item.ID == lead.ID {
+			claimed = append(claimed, lead)
+			continue
+		}
+		if !item.verify() || q.decode(item) != nil {
+			// Quarantine unreadable members as DequeueWithOptions would
+			_, err = tx.Exec(`
+				UPDATE queue_items SET status = 'corrupt', finished_at = ?
+				WHERE id = ? AND queue_name = ?
+			`, now, item.ID, q.queueName)
+			if err != nil {
+				return nil, nil, err
+			}
+			quarantined = append(quarantined, item.ID)
+			continue
+		}
+
+		_, err = tx.Exec(`
+			UPDATE queue_items
+			SET status = 'processing', attempts = attempts + 1, last_attempt_at = ?, claimed_by = NULLIF(?, '')
+			WHERE id = ? AND queue_name = ?
+		`, now, opts.WorkerID, item.ID, q.queueName)
+		if err != nil {
+			return nil, nil, err
+		}
+		item.Status = StatusProcessing
+		item.Attempts++
+		item.LastAttemptAt = &now
+		item.ClaimedBy = opts.WorkerID
+		claimed = append(claimed, item)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return claimed, quarantined, nil
+}
+
+// releaseLocked is Release for callers already holding the write lock
+func (q *LaQueue) releaseLocked(id int64) error {
+	_, err := q.exec(`
+		UPDATE queue_items
+		SET status = 'pending', attempts = MAX(attempts - 1, 0)
+		WHERE id = ? AND queue_name = ? AND status = 'processing'
+	`, id, q.queueName)
+	return err
+}
+
+// CompleteGroup marks items as completed in a single transaction, storing
+// the same result with each of them unless it is nil
+func (q *LaQueue) CompleteGroup(ids []int64, result any) error {
+	var resultArg any // NULL keeps the stored result
+	if result != nil {
+		resultBytes, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		resultArg = resultBytes
+	}
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	now := q.clock.Now()
+	err := q.writeTx(func(tx *sql.Tx) error {
+		for _, id := range ids {
+			_, err := tx.Exec(`
+				UPDATE queue_items
+				SET status = 'completed', finished_at = ?, result = COALESCE(?, result)
+				WHERE id = ? AND queue_name = ?
+			`, now, resultArg, id, q.queueName)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		notifyWatchers(q.db, id)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ExactlyOnce runs fn unless key was already recorded for this queue by a
+// previous successful call, and reports whether fn ran. The key is recorded
+// in the transaction passed to fn, once fn succeeds: database writes made
+// through tx commit atomically with the key, so a retried item never applies
+// them twice. Side effects outside the database still happen at least once
+// if the process crashes between fn returning and the commit.
+func (q *LaQueue) ExactlyOnce(key string, fn func(tx *sql.Tx) error) (bool, error) {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	ran, err := q.ExactlyOnceTx(tx, key, fn)
+	if err != nil || !ran {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, dbError(err)
+	}
+	return true, nil
+}
+
+// ExactlyOnceTx is ExactlyOnce within tx, e.g. the transaction of a
+// transactional handler, so that the key commits with the caller's writes.
+// The writes of fn are rolled back if it fails, leaving tx usable. Like
+// CompleteTx, the write lock is not taken: the caller owns tx and its commit.
+func (q *LaQueue) ExactlyOnceTx(tx *sql.Tx, key string, fn func(tx *sql.Tx) error) (bool, error) {
+	var exists int
+	err := tx.QueryRow(`
+		SELECT 1 FROM queue_idempotency_keys WHERE queue_name = ? AND key = ?
+	`, q.queueName, key).Scan(&exists)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, dbError(err)
+	}
+
+	if _, err := tx.Exec(`SAVEPOINT laqueue_exactly_once`); err != nil {
+		return false, dbError(err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Exec(`ROLLBACK TO laqueue_exactly_once`)
+		tx.Exec(`RELEASE laqueue_exactly_once`)
+		return false, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO queue_idempotency_keys (queue_name, key) VALUES (?, ?)
+	`, q.queueName, key); err != nil {
+		return false, dbError(err)
+	}
+	if _, err := tx.Exec(`RELEASE laqueue_exactly_once`); err != nil {
+		return false, dbError(err)
+	}
+	return true, nil
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Violation is a breach of the consistency rules checked by Invariants
+type Violation struct {
+	QueueName string `json:"queue_name"`
+	ItemID    int64  `json:"item_id"`
+	Rule      string `json:"rule"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("queue %s, item %d: %s", v.QueueName, v.ItemID, v.Rule)
+}
+
+// invariants lists the rules checked against each item, as SQL conditions
+// selecting the items breaking them
+var invariants = []struct {
+	rule      string
+	condition string
+}{
+	{
+		"unknown status",
+		`status NOT IN ('pending', 'processing', 'completed', 'failed', 'draft', 'corrupt') OR status IS NULL`,
+	},
+	{
+		"negative attempt count",
+		`attempts < 0`,
+	},
+	{
+		"finished item without finished_at",
+		`status IN ('completed', 'failed', 'corrupt') AND finished_at IS NULL`,
+	},
+	{
+		"unfinished item with finished_at",
+		`status IN ('pending', 'processing', 'draft') AND finished_at IS NOT NULL`,
+	},
+	{
+		"processing item never claimed",
+		`status = 'processing' AND (last_attempt_at IS NULL OR attempts < 1)`,
+	},
+}
+
+// Invariants checks the internal consistency of the queues stored in db and
+// returns the breaches found, e.g. a completed item without a finish time or
+// a processing item that was never claimed. An empty result means the
+// database is consistent, which makes it suitable for asserting at the end of
+// property-based tests of code driving the queue. Attempt counts are not
+// compared with the attempt log, since ResetAttempts legitimately makes them
+// diverge.
+func Invariants(db *sql.DB) ([]Violation, error) {
+	var violations []Violation
+	for _, invariant := range invariants {
+		rows, err := db.Query(`SELECT queue_name, id FROM queue_items WHERE ` + invariant.condition + ` ORDER BY id`)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			v := Violation{Rule: invariant.rule}
+			if err := rows.Scan(&v.QueueName, &v.ItemID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			violations = append(violations, v)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	attempts, err := attemptViolations(db)
+	if err != nil {
+		return nil, err
+	}
+	return append(violations, attempts...), nil
+}
+
+// attemptViolations checks the attempt log. Times are compared in Go, as the
+// driver parses them, rather than as the strings SQLite stores.
+func attemptViolations(db *sql.DB) ([]Violation, error) {
+	rows, err := db.Query(`SELECT queue_name, item_id, attempt, started_at, finished_at FROM queue_attempts ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var violations []Violation
+	for rows.Next() {
+		var (
+			v                 Violation
+			attempt           int
+			started, finished time.Time
+		)
+		if err := rows.Scan(&v.QueueName, &v.ItemID, &attempt, &started, &finished); err != nil {
+			return nil, err
+		}
+		switch {
+		case attempt < 1:
+			v.Rule = fmt.Sprintf("attempt %d recorded with a number below 1", attempt)
+		case finished.Before(started):
+			v.Rule = fmt.Sprintf("attempt %d finished before it started", attempt)
+		default:
+			continue
+		}
+		violations = append(violations, v)
+	}
+	return violations, rows.Err()
+}
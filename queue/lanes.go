@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"sort"
+	"time"
+)
+
+// Lane summarizes the items of one priority, so that operators can check
+// that higher priorities are actually serviced faster
+type Lane struct {
+	Priority int `json:"priority"`
+
+	// Depth is the number of items of the lane ready to be claimed
+	Depth int `json:"depth"`
+
+	// OldestWait is how long the oldest ready item has been waiting
+	OldestWait time.Duration `json:"oldest_wait"`
+
+	// Claimed is the number of items claimed since the given time
+	Claimed int `json:"claimed"`
+
+	// Latency is the average time those items waited between becoming
+	// eligible and being claimed
+	Latency time.Duration `json:"latency"`
+}
+
+// Lanes returns the depth and claim latency of each priority of the queue,
+// highest priority first. Latency covers the items claimed since the given
+// time. Priorities without ready nor recently claimed items are omitted.
+func (q *LaQueue) Lanes(since time.Time) ([]Lane, error) {
+	stmt, err := q.stmt(`
+		SELECT priority, status, scheduled_at, last_attempt_at
+		FROM queue_items
+		WHERE queue_name = ?
+		AND ((status = 'pending' AND scheduled_at <= ?) OR last_attempt_at >= ?)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	now := q.clock.Now()
+	rows, err := stmt.Query(q.queueName, now, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lanes := make(map[int]*Lane)
+	waited := make(map[int]time.Duration)
+	for rows.Next() {
+		var (
+			priority      int
+			status        string
+			scheduledAt   time.Time
+			lastAttemptAt *time.Time
+		)
+		if err := rows.Scan(&priority, &status, &scheduledAt, &lastAttemptAt); err != nil {
+			return nil, err
+		}
+
+		lane, ok := lanes[priority]
+		if !ok {
+			lane = &Lane{Priority: priority}
+			lanes[priority] = lane
+		}
+
+		if status == StatusPending && !scheduledAt.After(now) {
+			lane.Depth++
+			lane.OldestWait = max(lane.OldestWait, now.Sub(scheduledAt))
+		}
+		if lastAttemptAt != nil && !lastAttemptAt.Before(since) {
+			lane.Claimed++
+			waited[priority] += max(lastAttemptAt.Sub(scheduledAt), 0)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]Lane, 0, len(lanes))
+	for priority, lane := range lanes {
+		if lane.Claimed > 0 {
+			lane.Latency = waited[priority] / time.Duration(lane.Claimed)
+		}
+		result = append(result, *lane)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Priority > result[j].Priority })
+	return result, nil
+}
@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ListOptions filters and orders the items returned by List. Zero values
+// disable the corresponding filter.
+type ListOptions struct {
+	// Status restricts the listing to items with this status
+	Status string
+
+	// Since and Before restrict the listing to items created at or after
+	// Since, and before Before
+	Since  time.Time
+	Before time.Time
+
+	// ScheduledWithin restricts the listing to items scheduled to become
+	// eligible between now and now plus this duration
+	ScheduledWithin time.Duration
+
+	// Sort is the column to order by: id (the default), created_at,
+	// scheduled_at or attempts. Ties are broken by ID.
+	Sort string
+
+	// Descending reverses the order
+	Descending bool
+
+	// Limit caps the number of items returned. Zero means no limit.
+	Limit int
+}
+
+// listSortColumns lists the columns ListOptions.Sort accepts
+var listSortColumns = map[string]bool{
+	"id":           true,
+	"created_at":   true,
+	"scheduled_at": true,
+	"attempts":     true,
+}
+
+// List returns the items of the queue matching opts. Unlike Each, all items
+// are loaded in memory, so large listings should set a Limit.
+func (q *LaQueue) List(opts ListOptions) ([]*QueueItem, error) {
+	sortColumn := opts.Sort
+	if sortColumn == "" {
+		sortColumn = "id"
+	}
+	if !listSortColumns[sortColumn] {
+		return nil, fmt.Errorf("queue: cannot sort by %q", opts.Sort)
+	}
+	direction := "ASC"
+	if opts.Descending {
+		direction = "DESC"
+	}
+	orderBy := sortColumn + " " + direction
+	if sortColumn != "id" {
+		orderBy += ", id " + direction
+	}
+
+	conditions, args := q.listConditions(opts)
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = -1 // No limit
+	}
+	args = append(args, limit)
+
+	stmt, err := q.stmt(`
+		SELECT ` + itemColumns + `
+		FROM queue_items
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY ` + orderBy + `
+		LIMIT ?
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return q.readPage(stmt, args...)
+}
+
+// listConditions returns the conditions restricting a query on queue_items
+// to the items of the queue matching the filters of opts, and their
+// arguments
+func (q *LaQueue) listConditions(opts ListOptions) ([]string, []any) {
+	conditions := []string{"queue_name = ?"}
+	args := []any{q.queueName}
+
+	if opts.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, opts.Status)
+	}
+	if !opts.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, opts.Since.UTC())
+	}
+	if !opts.Before.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, opts.Before.UTC())
+	}
+	if opts.ScheduledWithin > 0 {
+		now := q.clock.Now()
+		conditions = append(conditions, "scheduled_at BETWEEN ? AND ?")
+		args = append(args, now, now.Add(opts.ScheduledWithin))
+	}
+	return conditions, args
+}
@@ -0,0 +1,49 @@
+package queue
+
+import (
+	"database/sql"
+	"math/rand/v2"
+)
+
+// Mirror copies a sample of enqueued items into a shadow queue, so that a new
+// version of a handler can consume real traffic without affecting the items
+// processed in production. Copies are inserted in the same transaction as
+// the original, with the same payload and options, except for the dedup key
+// and external ID, which only identify the original.
+type Mirror struct {
+	// Queue is the name of the shadow queue
+	Queue string
+
+	// Rate is the fraction of items copied, between 0 and 1
+	Rate float64
+}
+
+// sampled reports whether the next item should be mirrored
+func (m *Mirror) sampled() bool {
+	return m != nil && m.Queue != "" && m.Rate > 0 && rand.Float64() < m.Rate
+}
+
+// insertMirrored runs an item insert, then inserts a copy of the item into
+// the mirror's queue, and returns the ID of the original. The caller holds
+// the write lock.
+func (q *LaQueue) insertMirrored(insert *itemInsert) (int64, error) {
+	stmt, err := q.stmt(insert.query)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = q.writeTx(func(tx *sql.Tx) error {
+		result, err := tx.Stmt(stmt).Exec(insert.args...)
+		if err != nil {
+			return err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+
+		_, err = tx.Stmt(stmt).Exec(insert.mirrorArgs(q.mirror.Queue)...)
+		return err
+	})
+	return id, err
+}
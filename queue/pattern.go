@@ -0,0 +1,31 @@
+package queue
+
+import "database/sql"
+
+// MatchQueues returns the names of the queues holding items whose name
+// matches pattern, sorted. Patterns use the GLOB syntax of SQLite: * matches
+// any sequence of characters, ? any single character and [...] a set of
+// characters, so "emails.*" matches "emails.tenant42". Matching is
+// case-sensitive.
+func MatchQueues(db *sql.DB, pattern string) ([]string, error) {
+	rows, err := db.Query(`SELECT DISTINCT queue_name FROM queue_items WHERE queue_name GLOB ? ORDER BY queue_name`, pattern)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// Name returns the name of the queue
+func (q *LaQueue) Name() string {
+	return q.queueName
+}
@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"errors"
+	"time"
+)
+
+// PauseWindow is a period during which no item of the queue is claimed, e.g.
+// for an upstream maintenance. Pauses are stored in the database and enforced
+// by DequeueWithOptions for every worker of the queue.
+type PauseWindow struct {
+	ID    int64
+	Start time.Time
+	End   time.Time
+
+	// Every repeats the window at this period after Start, e.g. 7 * 24h for a
+	// weekly maintenance. Zero means the window happens once. Repetitions are
+	// computed in absolute time, so they shift by an hour across DST changes.
+	Every time.Duration
+}
+
+// pausedCondition restricts a query on queue_items to queues not paused at
+// the given Unix time, passed three times
+const pausedCondition = `NOT EXISTS (
+			SELECT 1 FROM queue_pauses AS pause
+			WHERE pause.queue_name = queue_items.queue_name
+			AND pause.starts_at <= ?
+			AND (CASE WHEN pause.repeat_every > 0
+				THEN (? - pause.starts_at) % pause.repeat_every
+				ELSE ? - pause.starts_at END) < pause.ends_at - pause.starts_at
+		)`
+
+// SchedulePause stores a pause window for the queue and returns its ID
+func (q *LaQueue) SchedulePause(pause PauseWindow) (int64, error) {
+	if !pause.End.After(pause.Start) {
+		return 0, errors.New("queue: pause window must end after it starts")
+	}
+	if pause.Every > 0 && pause.Every < pause.End.Sub(pause.Start) {
+		return 0, errors.New("queue: pause window must not last longer than its period")
+	}
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	result, err := q.exec(`
+		INSERT INTO queue_pauses (queue_name, starts_at, ends_at, repeat_every)
+		VALUES (?, ?, ?, ?)
+	`, q.queueName, pause.Start.Unix(), pause.End.Unix(), int64(pause.Every/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Pauses returns the pause windows of the queue, including past ones
+func (q *LaQueue) Pauses() ([]PauseWindow, error) {
+	stmt, err := q.stmt(`
+		SELECT id, starts_at, ends_at, repeat_every
+		FROM queue_pauses
+		WHERE queue_name = ?
+		ORDER BY starts_at ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(q.queueName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pauses []PauseWindow
+	for rows.Next() {
+		var (
+			pause                 PauseWindow
+			start, end, everySecs int64
+		)
+		if err := rows.Scan(&pause.ID, &start, &end, &everySecs); err != nil {
+			return nil, err
+		}
+		pause.Start = time.Unix(start, 0)
+		pause.End = time.Unix(end, 0)
+		pause.Every = time.Duration(everySecs) * time.Second
+		pauses = append(pauses, pause)
+	}
+	return pauses, rows.Err()
+}
+
+// DeletePause removes a pause window of the queue
+func (q *LaQueue) DeletePause(id int64) error {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	result, err := q.exec(`DELETE FROM queue_pauses WHERE id = ? AND queue_name = ?`, id, q.queueName)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Paused reports whether one of the queue's pause windows contains t
+func (q *LaQueue) Paused(t time.Time) (bool, error) {
+	pauses, err := q.Pauses()
+	if err != nil {
+		return false, err
+	}
+	for _, pause := range pauses {
+		if pause.contains(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// contains reports whether t falls in the window or one of its repetitions
+func (p PauseWindow) contains(t time.Time) bool {
+	if t.Before(p.Start) {
+		return false
+	}
+	elapsed := t.Sub(p.Start)
+	if p.Every > 0 {
+		elapsed %= p.Every
+	}
+	return elapsed < p.End.Sub(p.Start)
+}
@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"context"
+	"maps"
+)
+
+// Propagator carries a value from the context of the code enqueueing an item,
+// such as a request ID, user ID or locale, to the context of the handler
+// processing it, through the item's metadata
+type Propagator struct {
+	// Key is the metadata key holding the value
+	Key string
+
+	// Extract reads the value from the enqueueing context
+	Extract func(ctx context.Context) (string, bool)
+
+	// Inject returns a copy of the handler's context carrying the value
+	Inject func(ctx context.Context, value string) context.Context
+}
+
+// EnqueueContext adds a new item to the queue like EnqueueWithOptions, after
+// storing the values captured from ctx by the queue's propagators (see
+// Options.Propagators) in the item's metadata. Metadata given in opts takes
+// precedence.
+func (q *LaQueue) EnqueueContext(ctx context.Context, payload any, opts EnqueueOptions) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	opts.Metadata = CaptureContext(ctx, q.propagators, opts.Metadata)
+	return q.EnqueueWithOptions(payload, opts)
+}
+
+// CaptureContext returns metadata along with the values the propagators
+// extract from ctx. Keys already present in metadata are kept. metadata
+// itself is not modified.
+func CaptureContext(ctx context.Context, propagators []Propagator, metadata map[string]string) map[string]string {
+	captured, copied := metadata, false
+	for _, p := range propagators {
+		if _, ok := metadata[p.Key]; ok {
+			continue
+		}
+		value, ok := p.Extract(ctx)
+		if !ok {
+			continue
+		}
+		if !copied {
+			captured, copied = maps.Clone(metadata), true
+			if captured == nil {
+				captured = make(map[string]string)
+			}
+		}
+		captured[p.Key] = value
+	}
+	return captured
+}
+
+// RestoreContext returns a copy of ctx carrying the values propagated with
+// an item's metadata
+func RestoreContext(ctx context.Context, propagators []Propagator, metadata map[string]string) context.Context {
+	for _, p := range propagators {
+		if value, ok := metadata[p.Key]; ok {
+			ctx = p.Inject(ctx, value)
+		}
+	}
+	return ctx
+}
@@ -4,13 +4,58 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Item statuses
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusDraft      = "draft"
+	StatusCorrupt    = "corrupt"
+)
+
+// ErrNotFound is returned when an item does not exist in the queue
+var ErrNotFound = errors.New("queue: item not found")
+
+// writeLocks holds one mutex per database handle. SQLite only allows a single
+// writer at a time, so every write issued through the queue package goes
+// through this lock instead of racing for the database file lock.
+var writeLocks sync.Map
+
+// writeLock returns the write mutex associated with db
+func writeLock(db *sql.DB) *sync.Mutex {
+	mu, _ := writeLocks.LoadOrStore(db, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
 // LaQueue represents a queue backed by SQLite
 type LaQueue struct {
 	db        *sql.DB
 	queueName string
+	writeMu   *sync.Mutex
+	codecs    []Codec
+
+	payloadVersion int
+	upgrades       map[int]Upgrade
+	mirror         *Mirror
+	propagators    []Propagator
+	clock          Clock
+	externalIDs    IDGenerator
+	busyRetry      time.Duration
+
+	stmtMu sync.Mutex
+	stmts  map[string]*sql.Stmt
+
+	// delay caches the stored DefaultDelay, read at delayRead, see defaultDelay
+	delayMu   sync.Mutex
+	delay     time.Duration
+	delayRead time.Time
 }
 
 // QueueItem represents an item in the queue
@@ -23,127 +68,608 @@ type QueueItem struct {
 	Status        string     `json:"status"`
 	Attempts      int        `json:"attempts"`
 	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	Result        []byte     `json:"result,omitempty"`
+
+	// RetrySchedule is the item's own retry schedule, if it was given one
+	RetrySchedule []time.Duration `json:"retry_schedule,omitempty"`
+
+	// LockKey is the mutual exclusion key of the item, if any
+	LockKey string `json:"lock_key,omitempty"`
+
+	// FinishedAt is when the item was completed or failed
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	// Metadata holds the attributes given to the item at enqueue time
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Checksum is the checksum of the payload taken at enqueue time
+	Checksum string `json:"checksum,omitempty"`
+
+	// ClaimedBy identifies the worker that last claimed the item, see
+	// DequeueOptions.WorkerID. Along with LastAttemptAt, it tells who holds a
+	// processing item and since when.
+	ClaimedBy string `json:"claimed_by,omitempty"`
+
+	// PayloadVersion is the version of the payload structure the item was
+	// enqueued with, see Options.PayloadVersion. Items read through a queue
+	// with upgrades report the version their payload was upgraded to.
+	PayloadVersion int `json:"payload_version,omitempty"`
+
+	// Priority is the priority given at enqueue time, see OrderPriority
+	Priority int `json:"priority,omitempty"`
+
+	// GroupKey is the group the item belongs to, if any, see DequeueGroup
+	GroupKey string `json:"group_key,omitempty"`
+
+	// Checkpoint is the progress last saved by the item's handler, if any,
+	// see SaveCheckpoint
+	Checkpoint []byte `json:"checkpoint,omitempty"`
+
+	// ExternalID is the string ID generated at enqueue time by the queue's
+	// IDGenerator, if it has one, see Options.ExternalIDs
+	ExternalID string `json:"external_id,omitempty"`
+
+	// ContentType describes the payload, see EnqueueOptions.ContentType
+	ContentType string `json:"content_type"`
+
+	// DedupKey is the dedup key given at enqueue time, if any, see
+	// EnqueueOptions.DedupKey
+	DedupKey string `json:"dedup_key,omitempty"`
+
+	// Requires holds the worker labels the item is pinned to, if any, see
+	// EnqueueOptions.Requires
+	Requires map[string]string `json:"requires,omitempty"`
+}
+
+// Content types of payloads, see EnqueueOptions.ContentType. Other types
+// may be used as well.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeText     = "text/plain"
+	ContentTypeProtobuf = "application/protobuf"
+)
+
+// itemColumns lists the columns read into a QueueItem, in scanItem order
+const itemColumns = `id, queue_name, payload, created_at, scheduled_at, status, attempts, last_attempt_at, result, retry_schedule, lock_key, finished_at, metadata, checksum, claimed_by, payload_version, priority, group_key, checkpoint, external_id, content_type, dedup_key, requires`
+
+// scanItem reads a row selected with itemColumns
+func scanItem(row interface{ Scan(...any) error }) (*QueueItem, error) {
+	var (
+		item     QueueItem
+		schedule sql.NullString
+		lockKey  sql.NullString
+		metadata sql.NullString
+		sum      sql.NullString
+		owner    sql.NullString
+		group    sql.NullString
+		external sql.NullString
+		content  sql.NullString
+		dedupKey sql.NullString
+		requires sql.NullString
+	)
+	err := row.Scan(
+		&item.ID, &item.QueueName, &item.Payload, &item.CreatedAt,
+		&item.ScheduledAt, &item.Status, &item.Attempts, &item.LastAttemptAt,
+		&item.Result, &schedule, &lockKey, &item.FinishedAt, &metadata,
+		&sum, &owner, &item.PayloadVersion, &item.Priority, &group,
+		&item.Checkpoint, &external, &content, &dedupKey, &requires,
+	)
+	if err != nil {
+		return nil, err
+	}
+	item.LockKey = lockKey.String
+	item.Checksum = sum.String
+	item.ClaimedBy = owner.String
+	item.GroupKey = group.String
+	item.ExternalID = external.String
+	item.DedupKey = dedupKey.String
+	item.ContentType = content.String
+	if !content.Valid {
+		item.ContentType = ContentTypeJSON
+	}
+	if schedule.Valid && schedule.String != "" {
+		if err := json.Unmarshal([]byte(schedule.String), &item.RetrySchedule); err != nil {
+			return nil, err
+		}
+	}
+	if metadata.Valid && metadata.String != "" {
+		if err := json.Unmarshal([]byte(metadata.String), &item.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	if requires.Valid && requires.String != "" {
+		if err := json.Unmarshal([]byte(requires.String), &item.Requires); err != nil {
+			return nil, err
+		}
+	}
+	return &item, nil
+}
+
+// Options holds the optional settings of a queue, see NewWithOptions
+type Options struct {
+	// Codecs are applied in order when enqueueing, and in reverse order when
+	// reading items back, so that e.g. {Gzip{}, encryption} compresses then
+	// encrypts, and decrypts then decompresses. Items are read with the
+	// codecs of the queue reading them: changing the chain of a queue with
+	// items in flight requires codecs that recognize the older formats.
+	Codecs []Codec
+
+	// PayloadVersion is stamped on the items enqueued through the queue, to
+	// be bumped when the payload structure changes. Items enqueued with a
+	// newer version, e.g. by a newer release during a rolling deployment, are
+	// left for the workers that know it. Zero disables versioning.
+	PayloadVersion int
+
+	// Upgrades convert payloads from one version to the next: Upgrades[1]
+	// turns a version 1 payload into a version 2 one. Items enqueued with an
+	// older version go through each upgrade in turn when read back, after the
+	// codecs, so handlers only ever see the current version.
+	Upgrades map[int]Upgrade
+
+	// Mirror, if set, copies a sample of the items enqueued through the queue
+	// into a shadow queue
+	Mirror *Mirror
+
+	// Propagators capture values from the context given to EnqueueContext
+	// into the item's metadata
+	Propagators []Propagator
+
+	// ExternalIDs, if set, generates a string ID for each item enqueued
+	// through the queue, e.g. NewULID, to reference it in logs, APIs or
+	// emails without exposing the sequential row IDs
+	ExternalIDs IDGenerator
+
+	// Clock is the source of the times the queue schedules and compares
+	// items with. Defaults to SystemClock.
+	Clock Clock
+
+	// BusyRetry is how long writes failing with ErrBusy are retried, with
+	// backoff, before the error is returned. Retries complement the
+	// connection's busy timeout when several processes write to the same
+	// database. Zero disables retries. Callbacks of ExactlyOnce are not
+	// retried, as they may have effects outside the transaction.
+	BusyRetry time.Duration
 }
 
 // New creates a new LaQueue instance
 func New(db *sql.DB, queueName string) *LaQueue {
+	return NewWithOptions(db, queueName, Options{})
+}
+
+// NewWithOptions creates a new LaQueue instance with optional settings
+func NewWithOptions(db *sql.DB, queueName string, opts Options) *LaQueue {
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
 	return &LaQueue{
 		db:        db,
 		queueName: queueName,
+		writeMu:   writeLock(db),
+		codecs:    opts.Codecs,
+		stmts:     make(map[string]*sql.Stmt),
+
+		payloadVersion: opts.PayloadVersion,
+		upgrades:       opts.Upgrades,
+		mirror:         opts.Mirror,
+		propagators:    opts.Propagators,
+		clock:          utcClock{opts.Clock},
+		externalIDs:    opts.ExternalIDs,
+		busyRetry:      opts.BusyRetry,
 	}
 }
 
-// Enqueue adds a new item to the queue
-func (q *LaQueue) Enqueue(payload any) (int64, error) {
-	payloadBytes, err := json.Marshal(payload)
+// stmt returns a cached prepared statement for query, preparing it on first use
+func (q *LaQueue) stmt(query string) (*sql.Stmt, error) {
+	q.stmtMu.Lock()
+	defer q.stmtMu.Unlock()
+
+	if stmt, ok := q.stmts[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := q.db.Prepare(query)
 	if err != nil {
-		return 0, err
+		return nil, dbError(err)
 	}
+	q.stmts[query] = stmt
+	return stmt, nil
+}
 
-	result, err := q.db.Exec(
-		`INSERT INTO queue_items (queue_name, payload) VALUES (?, ?)`,
-		q.queueName, payloadBytes,
-	)
+// exec runs a write statement through the statement cache
+func (q *LaQueue) exec(query string, args ...any) (sql.Result, error) {
+	stmt, err := q.stmt(query)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	var result sql.Result
+	err = q.retryBusy(func() (err error) {
+		result, err = stmt.Exec(args...)
+		return err
+	})
+	return result, dbError(err)
+}
+
+// Close releases the prepared statements cached by the queue. The underlying
+// database is left open; statements are prepared again if the queue is reused.
+func (q *LaQueue) Close() error {
+	q.stmtMu.Lock()
+	defer q.stmtMu.Unlock()
+
+	var errs []error
+	for query, stmt := range q.stmts {
+		if err := stmt.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(q.stmts, query)
 	}
+	return errors.Join(errs...)
+}
+
+// EnqueueOptions holds per-item options for EnqueueWithOptions
+type EnqueueOptions struct {
+	// Delay postpones the item's eligibility for processing
+	Delay time.Duration
+
+	// RetrySchedule lists the delays to wait before each retry of the item,
+	// overriding the worker's schedule or computed backoff. The item fails
+	// once the schedule is exhausted.
+	RetrySchedule []time.Duration
+
+	// LockKey, when set, prevents the item from being processed while another
+	// item of the queue with the same key is processing, across all workers.
+	// The item stays pending until the other one finishes.
+	LockKey string
+
+	// Metadata holds attributes of the item that workers can select on
+	// (see DequeueOptions.Selector) and read from their handler context
+	Metadata map[string]string
 
-	return result.LastInsertId()
+	// Priority orders items for workers claiming with OrderPriority, higher first
+	Priority int
+
+	// GroupKey, when set, makes the item part of a group claimed as a unit
+	// by DequeueGroup. No item of a group is claimed while another one is
+	// processing.
+	GroupKey string
+
+	// Draft inserts the item as a draft: it is not claimed until published
+	// with Publish, or can be dropped with Discard
+	Draft bool
+
+	// DedupKey, when set, makes the enqueue fail with ErrDuplicate if an item
+	// of the queue was already enqueued with the same key, until that item
+	// is deleted. See EnqueueOrGet to get the existing item instead.
+	DedupKey string
+
+	// ContentType describes the payload, ContentTypeJSON by default. Payloads
+	// of other types must be given as []byte or string: they are stored as
+	// they are instead of being marshaled to JSON. Handlers can tell them
+	// apart, and tools render them accordingly.
+	ContentType string
+
+	// Requires pins the item to the workers whose labels include all these
+	// key/value pairs (see DequeueOptions.Labels), e.g. {"host": "gpu-1"}
+	// for a job needing a file or device that only lives there. The item
+	// waits until such a worker claims it.
+	Requires map[string]string
+}
+
+// Enqueue adds a new item to the queue
+func (q *LaQueue) Enqueue(payload any) (int64, error) {
+	return q.EnqueueWithOptions(payload, EnqueueOptions{})
 }
 
 // EnqueueWithDelay adds a new item to the queue with a specified delay
 func (q *LaQueue) EnqueueWithDelay(payload any, delay time.Duration) (int64, error) {
-	payloadBytes, err := json.Marshal(payload)
+	return q.EnqueueWithOptions(payload, EnqueueOptions{Delay: delay})
+}
+
+// EnqueueWithOptions adds a new item to the queue with per-item options
+func (q *LaQueue) EnqueueWithOptions(payload any, opts EnqueueOptions) (int64, error) {
+	id, _, err := q.EnqueueWithExternalID(payload, opts)
+	return id, err
+}
+
+// EnqueueWithExternalID adds a new item to the queue like EnqueueWithOptions,
+// and also returns its external ID, or an empty string if the queue doesn't
+// generate them (see Options.ExternalIDs)
+func (q *LaQueue) EnqueueWithExternalID(payload any, opts EnqueueOptions) (int64, string, error) {
+	insert, err := q.insertQuery(payload, opts)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 
-	scheduledAt := time.Now().Add(delay)
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
 
-	result, err := q.db.Exec(
-		`INSERT INTO queue_items (queue_name, payload, scheduled_at) VALUES (?, ?, ?)`,
-		q.queueName, payloadBytes, scheduledAt,
-	)
+	if q.mirror.sampled() {
+		id, err := q.insertMirrored(insert)
+		return id, insert.externalID, duplicateError(err, opts.DedupKey)
+	}
+
+	result, err := q.exec(insert.query, insert.args...)
 	if err != nil {
-		return 0, err
+		return 0, "", duplicateError(err, opts.DedupKey)
 	}
 
-	return result.LastInsertId()
+	id, err := result.LastInsertId()
+	return id, insert.externalID, err
 }
 
-// Dequeue retrieves and claims the next available item from the queue
-func (q *LaQueue) Dequeue() (*QueueItem, error) {
-	tx, err := q.db.Begin()
+// EnqueueTx adds a new item to the queue within tx, so that the item only
+// exists if the caller's own writes to the same database commit, e.g. to
+// enqueue a job along with the row it is about. Like CompleteTx, the write
+// lock is not taken: the caller owns tx and its commit.
+func (q *LaQueue) EnqueueTx(tx *sql.Tx, payload any, opts EnqueueOptions) (int64, error) {
+	insert, err := q.insertQuery(payload, opts)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer tx.Rollback()
 
-	var item QueueItem
-	now := time.Now()
-
-	err = tx.QueryRow(`
-		SELECT id, queue_name, payload, created_at, scheduled_at, status, attempts, last_attempt_at
-		FROM queue_items
-		WHERE queue_name = ? AND status = 'pending' AND scheduled_at <= ?
-		ORDER BY scheduled_at ASC
-		LIMIT 1
-	`, q.queueName, now).Scan(
-		&item.ID, &item.QueueName, &item.Payload, &item.CreatedAt,
-		&item.ScheduledAt, &item.Status, &item.Attempts, &item.LastAttemptAt,
-	)
+	result, err := tx.Exec(insert.query, insert.args...)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil // No items in queue
+		return 0, duplicateError(dbError(err), opts.DedupKey)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if q.mirror.sampled() {
+		if _, err := tx.Exec(insert.query, insert.mirrorArgs(q.mirror.Queue)...); err != nil {
+			return 0, dbError(err)
 		}
-		return nil, err
 	}
+	return id, nil
+}
 
-	// Mark the item as processing
-	_, err = tx.Exec(`
-		UPDATE queue_items
-		SET status = 'processing', attempts = attempts + 1, last_attempt_at = ?
-		WHERE id = ? AND queue_name = ?
-	`, now, item.ID, q.queueName)
+// itemInsert is the statement inserting an item into a queue
+type itemInsert struct {
+	query string
+
+	// args are the arguments of query, starting with the queue name
+	args []any
+
+	// externalID is the external ID of the item, if the queue generates them
+	externalID string
+
+	// unique lists the positions in args of the keys unique within the
+	// queue, e.g. the dedup key, which copies of the item must not carry
+	unique []int
+}
+
+// mirrorArgs returns the arguments inserting a copy of the item into queue,
+// without the keys unique to the original, so that the copy is neither
+// found by them nor conflicts with later items of the queue
+func (i *itemInsert) mirrorArgs(queue string) []any {
+	args := append([]any{queue}, i.args[1:]...)
+	for _, n := range i.unique {
+		args[n] = nil
+	}
+	return args
+}
+
+// insertQuery returns the statement inserting payload with opts into the
+// queue
+func (q *LaQueue) insertQuery(payload any, opts EnqueueOptions) (*itemInsert, error) {
+	payloadBytes, err := marshalPayload(payload, opts.ContentType)
 	if err != nil {
 		return nil, err
 	}
-
-	if err = tx.Commit(); err != nil {
+	if payloadBytes, err = q.encode(payloadBytes); err != nil {
+		return nil, err
+	}
+	floor, err := q.defaultDelay()
+	if err != nil {
 		return nil, err
 	}
+	opts.Delay = max(opts.Delay, floor)
 
-	item.Status = "processing"
-	item.Attempts++
-	item.LastAttemptAt = &now
+	columns := []string{"queue_name", "payload", "checksum"}
+	args := []any{q.queueName, payloadBytes, checksum(payloadBytes)}
 
-	return &item, nil
+	// Times are written rather than left to the CURRENT_TIMESTAMP defaults,
+	// which only have a precision of a second and ignore the queue's clock
+	now := q.clock.Now()
+	columns = append(columns, "created_at", "scheduled_at")
+	args = append(args, now, now.Add(opts.Delay))
+	if len(opts.RetrySchedule) > 0 {
+		schedule, err := json.Marshal(opts.RetrySchedule)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, "retry_schedule")
+		args = append(args, string(schedule))
+	}
+	if opts.LockKey != "" {
+		columns = append(columns, "lock_key")
+		args = append(args, opts.LockKey)
+	}
+	if len(opts.Metadata) > 0 {
+		metadata, err := json.Marshal(opts.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, "metadata")
+		args = append(args, string(metadata))
+	}
+	if opts.Priority != 0 {
+		columns = append(columns, "priority")
+		args = append(args, opts.Priority)
+	}
+	if opts.GroupKey != "" {
+		columns = append(columns, "group_key")
+		args = append(args, opts.GroupKey)
+	}
+	var unique []int
+	if opts.DedupKey != "" {
+		unique = append(unique, len(args))
+		columns = append(columns, "dedup_key")
+		args = append(args, opts.DedupKey)
+	}
+	if opts.ContentType != "" && opts.ContentType != ContentTypeJSON {
+		columns = append(columns, "content_type")
+		args = append(args, opts.ContentType)
+	}
+	if len(opts.Requires) > 0 {
+		requires, err := json.Marshal(opts.Requires)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, "requires")
+		args = append(args, string(requires))
+	}
+	if q.payloadVersion > 0 {
+		columns = append(columns, "payload_version")
+		args = append(args, q.payloadVersion)
+	}
+	if opts.Draft {
+		columns = append(columns, "status")
+		args = append(args, StatusDraft)
+	}
+	var externalID string
+	if q.externalIDs != nil {
+		externalID = q.externalIDs()
+		unique = append(unique, len(args))
+		columns = append(columns, "external_id")
+		args = append(args, externalID)
+	}
+
+	return &itemInsert{
+		query:      `INSERT INTO queue_items (` + strings.Join(columns, ", ") + `) VALUES (` + placeholders(len(columns)) + `)`,
+		args:       args,
+		externalID: externalID,
+		unique:     unique,
+	}, nil
+}
+
+// marshalPayload returns the stored form of a payload of the given content
+// type: JSON for ContentTypeJSON, the payload itself otherwise
+func marshalPayload(payload any, contentType string) ([]byte, error) {
+	if contentType == "" || contentType == ContentTypeJSON {
+		return json.Marshal(payload)
+	}
+	switch p := payload.(type) {
+	case []byte:
+		return p, nil
+	case string:
+		return []byte(p), nil
+	}
+	return nil, fmt.Errorf("queue: %s payloads must be []byte or string, got %T", contentType, payload)
+}
+
+// placeholders returns n comma-separated SQL parameter placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// Dequeue retrieves and claims the next available item from the queue
+func (q *LaQueue) Dequeue() (*QueueItem, error) {
+	return q.DequeueWithOptions(DequeueOptions{})
 }
 
 // Complete marks a queue item as completed
 func (q *LaQueue) Complete(id int64) error {
-	_, err := q.db.Exec(`
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	_, err := q.exec(`
 		UPDATE queue_items
-		SET status = 'completed'
+		SET status = 'completed', finished_at = ?
 		WHERE id = ? AND queue_name = ?
-	`, id, q.queueName)
+	`, q.clock.Now(), id, q.queueName)
+	if err == nil {
+		notifyWatchers(q.db, id)
+	}
 	return err
 }
 
+// CompleteWithResult marks a queue item as completed and stores the result
+// of its processing, which can be read back with Get
+func (q *LaQueue) CompleteWithResult(id int64, result any) error {
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	_, err = q.exec(`
+		UPDATE queue_items
+		SET status = 'completed', result = ?, finished_at = ?
+		WHERE id = ? AND queue_name = ?
+	`, resultBytes, q.clock.Now(), id, q.queueName)
+	if err == nil {
+		notifyWatchers(q.db, id)
+	}
+	return err
+}
+
+// CompleteTx marks a queue item as completed within tx, so that the ack
+// commits atomically with the caller's own writes to the same database. A nil
+// result leaves the item without one. The write lock is not taken: the caller
+// owns tx and its commit. Watchers learn of the completion at their next poll.
+func (q *LaQueue) CompleteTx(tx *sql.Tx, id int64, result any) error {
+	query := `
+		UPDATE queue_items
+		SET status = 'completed', finished_at = ?
+		WHERE id = ? AND queue_name = ?
+	`
+	args := []any{q.clock.Now(), id, q.queueName}
+	if result != nil {
+		resultBytes, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		query = `
+			UPDATE queue_items
+			SET status = 'completed', finished_at = ?, result = ?
+			WHERE id = ? AND queue_name = ?
+		`
+		args = []any{q.clock.Now(), resultBytes, id, q.queueName}
+	}
+
+	stmt, err := q.stmt(query)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Stmt(stmt).Exec(args...)
+	return dbError(err)
+}
+
 // Fail marks a queue item as failed
 func (q *LaQueue) Fail(id int64) error {
-	_, err := q.db.Exec(`
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	_, err := q.exec(`
 		UPDATE queue_items
-		SET status = 'failed'
+		SET status = 'failed', finished_at = ?
 		WHERE id = ? AND queue_name = ?
-	`, id, q.queueName)
+	`, q.clock.Now(), id, q.queueName)
+	if err == nil {
+		notifyWatchers(q.db, id)
+	}
 	return err
 }
 
+// Release returns a processing item to the pending state without counting
+// the interrupted attempt, e.g. when its worker shuts down mid-processing
+func (q *LaQueue) Release(id int64) error {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	return q.releaseLocked(id)
+}
+
 // RetryWithDelay reschedules a failed item with a delay
 func (q *LaQueue) RetryWithDelay(id int64, delay time.Duration) error {
-	scheduledAt := time.Now().Add(delay)
-	_, err := q.db.Exec(`
+	scheduledAt := q.clock.Now().Add(delay)
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	_, err := q.exec(`
 		UPDATE queue_items
 		SET status = 'pending', scheduled_at = ?
 		WHERE id = ? AND queue_name = ?
@@ -151,14 +677,52 @@ func (q *LaQueue) RetryWithDelay(id int64, delay time.Duration) error {
 	return err
 }
 
+// Get returns the item with the given ID, or ErrNotFound
+func (q *LaQueue) Get(id int64) (*QueueItem, error) {
+	return q.getWhere("id = ?", id)
+}
+
+// GetByExternalID returns the item with the given external ID, or ErrNotFound
+func (q *LaQueue) GetByExternalID(externalID string) (*QueueItem, error) {
+	return q.getWhere("external_id = ?", externalID)
+}
+
+// getWhere returns the item of the queue matching condition, or ErrNotFound
+func (q *LaQueue) getWhere(condition string, arg any) (*QueueItem, error) {
+	stmt, err := q.stmt(`
+		SELECT ` + itemColumns + `
+		FROM queue_items
+		WHERE ` + condition + ` AND queue_name = ?
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := scanItem(stmt.QueryRow(arg, q.queueName))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if err := q.decode(item); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
 // Size returns the number of pending items in the queue
 func (q *LaQueue) Size() (int, error) {
 	var count int
-	now := time.Now()
-	err := q.db.QueryRow(`
+	now := q.clock.Now()
+	stmt, err := q.stmt(`
 		SELECT COUNT(*) FROM queue_items
 		WHERE queue_name = ? AND status = 'pending' AND scheduled_at <= ?
-	`, q.queueName, now).Scan(&count)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	err = stmt.QueryRow(q.queueName, now).Scan(&count)
 	return count, err
 }
-
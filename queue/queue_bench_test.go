@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"fmt"
+	"testing"
+)
+
+// prefill inserts n completed items so benchmarks run against a table of realistic size
+func prefill(b *testing.B, q *LaQueue, n int) {
+	b.Helper()
+
+	tx, err := q.db.Begin()
+	if err != nil {
+		b.Fatalf("Failed to begin transaction: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := tx.Exec(
+			`INSERT INTO queue_items (queue_name, payload, status) VALUES (?, ?, 'completed')`,
+			q.queueName, []byte(`{"prefill":true}`),
+		); err != nil {
+			b.Fatalf("Failed to prefill queue: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatalf("Failed to commit prefill: %v", err)
+	}
+}
+
+var benchTableSizes = []int{0, 10000, 100000}
+
+var benchConcurrency = []int{1, 4, 16}
+
+func BenchmarkEnqueue(b *testing.B) {
+	for _, size := range benchTableSizes {
+		for _, conc := range benchConcurrency {
+			b.Run(fmt.Sprintf("rows=%d/parallelism=%d", size, conc), func(b *testing.B) {
+				db, cleanup := setupTestDB(b)
+				defer cleanup()
+
+				q := New(db, "bench_queue")
+				defer q.Close()
+				prefill(b, q, size)
+
+				payload := map[string]string{"message": "benchmark"}
+
+				b.SetParallelism(conc)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if _, err := q.Enqueue(payload); err != nil {
+							b.Errorf("Failed to enqueue item: %v", err)
+							return
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+func BenchmarkDequeueComplete(b *testing.B) {
+	for _, size := range benchTableSizes {
+		for _, conc := range benchConcurrency {
+			b.Run(fmt.Sprintf("rows=%d/parallelism=%d", size, conc), func(b *testing.B) {
+				db, cleanup := setupTestDB(b)
+				defer cleanup()
+
+				q := New(db, "bench_queue")
+				defer q.Close()
+				prefill(b, q, size)
+
+				for i := 0; i < b.N; i++ {
+					if _, err := q.Enqueue(map[string]int{"value": i}); err != nil {
+						b.Fatalf("Failed to enqueue item: %v", err)
+					}
+				}
+
+				b.SetParallelism(conc)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						item, err := q.Dequeue()
+						if err != nil {
+							b.Errorf("Failed to dequeue item: %v", err)
+							return
+						}
+						if item == nil {
+							continue
+						}
+						if err := q.Complete(item.ID); err != nil {
+							b.Errorf("Failed to complete item: %v", err)
+							return
+						}
+					}
+				})
+			})
+		}
+	}
+}
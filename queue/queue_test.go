@@ -1,16 +1,25 @@
 package queue
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"database/sql"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
-func setupTestDB(t *testing.T) (*sql.DB, func()) {
+func setupTestDB(t testing.TB) (*sql.DB, func()) {
 	// Create a temporary database file
 	f, err := os.CreateTemp("", "laqueue_test_*.db")
 	if err != nil {
@@ -26,21 +35,7 @@ func setupTestDB(t *testing.T) (*sql.DB, func()) {
 	}
 
 	// Initialize the schema
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS queue_items (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			queue_name TEXT NOT NULL,
-			payload BLOB NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			scheduled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			status TEXT DEFAULT 'pending',
-			attempts INTEGER DEFAULT 0,
-			last_attempt_at TIMESTAMP,
-			UNIQUE(id, queue_name)
-		);
-		CREATE INDEX IF NOT EXISTS idx_queue_status ON queue_items (queue_name, status, scheduled_at);
-	`)
-	if err != nil {
+	if err := InitSchema(db); err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
 
@@ -53,6 +48,16 @@ func setupTestDB(t *testing.T) (*sql.DB, func()) {
 	return db, cleanup
 }
 
+// inZone runs the rest of the test with the local time zone offset from UTC
+// by the given hours, so that times written or compared in local time while
+// the CURRENT_TIMESTAMP defaults are in UTC show up. Times ahead of UTC sort
+// after their UTC form and times behind it before.
+func inZone(t testing.TB, hours int) {
+	local := time.Local
+	time.Local = time.FixedZone(fmt.Sprintf("UTC%+d", hours), hours*60*60)
+	t.Cleanup(func() { time.Local = local })
+}
+
 func TestEnqueueDequeue(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -133,8 +138,9 @@ func TestEnqueueWithDelay(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	// Create a queue
-	q := New(db, "test_queue")
+	// Create a queue on a clock that only moves when told to
+	clock := NewManualClock(time.Now())
+	q := NewWithOptions(db, "test_queue", Options{Clock: clock})
 
 	// Create a test payload
 	payload := map[string]string{"message": "delayed item"}
@@ -154,8 +160,8 @@ func TestEnqueueWithDelay(t *testing.T) {
 		t.Errorf("Expected no items due to delay, got item with ID %d", item.ID)
 	}
 
-	// Wait for the delay to pass
-	time.Sleep(2100 * time.Millisecond)
+	// Let the delay pass
+	clock.Advance(2100 * time.Millisecond)
 
 	// Now the item should be available
 	item, err = q.Dequeue()
@@ -174,8 +180,9 @@ func TestRetryWithDelay(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	// Create a queue
-	q := New(db, "test_queue")
+	// Create a queue on a clock that only moves when told to
+	clock := NewManualClock(time.Now())
+	q := NewWithOptions(db, "test_queue", Options{Clock: clock})
 
 	// Enqueue an item
 	payload := map[string]string{"message": "retry test"}
@@ -207,8 +214,8 @@ func TestRetryWithDelay(t *testing.T) {
 		t.Errorf("Expected no items due to retry delay, got item with ID %d", item.ID)
 	}
 
-	// Wait for the delay to pass
-	time.Sleep(1100 * time.Millisecond)
+	// Let the delay pass
+	clock.Advance(1100 * time.Millisecond)
 
 	// Now the item should be available again
 	item, err = q.Dequeue()
@@ -226,3 +233,2926 @@ func TestRetryWithDelay(t *testing.T) {
 	}
 }
 
+func TestConcurrentWrites(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Use separate queue instances sharing the same database handle
+	producer := New(db, "test_queue")
+	consumer := New(db, "test_queue")
+
+	const n = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, n*2)
+
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := producer.Enqueue(map[string]int{"value": i}); err != nil {
+				errs <- err
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			item, err := consumer.Dequeue()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if item != nil {
+				if err := consumer.Complete(item.ID); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Unexpected error during concurrent writes: %v", err)
+	}
+}
+
+func TestCloseStatements(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	if _, err := q.Enqueue(map[string]string{"message": "before close"}); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if len(q.stmts) == 0 {
+		t.Fatal("Expected prepared statements to be cached")
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatalf("Failed to close queue: %v", err)
+	}
+	if len(q.stmts) != 0 {
+		t.Errorf("Expected statement cache to be empty, got %d entries", len(q.stmts))
+	}
+
+	// The queue remains usable after Close
+	if _, err := q.Enqueue(map[string]string{"message": "after close"}); err != nil {
+		t.Fatalf("Failed to enqueue item after close: %v", err)
+	}
+	size, err := q.Size()
+	if err != nil {
+		t.Fatalf("Failed to get queue size: %v", err)
+	}
+	if size != 2 {
+		t.Errorf("Expected size 2, got %d", size)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	id, err := q.Enqueue(map[string]string{"message": "watch me"})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	results := q.Watch(context.Background(), id)
+
+	item, err := q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if err := q.Complete(item.ID); err != nil {
+		t.Fatalf("Failed to complete item: %v", err)
+	}
+
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			t.Fatalf("Unexpected watch error: %v", result.Err)
+		}
+		if result.Status != StatusCompleted {
+			t.Errorf("Expected status '%s', got '%s'", StatusCompleted, result.Status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for watch result")
+	}
+
+	// Watching an unknown item reports ErrNotFound
+	result := <-q.Watch(context.Background(), 12345)
+	if !errors.Is(result.Err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", result.Err)
+	}
+}
+
+func TestEnqueueAndWait(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	// Simulate a worker answering the request
+	go func() {
+		for {
+			item, err := q.Dequeue()
+			if err != nil {
+				t.Errorf("Failed to dequeue item: %v", err)
+				return
+			}
+			if item == nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			if err := q.CompleteWithResult(item.ID, map[string]string{"answer": "pong"}); err != nil {
+				t.Errorf("Failed to complete item: %v", err)
+			}
+			return
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := q.EnqueueAndWait(ctx, map[string]string{"message": "ping"})
+	if err != nil {
+		t.Fatalf("Failed to enqueue and wait: %v", err)
+	}
+
+	var decoded map[string]string
+	if err := json.Unmarshal(result, &decoded); err != nil {
+		t.Fatalf("Failed to decode result: %v", err)
+	}
+	if decoded["answer"] != "pong" {
+		t.Errorf("Expected answer 'pong', got '%s'", decoded["answer"])
+	}
+}
+
+func TestInitSchemaMigratesOldDatabase(t *testing.T) {
+	f, err := os.CreateTemp("", "laqueue_test_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	db, err := sql.Open("sqlite3", f.Name())
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	// Create the table as the first release did
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+	// Older versions wrote delays in local time
+	if _, err := db.Exec(`INSERT INTO queue_items (queue_name, payload, scheduled_at) VALUES ('test_queue', '1', '2024-01-01 09:00:00.5+09:00')`); err != nil {
+		t.Fatalf("Failed to insert legacy item: %v", err)
+	}
+
+	if err := InitSchema(db); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	var scheduledAt string
+	if err := db.QueryRow(`SELECT scheduled_at || '' FROM queue_items`).Scan(&scheduledAt); err != nil {
+		t.Fatalf("Failed to read legacy item: %v", err)
+	}
+	if scheduledAt != "2024-01-01 00:00:00.500+00:00" {
+		t.Errorf("Expected the legacy time converted to UTC, got %s", scheduledAt)
+	}
+
+	for _, col := range migrations {
+		columns, err := tableColumns(db, col.table)
+		if err != nil {
+			t.Fatalf("Failed to read columns: %v", err)
+		}
+		if !columns[col.name] {
+			t.Errorf("Expected column '%s.%s' to be added", col.table, col.name)
+		}
+	}
+
+	// Running it again is a no-op
+	if err := InitSchema(db); err != nil {
+		t.Fatalf("Failed to re-run schema initialization: %v", err)
+	}
+}
+
+func TestEnqueueWithRetrySchedule(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	schedule := []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
+	id, err := q.EnqueueWithOptions(map[string]string{"message": "webhook"}, EnqueueOptions{
+		RetrySchedule: schedule,
+	})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	item, err := q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item == nil || item.ID != id {
+		t.Fatalf("Expected item %d, got %v", id, item)
+	}
+	if len(item.RetrySchedule) != len(schedule) {
+		t.Fatalf("Expected %d schedule entries, got %d", len(schedule), len(item.RetrySchedule))
+	}
+	for i := range schedule {
+		if item.RetrySchedule[i] != schedule[i] {
+			t.Errorf("Expected schedule[%d] = %v, got %v", i, schedule[i], item.RetrySchedule[i])
+		}
+	}
+}
+
+func TestLockKey(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	first, err := q.EnqueueWithOptions(map[string]int{"order": 1}, EnqueueOptions{LockKey: "customer-42"})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	second, err := q.EnqueueWithOptions(map[string]int{"order": 2}, EnqueueOptions{LockKey: "customer-42"})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	other, err := q.EnqueueWithOptions(map[string]int{"order": 3}, EnqueueOptions{LockKey: "customer-7"})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	item, err := q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item == nil || item.ID != first {
+		t.Fatalf("Expected item %d, got %v", first, item)
+	}
+	if item.LockKey != "customer-42" {
+		t.Errorf("Expected lock key 'customer-42', got '%s'", item.LockKey)
+	}
+
+	// The second item shares the key of a processing item and is skipped
+	item, err = q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item == nil || item.ID != other {
+		t.Fatalf("Expected item %d, got %v", other, item)
+	}
+
+	item, err = q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item != nil {
+		t.Fatalf("Expected no item while the key is held, got item %d", item.ID)
+	}
+
+	// Once the first item finishes, the second becomes available
+	if err := q.Complete(first); err != nil {
+		t.Fatalf("Failed to complete item: %v", err)
+	}
+	item, err = q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item == nil || item.ID != second {
+		t.Fatalf("Expected item %d, got %v", second, item)
+	}
+}
+
+func TestAttemptStats(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	id, err := q.Enqueue(map[string]string{"message": "flaky"})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	started := time.Now()
+	attempts := []Attempt{
+		{ItemID: id, Attempt: 1, WorkerID: "host-a:1", StartedAt: started, FinishedAt: started.Add(time.Second), Error: "timeout"},
+		{ItemID: id, Attempt: 2, WorkerID: "host-b:2", StartedAt: started.Add(time.Minute), FinishedAt: started.Add(time.Minute + 500*time.Millisecond)},
+	}
+	for _, a := range attempts {
+		if err := q.RecordAttempt(a); err != nil {
+			t.Fatalf("Failed to record attempt: %v", err)
+		}
+	}
+
+	stats, err := q.AttemptStats(id)
+	if err != nil {
+		t.Fatalf("Failed to get attempt stats: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(stats))
+	}
+	if stats[0].Error != "timeout" || stats[0].WorkerID != "host-a:1" || stats[0].Duration != time.Second {
+		t.Errorf("Unexpected first attempt: %+v", stats[0])
+	}
+	if stats[1].Error != "" || stats[1].Attempt != 2 || stats[1].Duration != 500*time.Millisecond {
+		t.Errorf("Unexpected second attempt: %+v", stats[1])
+	}
+}
+
+func TestRetention(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	id, err := q.Enqueue(map[string]string{"message": "export"})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if _, err := q.Dequeue(); err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if err := q.CompleteWithResult(id, map[string]string{"file": "large"}); err != nil {
+		t.Fatalf("Failed to complete item: %v", err)
+	}
+
+	// Nothing is old enough yet
+	if n, err := q.PurgeResults(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("Expected no results purged, got %d (err=%v)", n, err)
+	}
+
+	// Dropping results keeps the item
+	if n, err := q.PurgeResults(time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("Expected 1 result purged, got %d (err=%v)", n, err)
+	}
+	item, err := q.Get(id)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if item.Result != nil {
+		t.Errorf("Expected result to be dropped, got %s", item.Result)
+	}
+	if item.FinishedAt == nil {
+		t.Error("Expected finished_at to be set")
+	}
+
+	// Purging deletes the item
+	if n, err := q.Purge(time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("Expected 1 item purged, got %d (err=%v)", n, err)
+	}
+	if _, err := q.Get(id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after purge, got %v", err)
+	}
+}
+
+func TestPurgeWithOptions(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start := time.Now()
+	clock := NewManualClock(start)
+	q := NewWithOptions(db, "test_queue", Options{Clock: clock})
+
+	// One item finished per hour: completed, failed, completed
+	var ids []int64
+	for i, fail := range []bool{false, true, false} {
+		id, err := q.Enqueue(map[string]int{"value": i})
+		if err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		if _, err := q.Dequeue(); err != nil {
+			t.Fatalf("Failed to dequeue item: %v", err)
+		}
+		if fail {
+			err = q.Fail(id)
+		} else {
+			err = q.Complete(id)
+		}
+		if err != nil {
+			t.Fatalf("Failed to finish item: %v", err)
+		}
+		ids = append(ids, id)
+		clock.Advance(time.Hour)
+	}
+	pending, err := q.Enqueue(map[string]int{"value": 3})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	// Counts can be restricted like List
+	counts, err := q.CountsWithOptions(ListOptions{Status: StatusPending})
+	if err != nil {
+		t.Fatalf("Failed to count items: %v", err)
+	}
+	if len(counts) != 1 || counts[StatusPending] != 1 {
+		t.Errorf("Expected 1 pending item, got %v", counts)
+	}
+
+	// Only the item finished in the window is purged
+	n, err := q.PurgeWithOptions(PurgeOptions{Since: start.Add(30 * time.Minute), Before: start.Add(90 * time.Minute)})
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 item purged, got %d (err=%v)", n, err)
+	}
+	if _, err := q.Get(ids[1]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for the failed item, got %v", err)
+	}
+
+	// A zero Before purges up to now, pending items are kept
+	n, err = q.PurgeWithOptions(PurgeOptions{Status: StatusCompleted})
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 items purged, got %d (err=%v)", n, err)
+	}
+	if _, err := q.Get(pending); err != nil {
+		t.Errorf("Expected the pending item to be kept, got %v", err)
+	}
+
+	if _, err := q.PurgeWithOptions(PurgeOptions{Status: StatusPending}); err == nil {
+		t.Error("Expected an error purging pending items")
+	}
+}
+
+func TestEach(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	// Span several pages
+	const n = eachPageSize*2 + 10
+	for i := 0; i < n; i++ {
+		if _, err := q.Enqueue(map[string]int{"value": i}); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+	}
+
+	var count int
+	var lastID int64
+	err := q.Each(StatusPending, func(item *QueueItem) error {
+		if item.ID <= lastID {
+			t.Fatalf("Expected increasing IDs, got %d after %d", item.ID, lastID)
+		}
+		lastID = item.ID
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to iterate items: %v", err)
+	}
+	if count != n {
+		t.Errorf("Expected %d items, got %d", n, count)
+	}
+
+	// Errors from the callback stop the iteration
+	stop := errors.New("stop")
+	count = 0
+	err = q.Each("", func(item *QueueItem) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || count != 3 {
+		t.Errorf("Expected iteration to stop after 3 items with the callback error, got %d items (err=%v)", count, err)
+	}
+
+	// No completed items
+	err = q.Each(StatusCompleted, func(item *QueueItem) error {
+		t.Errorf("Unexpected completed item %d", item.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to iterate items: %v", err)
+	}
+}
+
+func TestBoost(t *testing.T) {
+	inZone(t, 9)
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	// Items written with the default UTC times are jumped too
+	if _, err := db.Exec(`INSERT INTO queue_items (queue_name, payload) VALUES ('test_queue', '"legacy"')`); err != nil {
+		t.Fatalf("Failed to insert item: %v", err)
+	}
+
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		id, err := q.Enqueue(map[string]int{"value": i})
+		if err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	delayed, err := q.EnqueueWithDelay(map[string]string{"message": "later"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	// A boosted delayed item becomes eligible and jumps the line
+	if err := q.Boost(delayed); err != nil {
+		t.Fatalf("Failed to boost item: %v", err)
+	}
+	item, err := q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item == nil || item.ID != delayed {
+		t.Fatalf("Expected boosted item %d, got %v", delayed, item)
+	}
+
+	if err := q.Boost(ids[2]); err != nil {
+		t.Fatalf("Failed to boost item: %v", err)
+	}
+	item, err = q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item == nil || item.ID != ids[2] {
+		t.Fatalf("Expected boosted item %d, got %v", ids[2], item)
+	}
+
+	// Only pending items can be boosted
+	if err := q.Boost(ids[2]); !errors.Is(err, ErrNotPending) {
+		t.Errorf("Expected ErrNotPending, got %v", err)
+	}
+	if err := q.Boost(12345); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestEnqueueFanout(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	payloads := []any{
+		map[string]int{"value": 0},
+		map[string]int{"value": 1},
+		map[string]int{"value": 2},
+		map[string]int{"value": 3},
+	}
+	ids, err := q.EnqueueFanout(payloads, 4*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to enqueue fanout: %v", err)
+	}
+	if len(ids) != len(payloads) {
+		t.Fatalf("Expected %d IDs, got %d", len(payloads), len(ids))
+	}
+
+	// Only the first item is eligible right away
+	size, err := q.Size()
+	if err != nil {
+		t.Fatalf("Failed to get queue size: %v", err)
+	}
+	if size != 1 {
+		t.Errorf("Expected 1 eligible item, got %d", size)
+	}
+
+	// Items are spaced by spread/len(payloads)
+	first, err := q.Get(ids[0])
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	for i, id := range ids[1:] {
+		item, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Failed to get item: %v", err)
+		}
+		want := time.Duration(i+1) * time.Hour
+		if got := item.ScheduledAt.Sub(first.ScheduledAt); got != want {
+			t.Errorf("Expected item %d to be scheduled %v after the first, got %v", id, want, got)
+		}
+	}
+}
+
+func TestDequeueSelector(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	usID, err := q.EnqueueWithOptions(map[string]string{"message": "us"}, EnqueueOptions{
+		Metadata: map[string]string{"region": "us"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	euID, err := q.EnqueueWithOptions(map[string]string{"message": "eu"}, EnqueueOptions{
+		Metadata: map[string]string{"region": "eu", "gpu": "true"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	// An EU GPU worker skips the US item
+	item, err := q.DequeueWithOptions(DequeueOptions{Selector: map[string]string{"region": "eu", "gpu": "true"}})
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item == nil || item.ID != euID {
+		t.Fatalf("Expected item %d, got %v", euID, item)
+	}
+	if item.Metadata["region"] != "eu" {
+		t.Errorf("Expected metadata region 'eu', got %v", item.Metadata)
+	}
+
+	item, err = q.DequeueWithOptions(DequeueOptions{Selector: map[string]string{"region": "eu"}})
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item != nil {
+		t.Fatalf("Expected no EU item left, got item %d", item.ID)
+	}
+
+	// Without a selector, any item can be claimed
+	item, err = q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item == nil || item.ID != usID {
+		t.Fatalf("Expected item %d, got %v", usID, item)
+	}
+}
+
+func TestDraftPublish(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	var drafts []int64
+	for i := 0; i < 3; i++ {
+		id, err := q.EnqueueWithOptions(map[string]int{"line": i}, EnqueueOptions{Draft: true})
+		if err != nil {
+			t.Fatalf("Failed to enqueue draft: %v", err)
+		}
+		drafts = append(drafts, id)
+	}
+
+	// Drafts are not claimed
+	item, err := q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item != nil {
+		t.Fatalf("Expected drafts to be invisible, got item %d", item.ID)
+	}
+
+	// Publishing is all-or-nothing
+	if err := q.Publish(drafts[0], 12345); !errors.Is(err, ErrNotDraft) {
+		t.Fatalf("Expected ErrNotDraft, got %v", err)
+	}
+	if size, _ := q.Size(); size != 0 {
+		t.Fatalf("Expected failed publish to be rolled back, got %d pending items", size)
+	}
+
+	if err := q.Discard(drafts[2]); err != nil {
+		t.Fatalf("Failed to discard draft: %v", err)
+	}
+	if _, err := q.Get(drafts[2]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected discarded draft to be deleted, got %v", err)
+	}
+
+	if err := q.Publish(drafts[0], drafts[1]); err != nil {
+		t.Fatalf("Failed to publish drafts: %v", err)
+	}
+	if size, _ := q.Size(); size != 2 {
+		t.Errorf("Expected 2 pending items after publish, got %d", size)
+	}
+}
+
+func TestExactlyOnce(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	if _, err := db.Exec(`CREATE TABLE charges (id INTEGER PRIMARY KEY, amount INTEGER)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	charge := func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO charges (amount) VALUES (100)`)
+		return err
+	}
+
+	// A failing attempt doesn't record the key nor its writes
+	boom := errors.New("boom")
+	ran, err := q.ExactlyOnce("order-1", func(tx *sql.Tx) error {
+		if err := charge(tx); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) || ran {
+		t.Fatalf("Expected the error from fn, got ran=%v err=%v", ran, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		ran, err := q.ExactlyOnce("order-1", charge)
+		if err != nil {
+			t.Fatalf("Failed to run once: %v", err)
+		}
+		if ran != (i == 0) {
+			t.Errorf("Call %d: expected ran=%v, got %v", i, i == 0, ran)
+		}
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM charges`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count charges: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected exactly 1 charge, got %d", count)
+	}
+
+	// Within the caller's transaction, a failing fn only undoes its own writes
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO charges (amount) VALUES (1)`); err != nil {
+		t.Fatalf("Failed to insert charge: %v", err)
+	}
+	ran, err = q.ExactlyOnceTx(tx, "order-2", func(tx *sql.Tx) error {
+		if err := charge(tx); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) || ran {
+		t.Fatalf("Expected the error from fn, got ran=%v err=%v", ran, err)
+	}
+	if ran, err := q.ExactlyOnceTx(tx, "order-2", charge); err != nil || !ran {
+		t.Fatalf("Expected fn to run, got ran=%v err=%v", ran, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if ran, err := q.ExactlyOnce("order-2", charge); err != nil || ran {
+		t.Errorf("Expected the key to be committed with the transaction, got ran=%v err=%v", ran, err)
+	}
+	if err := db.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM charges`).Scan(&count); err != nil {
+		t.Fatalf("Failed to sum charges: %v", err)
+	}
+	if count != 201 {
+		t.Errorf("Expected charges of 201, got %d", count)
+	}
+}
+
+func TestCorruptItemsAreQuarantined(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	// Items written without a checksum, e.g. by older versions, are trusted.
+	// Their second-precision default times sort first.
+	result, err := db.Exec(`INSERT INTO queue_items (queue_name, payload) VALUES ('test_queue', '"legacy"')`)
+	if err != nil {
+		t.Fatalf("Failed to insert item: %v", err)
+	}
+	legacyID, _ := result.LastInsertId()
+	corruptID, err := q.Enqueue("first")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	validID, err := q.Enqueue("second")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE queue_items SET payload = '"tampered"' WHERE id = ?`, corruptID); err != nil {
+		t.Fatalf("Failed to tamper with item: %v", err)
+	}
+
+	item, err := q.Dequeue()
+	if err != nil || item == nil || item.ID != legacyID {
+		t.Fatalf("Expected the legacy item %d, got %+v, %v", legacyID, item, err)
+	}
+
+	_, err = q.Dequeue()
+	var corrupt *CorruptItemError
+	if !errors.As(err, &corrupt) || corrupt.ID != corruptID || !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Expected a CorruptItemError for item %d, got %v", corruptID, err)
+	}
+
+	item, err = q.Get(corruptID)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if item.Status != StatusCorrupt {
+		t.Errorf("Expected status %s, got %s", StatusCorrupt, item.Status)
+	}
+
+	item, err = q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item == nil || item.ID != validID {
+		t.Fatalf("Expected item %d, got %+v", validID, item)
+	}
+}
+
+func TestList(t *testing.T) {
+	inZone(t, 9)
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	first, _ := q.Enqueue("first")
+	soon, _ := q.EnqueueWithDelay("soon", 5*time.Minute)
+	later, _ := q.EnqueueWithDelay("later", time.Hour)
+
+	ids := func(items []*QueueItem) []int64 {
+		var ids []int64
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want []int64
+	}{
+		{"all", ListOptions{}, []int64{first, soon, later}},
+		{"descending with limit", ListOptions{Descending: true, Limit: 2}, []int64{later, soon}},
+		{"by schedule", ListOptions{Sort: "scheduled_at", Descending: true}, []int64{later, soon, first}},
+		{"scheduled within", ListOptions{ScheduledWithin: 10 * time.Minute}, []int64{soon}},
+		{"since", ListOptions{Since: time.Now().Add(-time.Hour)}, []int64{first, soon, later}},
+		{"before", ListOptions{Before: time.Now().Add(-time.Hour)}, nil},
+	}
+	for _, tt := range tests {
+		items, err := q.List(tt.opts)
+		if err != nil {
+			t.Fatalf("%s: failed to list items: %v", tt.name, err)
+		}
+		if got := ids(items); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := q.List(ListOptions{Sort: "payload"}); err == nil {
+		t.Error("Expected an error sorting by an unknown column")
+	}
+}
+
+func TestQueueConfig(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	config, err := q.Config()
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	if config != (QueueConfig{}) {
+		t.Errorf("Expected a zero config, got %+v", config)
+	}
+
+	for _, want := range []QueueConfig{
+		{MaxRetries: 5, Retention: 7 * 24 * time.Hour},
+		{ResultRetention: time.Hour},
+	} {
+		if err := q.SetConfig(want); err != nil {
+			t.Fatalf("Failed to store config: %v", err)
+		}
+		got, err := q.Config()
+		if err != nil {
+			t.Fatalf("Failed to read config: %v", err)
+		}
+		if got != want {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+
+	// Other queues are unaffected
+	if config, _ := New(db, "other_queue").Config(); config != (QueueConfig{}) {
+		t.Errorf("Expected a zero config for another queue, got %+v", config)
+	}
+}
+
+func TestDequeueRecordsWorker(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	id, err := q.Enqueue("job")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	item, err := q.DequeueWithOptions(DequeueOptions{WorkerID: "host:42"})
+	if err != nil || item == nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item.ClaimedBy != "host:42" {
+		t.Errorf("Expected the dequeued item to be claimed by host:42, got %q", item.ClaimedBy)
+	}
+
+	stored, err := q.Get(id)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if stored.ClaimedBy != "host:42" || stored.LastAttemptAt == nil {
+		t.Errorf("Expected the claim to be stored, got owner %q since %v", stored.ClaimedBy, stored.LastAttemptAt)
+	}
+}
+
+func TestResetAttempts(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		id, err := q.Enqueue(i)
+		if err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		ids = append(ids, id)
+		if _, err := q.Dequeue(); err != nil {
+			t.Fatalf("Failed to dequeue item: %v", err)
+		}
+		if err := q.Fail(id); err != nil {
+			t.Fatalf("Failed to fail item: %v", err)
+		}
+	}
+
+	attempts := func(id int64) int {
+		item, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Failed to get item: %v", err)
+		}
+		return item.Attempts
+	}
+
+	// A missing item aborts the whole reset
+	if err := q.ResetAttempts(ids[0], 999); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if got := attempts(ids[0]); got != 1 {
+		t.Errorf("Expected item %d to keep its attempt, got %d", ids[0], got)
+	}
+
+	if err := q.ResetAttempts(ids[0]); err != nil {
+		t.Fatalf("Failed to reset attempts: %v", err)
+	}
+	if got := attempts(ids[0]); got != 0 {
+		t.Errorf("Expected item %d to have no attempts, got %d", ids[0], got)
+	}
+
+	n, err := q.ResetAttemptsByStatus(StatusFailed)
+	if err != nil {
+		t.Fatalf("Failed to reset attempts: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 items reset, got %d", n)
+	}
+	for _, id := range ids {
+		if got := attempts(id); got != 0 {
+			t.Errorf("Expected item %d to have no attempts, got %d", id, got)
+		}
+	}
+}
+
+func TestPauseWindows(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+	other := New(db, "other_queue")
+
+	if _, err := q.Enqueue("job"); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if _, err := other.Enqueue("job"); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	// A weekly window that started a week ago and is in its second occurrence
+	now := time.Now()
+	id, err := q.SchedulePause(PauseWindow{
+		Start: now.Add(-7*24*time.Hour - time.Minute),
+		End:   now.Add(-7*24*time.Hour + time.Hour),
+		Every: 7 * 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to schedule pause: %v", err)
+	}
+
+	if paused, err := q.Paused(now); err != nil || !paused {
+		t.Fatalf("Expected the queue to be paused, got %v (%v)", paused, err)
+	}
+	if paused, _ := q.Paused(now.Add(2 * time.Hour)); paused {
+		t.Error("Expected the queue not to be paused after the window")
+	}
+
+	item, err := q.Dequeue()
+	if err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if item != nil {
+		t.Fatalf("Expected no item while paused, got %d", item.ID)
+	}
+
+	// Other queues are unaffected
+	if item, err := other.Dequeue(); err != nil || item == nil {
+		t.Fatalf("Expected an item from another queue, got %v (%v)", item, err)
+	}
+
+	if err := q.DeletePause(id); err != nil {
+		t.Fatalf("Failed to delete pause: %v", err)
+	}
+	if item, err := q.Dequeue(); err != nil || item == nil {
+		t.Fatalf("Expected an item once unpaused, got %v (%v)", item, err)
+	}
+
+	if _, err := q.SchedulePause(PauseWindow{Start: now, End: now.Add(-time.Hour)}); err == nil {
+		t.Error("Expected an error for a window ending before it starts")
+	}
+}
+
+// rot13 is a test codec that fails to decode payloads starting with '!'
+type rot13 struct{}
+
+func (rot13) Encode(payload []byte) ([]byte, error) {
+	out := make([]byte, len(payload))
+	for i, b := range payload {
+		switch {
+		case b >= 'a' && b <= 'z':
+			out[i] = 'a' + (b-'a'+13)%26
+		default:
+			out[i] = b
+		}
+	}
+	return out, nil
+}
+
+func (c rot13) Decode(payload []byte) ([]byte, error) {
+	if len(payload) > 0 && payload[0] == '!' {
+		return nil, errors.New("unreadable")
+	}
+	return c.Encode(payload)
+}
+
+func TestCodecs(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := NewWithOptions(db, "test_queue", Options{Codecs: []Codec{rot13{}, Gzip{}}})
+
+	id, err := q.Enqueue(map[string]string{"message": "hello"})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	// Stored rot13'd then gzipped
+	var stored []byte
+	if err := db.QueryRow(`SELECT payload FROM queue_items WHERE id = ?`, id).Scan(&stored); err != nil {
+		t.Fatalf("Failed to read payload: %v", err)
+	}
+	raw, err := Gzip{}.Decode(stored)
+	if err != nil {
+		t.Fatalf("Expected a gzipped payload: %v", err)
+	}
+	if string(raw) != `{"zrffntr":"uryyb"}` {
+		t.Errorf("Expected the payload to be encoded in order, got %s", raw)
+	}
+
+	item, err := q.Dequeue()
+	if err != nil || item == nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if string(item.Payload) != `{"message":"hello"}` {
+		t.Errorf("Expected the decoded payload, got %s", item.Payload)
+	}
+
+	// Items the codecs cannot decode are quarantined
+	badID, err := New(db, "test_queue").Enqueue("!")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	db.Exec(`UPDATE queue_items SET payload = '!', checksum = NULL WHERE id = ?`, badID)
+	_, err = q.Dequeue()
+	var corrupt *CorruptItemError
+	if !errors.As(err, &corrupt) || corrupt.ID != badID || corrupt.Err == nil {
+		t.Fatalf("Expected a CorruptItemError with the codec error, got %v", err)
+	}
+}
+
+func TestPayloadUpgrades(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	v1 := New(db, "test_queue")
+	v3 := NewWithOptions(db, "test_queue", Options{
+		PayloadVersion: 3,
+		Upgrades: map[int]Upgrade{
+			// Unversioned items are version 0
+			0: func(payload []byte) ([]byte, error) {
+				return []byte(`{"name":` + string(payload) + `}`), nil
+			},
+			1: func(payload []byte) ([]byte, error) {
+				var v map[string]any
+				if err := json.Unmarshal(payload, &v); err != nil {
+					return nil, err
+				}
+				v["version"] = 2
+				return json.Marshal(v)
+			},
+			2: func(payload []byte) ([]byte, error) { return payload, nil },
+		},
+	})
+	v4 := NewWithOptions(db, "test_queue", Options{PayloadVersion: 4})
+
+	// A newer release's item is left alone by older workers
+	if _, err := v4.Enqueue(map[string]string{"full_name": "later"}); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	oldID, err := v1.Enqueue("ada")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	currentID, err := v3.Enqueue(map[string]string{"name": "grace"})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	want := map[int64]string{
+		oldID:     `{"name":"ada","version":2}`,
+		currentID: `{"name":"grace"}`,
+	}
+	for i := 0; i < 2; i++ {
+		item, err := v3.Dequeue()
+		if err != nil || item == nil {
+			t.Fatalf("Failed to dequeue item: %v", err)
+		}
+		if string(item.Payload) != want[item.ID] {
+			t.Errorf("Item %d: expected payload %s, got %s", item.ID, want[item.ID], item.Payload)
+		}
+		if item.PayloadVersion != 3 {
+			t.Errorf("Item %d: expected payload version 3, got %d", item.ID, item.PayloadVersion)
+		}
+	}
+
+	if item, err := v3.Dequeue(); err != nil || item != nil {
+		t.Fatalf("Expected the newer item to be left alone, got %v (%v)", item, err)
+	}
+}
+
+func TestDequeueOrder(t *testing.T) {
+	inZone(t, 9)
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	// Created in order, but the newest item became eligible first
+	var ids []int64
+	for i, priority := range []int{0, 5, 1} {
+		id, err := q.EnqueueWithOptions(i, EnqueueOptions{Priority: priority})
+		if err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := db.Exec(`UPDATE queue_items SET scheduled_at = ? WHERE id = ?`, time.Now().UTC().Add(-time.Minute), ids[2]); err != nil {
+		t.Fatalf("Failed to reschedule item: %v", err)
+	}
+
+	tests := []struct {
+		order Order
+		want  int64
+	}{
+		{"", ids[2]},
+		{OrderScheduled, ids[2]},
+		{OrderFIFO, ids[0]},
+		{OrderLIFO, ids[2]},
+		{OrderPriority, ids[1]},
+	}
+	for _, tt := range tests {
+		item, err := q.DequeueWithOptions(DequeueOptions{Order: tt.order})
+		if err != nil || item == nil {
+			t.Fatalf("%q: failed to dequeue item: %v", tt.order, err)
+		}
+		if item.ID != tt.want {
+			t.Errorf("%q: expected item %d, got %d", tt.order, tt.want, item.ID)
+		}
+		// Put it back for the next strategy
+		if err := q.Release(item.ID); err != nil {
+			t.Fatalf("Failed to release item: %v", err)
+		}
+	}
+
+	if item, err := q.DequeueWithOptions(DequeueOptions{Order: OrderRandom}); err != nil || item == nil {
+		t.Fatalf("Failed to dequeue a random item: %v", err)
+	}
+	if _, err := q.DequeueWithOptions(DequeueOptions{Order: "oldest"}); err == nil {
+		t.Error("Expected an error for an unknown order")
+	}
+}
+
+func TestDequeueGroup(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+
+	enqueue := func(payload string, opts EnqueueOptions) int64 {
+		id, err := q.EnqueueWithOptions(payload, opts)
+		if err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		return id
+	}
+	a1 := enqueue("a1", EnqueueOptions{GroupKey: "invoice-1"})
+	b := enqueue("b", EnqueueOptions{})
+	a2 := enqueue("a2", EnqueueOptions{GroupKey: "invoice-1"})
+	// Members the worker couldn't claim on their own are left out
+	a3 := enqueue("a3", EnqueueOptions{GroupKey: "invoice-1", Delay: time.Hour})
+	enqueue("a-gpu", EnqueueOptions{GroupKey: "invoice-1", Requires: map[string]string{"gpu": "yes"}})
+
+	group, err := q.DequeueGroup(DequeueOptions{WorkerID: "w1"})
+	if err != nil {
+		t.Fatalf("Failed to dequeue group: %v", err)
+	}
+	if got := fmt.Sprint(itemIDs(group)); got != fmt.Sprint([]int64{a1, a2}) {
+		t.Fatalf("Expected the eligible members of the group, got %s", got)
+	}
+	for _, item := range group {
+		if item.Status != StatusProcessing || item.Attempts != 1 || item.ClaimedBy != "w1" {
+			t.Errorf("Expected item %d to be claimed, got %+v", item.ID, item)
+		}
+	}
+
+	// Ungrouped items come alone, and late members wait for the group
+	a4 := enqueue("a4", EnqueueOptions{GroupKey: "invoice-1"})
+	single, err := q.DequeueGroup(DequeueOptions{})
+	if err != nil || len(single) != 1 || single[0].ID != b {
+		t.Fatalf("Expected item %d alone, got %v (%v)", b, single, err)
+	}
+	if item, err := q.Dequeue(); err != nil || item != nil {
+		t.Fatalf("Expected no item while the group is processing, got %v (%v)", item, err)
+	}
+
+	if err := q.CompleteGroup([]int64{a1, a2}, "reconciled"); err != nil {
+		t.Fatalf("Failed to complete group: %v", err)
+	}
+	if item, err := q.Get(a3); err != nil || item.Status != StatusPending {
+		t.Errorf("Expected the delayed member to stay pending, got %v (%v)", item, err)
+	}
+	for _, id := range []int64{a1, a2} {
+		item, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Failed to get item: %v", err)
+		}
+		if item.Status != StatusCompleted || string(item.Result) != `"reconciled"` {
+			t.Errorf("Expected item %d to be completed with the result, got %s %s", id, item.Status, item.Result)
+		}
+	}
+
+	if item, err := q.Dequeue(); err != nil || item == nil || item.ID != a4 {
+		t.Fatalf("Expected the late member once the group finished, got %v (%v)", item, err)
+	}
+
+	// A failure to claim the members releases the lead
+	c1 := enqueue("c1", EnqueueOptions{GroupKey: "invoice-2"})
+	c2 := enqueue("c2", EnqueueOptions{GroupKey: "invoice-2"})
+	_, err = db.Exec(fmt.Sprintf(`
+		CREATE TRIGGER test_fail_claim BEFORE UPDATE OF status ON queue_items WHEN OLD.id = %d
+		BEGIN SELECT RAISE(ABORT, 'claim failed'); END
+	`, c2))
+	if err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+	if group, err := q.DequeueGroup(DequeueOptions{}); err == nil {
+		t.Fatalf("Expected the claim to fail, got %v", itemIDs(group))
+	}
+	if item, err := q.Get(c1); err != nil || item.Status != StatusPending || item.Attempts != 0 {
+		t.Errorf("Expected the lead to be released, got %+v (%v)", item, err)
+	}
+}
+
+func itemIDs(items []*QueueItem) []int64 {
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+func TestMirror(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	all := NewWithOptions(db, "test_queue", Options{Mirror: &Mirror{Queue: "shadow", Rate: 1}})
+	none := NewWithOptions(db, "test_queue", Options{Mirror: &Mirror{Queue: "shadow", Rate: 0}})
+	shadow := New(db, "shadow")
+
+	id, err := all.EnqueueWithOptions("mirrored", EnqueueOptions{Metadata: map[string]string{"region": "eu"}})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if _, err := none.Enqueue("not mirrored"); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	if size, _ := all.Size(); size != 2 {
+		t.Errorf("Expected 2 items in the queue, got %d", size)
+	}
+	if size, _ := shadow.Size(); size != 1 {
+		t.Fatalf("Expected 1 item in the shadow queue, got %d", size)
+	}
+
+	copied, err := shadow.Dequeue()
+	if err != nil || copied == nil {
+		t.Fatalf("Failed to dequeue the copy: %v", err)
+	}
+	if copied.ID == id || string(copied.Payload) != `"mirrored"` || copied.Metadata["region"] != "eu" {
+		t.Errorf("Expected a copy of item %d, got %+v", id, copied)
+	}
+
+	// Copies don't carry the keys identifying the original
+	n := 0
+	keyed := NewWithOptions(db, "keyed", Options{
+		Mirror:      &Mirror{Queue: "keyed_shadow", Rate: 1},
+		ExternalIDs: func() string { n++; return fmt.Sprintf("ext-%d", n) },
+	})
+	if _, err := keyed.EnqueueWithOptions("direct", EnqueueOptions{DedupKey: "a"}); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if _, err := keyed.EnqueueTx(tx, "in tx", EnqueueOptions{DedupKey: "b"}); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	results, err := EnqueueBatch(db, []BatchItem{{Queue: "keyed", Payload: "batched", Options: EnqueueOptions{DedupKey: "c"}}}, Options{
+		Mirror: &Mirror{Queue: "keyed_shadow", Rate: 1},
+	})
+	if err != nil || results[0].Err != nil {
+		t.Fatalf("Failed to enqueue batch: %+v, %v", results, err)
+	}
+
+	var copies []*QueueItem
+	if err := New(db, "keyed_shadow").Each("", func(item *QueueItem) error { copies = append(copies, item); return nil }); err != nil {
+		t.Fatalf("Failed to read copies: %v", err)
+	}
+	if len(copies) != 3 {
+		t.Fatalf("Expected 3 copies, got %d", len(copies))
+	}
+	for _, item := range copies {
+		if item.DedupKey != "" || item.ExternalID != "" {
+			t.Errorf("Expected copy %s without keys, got dedup key %q and external ID %q", item.Payload, item.DedupKey, item.ExternalID)
+		}
+	}
+	if item, err := keyed.GetByExternalID("ext-1"); err != nil || string(item.Payload) != `"direct"` || item.DedupKey != "a" {
+		t.Errorf("Expected the original by its external ID, got %+v, %v", item, err)
+	}
+}
+
+type localeKey struct{}
+
+func TestEnqueueContext(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	propagators := []Propagator{{
+		Key: "locale",
+		Extract: func(ctx context.Context) (string, bool) {
+			locale, ok := ctx.Value(localeKey{}).(string)
+			return locale, ok
+		},
+		Inject: func(ctx context.Context, value string) context.Context {
+			return context.WithValue(ctx, localeKey{}, value)
+		},
+	}}
+	q := NewWithOptions(db, "test_queue", Options{Propagators: propagators})
+
+	ctx := context.WithValue(context.Background(), localeKey{}, "fr-FR")
+	captured, err := q.EnqueueContext(ctx, "captured", EnqueueOptions{})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	explicit := map[string]string{"locale": "en-US"}
+	overridden, err := q.EnqueueContext(ctx, "overridden", EnqueueOptions{Metadata: explicit})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	none, err := q.EnqueueContext(context.Background(), "none", EnqueueOptions{})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	for id, want := range map[int64]string{captured: "fr-FR", overridden: "en-US", none: ""} {
+		item, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Failed to get item: %v", err)
+		}
+		if got := item.Metadata["locale"]; got != want {
+			t.Errorf("Item %d: expected locale %q, got %q", id, want, got)
+		}
+
+		restored := RestoreContext(context.Background(), propagators, item.Metadata)
+		if got, _ := restored.Value(localeKey{}).(string); got != want {
+			t.Errorf("Item %d: expected restored locale %q, got %q", id, want, got)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := q.EnqueueContext(cancelled, "late", EnqueueOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDBErrors(t *testing.T) {
+	f, err := os.CreateTemp("", "laqueue_test_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	db, err := sql.Open("sqlite3", f.Name()+"?_busy_timeout=50")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if err := InitSchema(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	q := New(db, "test_queue")
+
+	// Another process holding the write lock
+	other, err := sql.Open("sqlite3", f.Name())
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer other.Close()
+	conn, err := other.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "BEGIN IMMEDIATE"); err != nil {
+		t.Fatalf("Failed to lock database: %v", err)
+	}
+
+	_, err = q.Enqueue("busy")
+	if !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected ErrBusy, got %v", err)
+	}
+	var driverErr sqlite3.Error
+	if !errors.As(err, &driverErr) || driverErr.Code != sqlite3.ErrBusy {
+		t.Errorf("Expected the driver error to be reachable, got %v", err)
+	}
+
+	conn.ExecContext(context.Background(), "ROLLBACK")
+	conn.Close()
+
+	if _, err := q.Enqueue("ok"); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if _, err := db.Exec(`CREATE UNIQUE INDEX test_unique_payload ON queue_items (payload)`); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	if _, err := q.Enqueue("ok"); !errors.Is(err, ErrConstraint) {
+		t.Errorf("Expected ErrConstraint, got %v", err)
+	}
+
+	readOnly, err := sql.Open("sqlite3", "file:"+f.Name()+"?mode=ro")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer readOnly.Close()
+	if _, err := New(readOnly, "test_queue").Enqueue("read-only"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly, got %v", err)
+	}
+
+	if _, err := q.Dequeue(); err != nil {
+		t.Errorf("Failed to dequeue item: %v", err)
+	}
+
+	// Errors of drivers exposing result codes are classified by code
+	if err := dbError(codedError(sqliteConstraintUnique)); !errors.Is(err, ErrConstraint) {
+		t.Errorf("Expected ErrConstraint for a coded error, got %v", err)
+	}
+	if err := dbError(codedError(sqliteLocked)); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected ErrBusy for a coded error, got %v", err)
+	}
+}
+
+// codedError is a driver error exposing its SQLite result code
+type codedError int
+
+func (e codedError) Error() string { return fmt.Sprintf("sqlite error %d", int(e)) }
+func (e codedError) Code() int     { return int(e) }
+
+func TestLanes(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+	for _, priority := range []int{0, 0, 5} {
+		if _, err := q.EnqueueWithOptions("job", EnqueueOptions{Priority: priority}); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+	}
+	// Not ready yet, so not counted
+	if _, err := q.EnqueueWithOptions("later", EnqueueOptions{Priority: 5, Delay: time.Hour}); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	since := time.Now()
+	item, err := q.DequeueWithOptions(DequeueOptions{Order: OrderPriority})
+	if err != nil || item == nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+
+	lanes, err := q.Lanes(since)
+	if err != nil {
+		t.Fatalf("Failed to read lanes: %v", err)
+	}
+	if len(lanes) != 2 {
+		t.Fatalf("Expected 2 lanes, got %+v", lanes)
+	}
+	if lanes[0].Priority != 5 || lanes[0].Depth != 0 || lanes[0].Claimed != 1 {
+		t.Errorf("Unexpected high priority lane: %+v", lanes[0])
+	}
+	if lanes[1].Priority != 0 || lanes[1].Depth != 2 || lanes[1].Claimed != 0 || lanes[1].OldestWait <= 0 {
+		t.Errorf("Unexpected default priority lane: %+v", lanes[1])
+	}
+}
+
+func TestManualClockTicker(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ticker := clock.NewTicker(time.Minute)
+
+	clock.Advance(30 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("Expected no tick before the interval elapsed")
+	default:
+	}
+
+	// Ticks are dropped while the receiver lags behind
+	clock.Advance(5 * time.Minute)
+	select {
+	case tick := <-ticker.C():
+		if want := time.Date(2024, 1, 1, 0, 5, 30, 0, time.UTC); !tick.Equal(want) {
+			t.Errorf("Expected a tick at %v, got %v", want, tick)
+		}
+	default:
+		t.Fatal("Expected a tick once the interval elapsed")
+	}
+	select {
+	case <-ticker.C():
+		t.Fatal("Expected a single pending tick")
+	default:
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Fatal("Expected no tick after Stop")
+	default:
+	}
+}
+
+func TestInvariants(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+	for i := 0; i < 3; i++ {
+		if _, err := q.Enqueue(i); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+	}
+	item, err := q.Dequeue()
+	if err != nil || item == nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if err := q.Complete(item.ID); err != nil {
+		t.Fatalf("Failed to complete item: %v", err)
+	}
+	if _, err := q.Dequeue(); err != nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+
+	violations, err := Invariants(db)
+	if err != nil {
+		t.Fatalf("Failed to check invariants: %v", err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("Expected a consistent database, got %v", violations)
+	}
+
+	// Break the rules behind the queue's back
+	if _, err := db.Exec(`UPDATE queue_items SET finished_at = NULL WHERE id = ?`, item.ID); err != nil {
+		t.Fatalf("Failed to update item: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE queue_items SET status = 'processing' WHERE status = 'pending'`); err != nil {
+		t.Fatalf("Failed to update item: %v", err)
+	}
+
+	violations, err = Invariants(db)
+	if err != nil {
+		t.Fatalf("Failed to check invariants: %v", err)
+	}
+	rules := make([]string, len(violations))
+	for i, v := range violations {
+		rules[i] = v.Rule
+	}
+	want := []string{"finished item without finished_at", "processing item never claimed"}
+	if fmt.Sprint(rules) != fmt.Sprint(want) {
+		t.Errorf("Expected violations %v, got %v", want, violations)
+	}
+}
+
+func TestDefaultDelay(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	clock := NewManualClock(time.Now())
+	q := NewWithOptions(db, "test_queue", Options{Clock: clock})
+	if err := q.SetConfig(QueueConfig{DefaultDelay: time.Minute}); err != nil {
+		t.Fatalf("Failed to store config: %v", err)
+	}
+
+	graced, err := q.Enqueue("graced")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	longer, err := q.EnqueueWithDelay("longer", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	if item, err := q.Dequeue(); err != nil || item != nil {
+		t.Fatalf("Expected no item during the grace period, got %v, %v", item, err)
+	}
+
+	clock.Advance(time.Minute)
+	item, err := q.Dequeue()
+	if err != nil || item == nil || item.ID != graced {
+		t.Fatalf("Expected item %d after the grace period, got %v, %v", graced, item, err)
+	}
+	if item, err := q.Dequeue(); err != nil || item != nil {
+		t.Fatalf("Expected the longer delay to be kept, got %v, %v", item, err)
+	}
+
+	clock.Advance(time.Hour)
+	if item, err := q.Dequeue(); err != nil || item == nil || item.ID != longer {
+		t.Fatalf("Expected item %d after its own delay, got %v, %v", longer, item, err)
+	}
+
+	// The delay is cached: changes made elsewhere apply once the cache
+	// expires, changes made through the queue at once
+	expectDelay := func(want time.Duration) {
+		t.Helper()
+		id, err := q.Enqueue("job")
+		if err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		item, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Failed to get item: %v", err)
+		}
+		if got := item.ScheduledAt.Sub(clock.Now()); got != want {
+			t.Errorf("Expected a delay of %v, got %v", want, got)
+		}
+	}
+	expectDelay(time.Minute)
+	if err := New(db, "test_queue").SetConfig(QueueConfig{}); err != nil {
+		t.Fatalf("Failed to store config: %v", err)
+	}
+	expectDelay(time.Minute)
+	clock.Advance(defaultDelayTTL)
+	expectDelay(0)
+	if err := q.SetConfig(QueueConfig{DefaultDelay: time.Minute}); err != nil {
+		t.Fatalf("Failed to store config: %v", err)
+	}
+	expectDelay(time.Minute)
+}
+
+func TestExternalIDs(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := NewWithOptions(db, "test_queue", Options{ExternalIDs: NewULID})
+	id, externalID, err := q.EnqueueWithExternalID("job", EnqueueOptions{})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if len(externalID) != 26 {
+		t.Fatalf("Expected a ULID, got %q", externalID)
+	}
+
+	item, err := q.GetByExternalID(externalID)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if item.ID != id || item.ExternalID != externalID {
+		t.Errorf("Expected item %d with external ID %s, got %d with %q", id, externalID, item.ID, item.ExternalID)
+	}
+	if _, err := q.GetByExternalID("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Queues without a generator leave the column empty
+	plain := New(db, "test_queue")
+	if _, externalID, err := plain.EnqueueWithExternalID("job", EnqueueOptions{}); err != nil || externalID != "" {
+		t.Errorf("Expected no external ID, got %q, %v", externalID, err)
+	}
+
+	// Duplicates are rejected within a queue
+	same := NewWithOptions(db, "test_queue", Options{ExternalIDs: func() string { return externalID }})
+	if _, err := same.Enqueue("job"); !errors.Is(err, ErrConstraint) {
+		t.Errorf("Expected ErrConstraint for a duplicate external ID, got %v", err)
+	}
+}
+
+func TestIDGenerators(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		ulid, uuid := NewULID(), NewUUID()
+		if seen[ulid] || seen[uuid] {
+			t.Fatalf("Duplicate ID generated: %s / %s", ulid, uuid)
+		}
+		seen[ulid], seen[uuid] = true, true
+	}
+
+	before := NewULID()
+	time.Sleep(2 * time.Millisecond)
+	if after := NewULID(); after <= before {
+		t.Errorf("Expected ULIDs to sort by creation time, got %s then %s", before, after)
+	}
+
+	uuid := NewUUID()
+	if len(uuid) != 36 || uuid[14] != '4' || !strings.ContainsRune("89ab", rune(uuid[19])) {
+		t.Errorf("Expected a version 4 UUID, got %s", uuid)
+	}
+}
+
+func TestRates(t *testing.T) {
+	inZone(t, -5)
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+	for i := 0; i < 4; i++ {
+		if _, err := q.Enqueue(i); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		item, err := q.Dequeue()
+		if err != nil || item == nil {
+			t.Fatalf("Failed to dequeue item: %v", err)
+		}
+		now := time.Now()
+		if i == 0 {
+			if err := q.RecordAttempt(Attempt{ItemID: item.ID, Attempt: 1, StartedAt: now, FinishedAt: now, Error: "boom"}); err != nil {
+				t.Fatalf("Failed to record attempt: %v", err)
+			}
+			err = q.Fail(item.ID)
+		} else {
+			err = q.Complete(item.ID)
+		}
+		if err != nil {
+			t.Fatalf("Failed to finish item: %v", err)
+		}
+	}
+
+	rates, err := q.Rates(2 * time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to compute rates: %v", err)
+	}
+	want := Rates{Window: 2 * time.Minute, Enqueued: 4, Completed: 2, Failed: 1, Errors: 1}
+	if rates != want {
+		t.Fatalf("Expected %+v, got %+v", want, rates)
+	}
+	if perMinute := rates.PerMinute(RateEnqueued); perMinute != 2 {
+		t.Errorf("Expected 2 enqueued per minute, got %v", perMinute)
+	}
+
+	states := rates.Evaluate(
+		Alarm{Name: "failure spike", Rate: RateFailed, Above: 0.1},
+		Alarm{Name: "stalled", Rate: RateCompleted, Below: 0.5},
+	)
+	if !states[0].Firing || states[1].Firing {
+		t.Errorf("Expected only the failure spike to fire, got %v", states)
+	}
+}
+
+func TestRescheduleWhere(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	clock := NewManualClock(time.Now())
+	q := NewWithOptions(db, "test_queue", Options{Clock: clock})
+	tonight, err := q.EnqueueWithOptions("batch", EnqueueOptions{Delay: time.Hour, Metadata: map[string]string{"batch": "nightly"}})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	other, err := q.EnqueueWithOptions("other", EnqueueOptions{Delay: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if _, err := q.EnqueueWithOptions("later", EnqueueOptions{Delay: 48 * time.Hour, Metadata: map[string]string{"batch": "nightly"}}); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	if _, err := q.RescheduleWhere(RescheduleFilter{}, Reschedule{}); err == nil {
+		t.Error("Expected an error without a time or a shift")
+	}
+
+	n, err := q.RescheduleWhere(RescheduleFilter{
+		Until:    clock.Now().Add(24 * time.Hour),
+		Selector: map[string]string{"batch": "nightly"},
+	}, Reschedule{Shift: 2 * time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to reschedule items: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected 1 item rescheduled, got %d", n)
+	}
+
+	clock.Advance(90 * time.Minute)
+	item, err := q.Dequeue()
+	if err != nil || item == nil || item.ID != other {
+		t.Fatalf("Expected item %d to keep its schedule, got %v, %v", other, item, err)
+	}
+	if item, err := q.Dequeue(); err != nil || item != nil {
+		t.Fatalf("Expected the batch to be pushed back, got %v, %v", item, err)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if item, err := q.Dequeue(); err != nil || item == nil || item.ID != tonight {
+		t.Fatalf("Expected item %d after the shift, got %v, %v", tonight, item, err)
+	}
+
+	// A fixed time applies to every match
+	if n, err := q.RescheduleWhere(RescheduleFilter{}, Reschedule{At: clock.Now()}); err != nil || n != 1 {
+		t.Fatalf("Expected the remaining item rescheduled, got %d, %v", n, err)
+	}
+	if item, err := q.Dequeue(); err != nil || item == nil {
+		t.Fatalf("Expected the remaining item to be due, got %v, %v", item, err)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := NewWithOptions(db, "test_queue", Options{ExternalIDs: NewUUID})
+	for i := 0; i < 3; i++ {
+		opts := EnqueueOptions{
+			Metadata:      map[string]string{"tenant": "acme"},
+			RetrySchedule: []time.Duration{time.Second, time.Minute},
+			Priority:      i,
+			DedupKey:      fmt.Sprintf("key-%d", i),
+		}
+		if i == 2 {
+			opts.Requires = map[string]string{"region": "eu"}
+		}
+		if _, err := q.EnqueueWithOptions(map[string]int{"n": i}, opts); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+	}
+	item, err := q.Dequeue()
+	if err != nil || item == nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if err := q.CompleteWithResult(item.ID, "done"); err != nil {
+		t.Fatalf("Failed to complete item: %v", err)
+	}
+	if _, err := New(db, "other_queue").Enqueue("other"); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	var buf bytes.Buffer
+	archive, err := NewArchiveWriter(&buf)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	n, err := Export(db, "test_queue", archive)
+	if err != nil || n != 3 {
+		t.Fatalf("Expected 3 items exported, got %d, %v", n, err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+	data := append([]byte{}, buf.Bytes()...)
+
+	restored, restoreCleanup := setupTestDB(t)
+	defer restoreCleanup()
+	reader, err := NewArchiveReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if n, err := Import(restored, reader); err != nil || n != 3 {
+		t.Fatalf("Expected 3 items imported, got %d, %v", n, err)
+	}
+
+	var original, copied []*QueueItem
+	if err := q.Each("", func(item *QueueItem) error { original = append(original, item); return nil }); err != nil {
+		t.Fatalf("Failed to read items: %v", err)
+	}
+	if err := New(restored, "test_queue").Each("", func(item *QueueItem) error { copied = append(copied, item); return nil }); err != nil {
+		t.Fatalf("Failed to read items: %v", err)
+	}
+	if len(copied) != len(original) {
+		t.Fatalf("Expected %d items, got %d", len(original), len(copied))
+	}
+	for i := range original {
+		want, got := *original[i], *copied[i]
+		if !want.CreatedAt.Equal(got.CreatedAt) || !want.ScheduledAt.Equal(got.ScheduledAt) {
+			t.Errorf("Item %d: times not preserved: %+v vs %+v", i, want, got)
+		}
+		want.CreatedAt, want.ScheduledAt, got.CreatedAt, got.ScheduledAt = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+		want.LastAttemptAt, want.FinishedAt, got.LastAttemptAt, got.FinishedAt = nil, nil, nil, nil
+		if fmt.Sprint(want) != fmt.Sprint(got) {
+			t.Errorf("Item %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+	if copied[2].DedupKey != "key-2" || copied[2].Requires["region"] != "eu" {
+		t.Errorf("Expected the dedup key and requirements to be imported, got %+v", copied[2])
+	}
+
+	// Importing back into the source conflicts on external IDs and dedup keys
+	reimport := func(onConflict OnConflict) (int, error) {
+		reader, err := NewArchiveReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to open archive: %v", err)
+		}
+		return ImportWithOptions(db, reader, ImportOptions{OnConflict: onConflict})
+	}
+	if _, err := reimport(""); err == nil {
+		t.Error("Expected importing conflicting items to fail by default")
+	}
+	if n, err := reimport(ConflictSkip); err != nil || n != 0 {
+		t.Errorf("Expected conflicting items to be skipped, got %d, %v", n, err)
+	}
+	if n, err := reimport(ConflictReplace); err != nil || n != 3 {
+		t.Errorf("Expected conflicting items to be replaced, got %d, %v", n, err)
+	}
+	var ids []int64
+	if err := q.Each("", func(item *QueueItem) error { ids = append(ids, item.ID); return nil }); err != nil {
+		t.Fatalf("Failed to read items: %v", err)
+	}
+	if len(ids) != 3 || ids[0] == original[0].ID {
+		t.Errorf("Expected the 3 items to be replaced with new IDs, got %v", ids)
+	}
+
+	if _, err := NewArchiveReader(strings.NewReader("not an archive")); !errors.Is(err, ErrBadArchive) {
+		t.Errorf("Expected ErrBadArchive, got %v", err)
+	}
+
+	// A corrupt frame size must not be allocated
+	buf.Reset()
+	buf.WriteString(archiveMagic)
+	buf.WriteByte(archiveVersion)
+	gz := gzip.NewWriter(&buf)
+	gz.Write(binary.AppendUvarint(nil, 1<<62))
+	gz.Close()
+	reader, err = NewArchiveReader(&buf)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if _, err := reader.Next(); !errors.Is(err, ErrBadArchive) {
+		t.Errorf("Expected ErrBadArchive for an oversized frame, got %v", err)
+	}
+}
+
+func TestClaimPolicy(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+	for _, tenant := range []string{"a", "a", "a", "b"} {
+		if _, err := q.EnqueueWithOptions(tenant, EnqueueOptions{Metadata: map[string]string{"tenant": tenant}}); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+	}
+
+	// Alternate between tenants rather than draining a first
+	var last string
+	fair := func(candidates []*QueueItem) int {
+		for i, item := range candidates {
+			if item.Metadata["tenant"] != last {
+				last = item.Metadata["tenant"]
+				return i
+			}
+		}
+		return 0
+	}
+
+	var tenants []string
+	for {
+		item, err := q.DequeueWithOptions(DequeueOptions{Policy: fair, Order: OrderFIFO})
+		if err != nil {
+			t.Fatalf("Failed to dequeue item: %v", err)
+		}
+		if item == nil {
+			break
+		}
+		tenants = append(tenants, item.Metadata["tenant"])
+	}
+	if got := strings.Join(tenants, ","); got != "a,b,a,a" {
+		t.Errorf("Expected tenants a,b,a,a, got %s", got)
+	}
+
+	if _, err := q.Enqueue("skipped"); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	none := func([]*QueueItem) int { return -1 }
+	if item, err := q.DequeueWithOptions(DequeueOptions{Policy: none}); err != nil || item != nil {
+		t.Errorf("Expected no item claimed, got %v, %v", item, err)
+	}
+	outOfRange := func([]*QueueItem) int { return 5 }
+	if _, err := q.DequeueWithOptions(DequeueOptions{Policy: outOfRange}); err == nil {
+		t.Error("Expected an error for an out of range choice")
+	}
+}
+
+func TestShards(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+	for i := 0; i < 6; i++ {
+		if _, err := q.Enqueue(i); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+	}
+
+	for shard := 0; shard < 3; shard++ {
+		var claimed int
+		for {
+			item, err := q.DequeueWithOptions(DequeueOptions{Shard: shard, Shards: 3})
+			if err != nil {
+				t.Fatalf("Failed to dequeue item: %v", err)
+			}
+			if item == nil {
+				break
+			}
+			if item.ID%3 != int64(shard) {
+				t.Errorf("Shard %d claimed item %d", shard, item.ID)
+			}
+			claimed++
+		}
+		if claimed != 2 {
+			t.Errorf("Expected shard %d to claim 2 items, got %d", shard, claimed)
+		}
+	}
+
+	if _, err := q.DequeueWithOptions(DequeueOptions{Shard: 3, Shards: 3}); err == nil {
+		t.Error("Expected an error for an out of range shard")
+	}
+}
+
+func TestBusyRetry(t *testing.T) {
+	f, err := os.CreateTemp("", "laqueue_test_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	db, err := sql.Open("sqlite3", f.Name()+"?_busy_timeout=10")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if err := InitSchema(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	q := NewWithOptions(db, "test_queue", Options{BusyRetry: 5 * time.Second})
+	id, err := q.Enqueue("first")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	other, err := sql.Open("sqlite3", f.Name())
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer other.Close()
+	lock := func() *sql.Conn {
+		conn, err := other.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		if _, err := conn.ExecContext(context.Background(), "BEGIN IMMEDIATE"); err != nil {
+			t.Fatalf("Failed to lock database: %v", err)
+		}
+		return conn
+	}
+	unlockAfter := func(conn *sql.Conn, d time.Duration) {
+		time.AfterFunc(d, func() {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+			conn.Close()
+		})
+	}
+
+	// Another process holding the write lock for longer than the busy timeout
+	unlockAfter(lock(), 100*time.Millisecond)
+	if _, err := q.Enqueue("second"); err != nil {
+		t.Errorf("Expected the enqueue to be retried, got %v", err)
+	}
+
+	unlockAfter(lock(), 100*time.Millisecond)
+	if err := q.Boost(id + 1); err != nil {
+		t.Errorf("Expected the transaction to be retried, got %v", err)
+	}
+
+	unlockAfter(lock(), 100*time.Millisecond)
+	item, err := q.Dequeue()
+	if err != nil || item == nil || item.ID != id+1 {
+		t.Errorf("Expected the claim to be retried, got %v, %v", item, err)
+	}
+
+	// The error is returned once the budget is spent
+	conn := lock()
+	defer unlockAfter(conn, 0)
+	short := NewWithOptions(db, "test_queue", Options{BusyRetry: 50 * time.Millisecond})
+	if err := short.Complete(id); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected ErrBusy, got %v", err)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "webhooks")
+	review := New(db, "webhooks-manual-review")
+
+	if err := q.SetConfig(QueueConfig{DeadLetterQueue: "webhooks"}); err == nil {
+		t.Error("Expected an error for a queue being its own dead-letter queue")
+	}
+	if err := q.SetConfig(QueueConfig{DeadLetterQueue: "other", DiscardFailed: true}); err == nil {
+		t.Error("Expected an error for exclusive targets")
+	}
+
+	// Items stay failed in their queue by default
+	kept, err := q.Enqueue("kept")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if err := q.DeadLetter(kept); err != nil {
+		t.Fatalf("Failed to dead-letter item: %v", err)
+	}
+	if item, err := q.Get(kept); err != nil || item.Status != StatusFailed {
+		t.Errorf("Expected item %d to be failed, got %v, %v", kept, item, err)
+	}
+
+	if err := q.SetConfig(QueueConfig{DeadLetterQueue: "webhooks-manual-review"}); err != nil {
+		t.Fatalf("Failed to store config: %v", err)
+	}
+	if config, err := q.Config(); err != nil || config.DeadLetterQueue != "webhooks-manual-review" {
+		t.Fatalf("Expected the dead-letter queue to be stored, got %+v, %v", config, err)
+	}
+	forwarded, err := q.EnqueueWithOptions("forwarded", EnqueueOptions{Metadata: map[string]string{"tenant": "a"}, Priority: 2})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if err := q.DeadLetter(forwarded); err != nil {
+		t.Fatalf("Failed to dead-letter item: %v", err)
+	}
+	if item, err := q.Get(forwarded); err != nil || item.Status != StatusFailed {
+		t.Errorf("Expected item %d to be failed, got %v, %v", forwarded, item, err)
+	}
+	copied, err := review.Dequeue()
+	if err != nil || copied == nil {
+		t.Fatalf("Expected a copy in the dead-letter queue, got %v, %v", copied, err)
+	}
+	var payload string
+	json.Unmarshal(copied.Payload, &payload)
+	if payload != "forwarded" || copied.Priority != 2 || copied.Metadata["tenant"] != "a" {
+		t.Errorf("Expected the copy to keep the payload and options, got %s, %+v", copied.Payload, copied)
+	}
+	if copied.Metadata["dead_letter_queue"] != "webhooks" || copied.Metadata["dead_letter_id"] != fmt.Sprint(forwarded) {
+		t.Errorf("Expected the copy to reference the original, got %v", copied.Metadata)
+	}
+
+	if err := q.SetConfig(QueueConfig{DiscardFailed: true}); err != nil {
+		t.Fatalf("Failed to store config: %v", err)
+	}
+	discarded, err := q.Enqueue("discarded")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if err := q.DeadLetter(discarded); err != nil {
+		t.Fatalf("Failed to dead-letter item: %v", err)
+	}
+	if _, err := q.Get(discarded); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected item %d to be deleted, got %v", discarded, err)
+	}
+}
+
+func TestRetrySpread(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	clock := NewManualClock(time.Now())
+	q := NewWithOptions(db, "test_queue", Options{Clock: clock})
+
+	// Items failing together during an outage
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		if _, err := q.Enqueue(i); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		item, err := q.Dequeue()
+		if err != nil || item == nil {
+			t.Fatalf("Failed to dequeue item: %v, %v", item, err)
+		}
+		ids = append(ids, item.ID)
+	}
+
+	start := clock.Now().Add(10 * time.Second)
+	seconds := make(map[int]int)
+	for _, id := range ids {
+		at, err := q.RetrySpread(id, 10*time.Second, 2)
+		if err != nil {
+			t.Fatalf("Failed to retry item: %v", err)
+		}
+		if at.Before(start) {
+			t.Errorf("Expected item %d to be retried after its delay, got %v", id, at)
+		}
+		seconds[int(at.Sub(start)/time.Second)]++
+
+		item, err := q.Get(id)
+		if err != nil || item.Status != StatusPending || !item.ScheduledAt.Equal(at) {
+			t.Errorf("Expected item %d to be pending at %v, got %+v, %v", id, at, item, err)
+		}
+	}
+	if seconds[0] != 2 || seconds[1] != 2 || seconds[2] != 1 {
+		t.Errorf("Expected retries spread 2, 2, 1 over three seconds, got %v", seconds)
+	}
+
+	// Without a rate, the delay is kept as is
+	if _, err := q.RetrySpread(ids[0], 10*time.Second, 0); err != nil {
+		t.Fatalf("Failed to retry item: %v", err)
+	}
+	if item, _ := q.Get(ids[0]); !item.ScheduledAt.Equal(start) {
+		t.Errorf("Expected item %d at %v, got %v", ids[0], start, item.ScheduledAt)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+	id, err := q.Enqueue("stuck")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	if err := q.Annotate(id+1, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := New(db, "other_queue").Annotate(id, "other queue"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for another queue, got %v", err)
+	}
+
+	notes := []string{"waiting on vendor fix", "re-drive after Tuesday"}
+	for _, note := range notes {
+		if err := q.Annotate(id, note); err != nil {
+			t.Fatalf("Failed to annotate item: %v", err)
+		}
+	}
+	annotations, err := q.Annotations(id)
+	if err != nil {
+		t.Fatalf("Failed to get annotations: %v", err)
+	}
+	if len(annotations) != 2 || annotations[0].Note != notes[0] || annotations[1].Note != notes[1] || annotations[0].ItemID != id {
+		t.Fatalf("Expected the notes in order, got %+v", annotations)
+	}
+	if annotations[0].CreatedAt.IsZero() {
+		t.Error("Expected the notes to be timestamped")
+	}
+
+	// Notes go away with their item
+	if err := q.Fail(id); err != nil {
+		t.Fatalf("Failed to fail item: %v", err)
+	}
+	if _, err := q.Purge(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to purge items: %v", err)
+	}
+	if annotations, err := q.Annotations(id); err != nil || len(annotations) != 0 {
+		t.Errorf("Expected the notes to be purged, got %+v, %v", annotations, err)
+	}
+}
+
+func TestRedactFields(t *testing.T) {
+	redact := RedactFields("email")
+
+	payload := []byte(`{"user":{"Email":"a@example.com","name":"Ann"},"Access_Token":"abc","items":[{"password":"x"}],"card":"4111-1111-1111-1111","order":"1234567890123","amount":42}`)
+	var got map[string]any
+	if err := json.Unmarshal(redact(payload), &got); err != nil {
+		t.Fatalf("Expected JSON, got %v", err)
+	}
+
+	user := got["user"].(map[string]any)
+	if user["Email"] != "[REDACTED]" || user["name"] != "Ann" {
+		t.Errorf("Expected only the given field to be masked, got %v", user)
+	}
+	if got["Access_Token"] != "[REDACTED]" || got["items"].([]any)[0].(map[string]any)["password"] != "[REDACTED]" {
+		t.Errorf("Expected the default fields to be masked at any depth, got %v", got)
+	}
+	if got["card"] != "************1111" {
+		t.Errorf("Expected the card number to be masked, got %v", got["card"])
+	}
+	if got["order"] != "1234567890123" || got["amount"] != float64(42) {
+		t.Errorf("Expected other values to be kept, got %v", got)
+	}
+
+	if out := redact([]byte("not json")); string(out) != "not json" {
+		t.Errorf("Expected non-JSON payloads to be unchanged, got %s", out)
+	}
+}
+
+func TestEnqueueOrGet(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+	id, status, err := q.EnqueueOrGet("order-42", "first")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if status != StatusPending {
+		t.Errorf("Expected a new pending item, got %s", status)
+	}
+
+	// A retried request gets the original item
+	item, err := q.Dequeue()
+	if err != nil || item == nil || item.ID != id {
+		t.Fatalf("Failed to dequeue item: %v, %+v", err, item)
+	}
+	again, status, err := q.EnqueueOrGet("order-42", "retried")
+	if err != nil {
+		t.Fatalf("Failed to get existing item: %v", err)
+	}
+	if again != id || status != StatusProcessing {
+		t.Errorf("Expected item %d processing, got %d %s", id, again, status)
+	}
+	if _, err := q.EnqueueWithOptions("plain", EnqueueOptions{DedupKey: "order-42"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate, got %v", err)
+	}
+
+	// Keys are scoped to their queue
+	other, _, err := New(db, "other_queue").EnqueueOrGet("order-42", "other")
+	if err != nil || other == id {
+		t.Errorf("Expected a new item in another queue, got %d, %v", other, err)
+	}
+
+	// Concurrent calls enqueue a single item
+	var (
+		wg  sync.WaitGroup
+		ids = make([]int64, 10)
+	)
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, _, err := New(db, "test_queue").EnqueueOrGet("order-43", i)
+			if err != nil {
+				t.Errorf("Failed to enqueue item: %v", err)
+			}
+			ids[i] = id
+		}()
+	}
+	wg.Wait()
+	for _, got := range ids {
+		if got != ids[0] {
+			t.Fatalf("Expected a single item, got %v", ids)
+		}
+	}
+
+	if _, _, err := q.EnqueueOrGet("", "no key"); err == nil {
+		t.Error("Expected an empty key to be rejected")
+	}
+}
+
+func TestMatchQueues(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, name := range []string{"emails.b", "emails.a", "emails", "sms.a"} {
+		if _, err := New(db, name).Enqueue("item"); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+	}
+
+	names, err := MatchQueues(db, "emails.*")
+	if err != nil {
+		t.Fatalf("Failed to match queues: %v", err)
+	}
+	if strings.Join(names, ",") != "emails.a,emails.b" {
+		t.Errorf("Expected emails.a and emails.b, got %v", names)
+	}
+}
+
+func TestEnqueueBatch(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := New(db, "emails").EnqueueWithOptions("existing", EnqueueOptions{DedupKey: "welcome-1"}); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	results, err := EnqueueBatch(db, []BatchItem{
+		{Queue: "emails", Payload: "first"},
+		{Queue: "sms", Payload: "second", Options: EnqueueOptions{Priority: 3}},
+		{Queue: "emails", Payload: "duplicate", Options: EnqueueOptions{DedupKey: "welcome-1"}},
+		{Queue: "emails", Payload: func() {}},
+		{Queue: "emails", Payload: "last"},
+	}, Options{})
+	if err != nil {
+		t.Fatalf("Failed to enqueue batch: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %d", len(results))
+	}
+	if !errors.Is(results[2].Err, ErrDuplicate) || results[3].Err == nil {
+		t.Errorf("Expected the duplicate and the invalid payload to fail, got %+v", results)
+	}
+
+	for i, want := range map[int]string{0: "emails", 1: "sms", 4: "emails"} {
+		if results[i].Err != nil {
+			t.Fatalf("Failed to enqueue item %d: %v", i, results[i].Err)
+		}
+		item, err := New(db, want).Get(results[i].ID)
+		if err != nil {
+			t.Fatalf("Failed to get item %d: %v", i, err)
+		}
+		if i == 1 && item.Priority != 3 {
+			t.Errorf("Expected the options to apply, got priority %d", item.Priority)
+		}
+	}
+	if size, _ := New(db, "emails").Size(); size != 3 {
+		t.Errorf("Expected 3 emails, got %d", size)
+	}
+}
+
+func TestRequiredLabels(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+	pinned, err := q.EnqueueWithOptions("render", EnqueueOptions{Requires: map[string]string{"host": "gpu-1", "disk": "ssd"}})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	// Workers missing a label, or with another value, leave the item alone
+	for _, labels := range []map[string]string{nil, {"host": "gpu-1"}, {"host": "gpu-2", "disk": "ssd"}} {
+		item, err := q.DequeueWithOptions(DequeueOptions{Labels: labels})
+		if err != nil {
+			t.Fatalf("Failed to dequeue: %v", err)
+		}
+		if item != nil {
+			t.Fatalf("Expected labels %v not to claim the pinned item", labels)
+		}
+	}
+
+	free, err := q.Enqueue("anywhere")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	item, err := q.DequeueWithOptions(DequeueOptions{Labels: map[string]string{"host": "cpu-1"}})
+	if err != nil || item == nil || item.ID != free {
+		t.Fatalf("Expected any worker to claim unpinned items, got %+v, %v", item, err)
+	}
+
+	item, err = q.DequeueWithOptions(DequeueOptions{Labels: map[string]string{"host": "gpu-1", "disk": "ssd", "zone": "b"}})
+	if err != nil || item == nil || item.ID != pinned {
+		t.Fatalf("Expected the matching worker to claim the pinned item, got %+v, %v", item, err)
+	}
+}
+
+func TestEnqueueTx(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+	for _, commit := range []bool{false, true} {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		if _, err := q.EnqueueTx(tx, map[string]bool{"commit": commit}, EnqueueOptions{Priority: 2}); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatalf("Failed to end transaction: %v", err)
+		}
+	}
+
+	// Only the committed item exists
+	item, err := q.Dequeue()
+	if err != nil || item == nil {
+		t.Fatalf("Failed to dequeue item: %v, %+v", err, item)
+	}
+	if string(item.Payload) != `{"commit":true}` || item.Priority != 2 {
+		t.Errorf("Expected the committed item, got %+v", item)
+	}
+	if item, err := q.Dequeue(); err != nil || item != nil {
+		t.Errorf("Expected the rolled back item not to exist, got %+v, %v", item, err)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := New(db, "test_queue")
+	if _, err := q.EnqueueWithOptions(map[string]int{"n": 1}, EnqueueOptions{ContentType: ContentTypeProtobuf}); err == nil {
+		t.Fatal("Expected a binary content type to reject a non-byte payload")
+	}
+
+	jsonID, err := q.Enqueue(map[string]int{"n": 1})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	textID, err := q.EnqueueWithOptions("hello", EnqueueOptions{ContentType: ContentTypeText})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	binary := []byte{0x08, 0x96, 0x01, 0x00}
+	binaryID, err := q.EnqueueWithOptions(binary, EnqueueOptions{ContentType: ContentTypeProtobuf})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	want := map[int64]struct {
+		contentType string
+		payload     string
+	}{
+		jsonID:   {ContentTypeJSON, `{"n":1}`},
+		textID:   {ContentTypeText, "hello"},
+		binaryID: {ContentTypeProtobuf, string(binary)},
+	}
+	check := func(q *LaQueue) {
+		t.Helper()
+		for id, w := range want {
+			item, err := q.Get(id)
+			if err != nil || item == nil {
+				t.Fatalf("Failed to get item %d: %v", id, err)
+			}
+			if item.ContentType != w.contentType || string(item.Payload) != w.payload {
+				t.Errorf("Item %d: expected %s %q, got %s %q", id, w.contentType, w.payload, item.ContentType, item.Payload)
+			}
+		}
+	}
+	check(q)
+
+	// Content types survive an archive round trip
+	var buf bytes.Buffer
+	archive, err := NewArchiveWriter(&buf)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	if _, err := Export(db, "test_queue", archive); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+	restored, restoreCleanup := setupTestDB(t)
+	defer restoreCleanup()
+	reader, err := NewArchiveReader(&buf)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	if _, err := Import(restored, reader); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	check(New(restored, "test_queue"))
+}
+
+func TestFreezeSnapshot(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	clock := NewManualClock(time.Date(2024, 1, 1, 23, 59, 0, 0, time.UTC))
+	q := NewWithOptions(db, "test_queue", Options{Clock: clock})
+	var last int64
+	for i := 0; i < 3; i++ {
+		id, err := q.Enqueue(i)
+		if err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		last = id
+	}
+	item, err := q.Dequeue()
+	if err != nil || item == nil {
+		t.Fatalf("Failed to dequeue item: %v", err)
+	}
+	if err := q.Complete(item.ID); err != nil {
+		t.Fatalf("Failed to complete item: %v", err)
+	}
+	if _, err := New(db, "other_queue").Enqueue("other"); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	first, err := q.FreezeSnapshot()
+	if err != nil {
+		t.Fatalf("Failed to freeze snapshot: %v", err)
+	}
+	want := map[string]int{StatusPending: 2, StatusCompleted: 1}
+	if fmt.Sprint(first.Counts) != fmt.Sprint(want) || first.MaxID != last {
+		t.Errorf("Expected counts %v and max ID %d, got %+v", want, last, first)
+	}
+	if counts, err := q.Counts(); err != nil || fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("Expected counts %v without a snapshot, got %v, %v", want, counts, err)
+	}
+
+	clock.Advance(24 * time.Hour)
+	if _, err := q.Enqueue("next day"); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if _, err := q.FreezeSnapshot(); err != nil {
+		t.Fatalf("Failed to freeze snapshot: %v", err)
+	}
+
+	snapshots, err := q.Snapshots(time.Time{})
+	if err != nil || len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %+v, %v", snapshots, err)
+	}
+	if snapshots[0].ID != first.ID || !snapshots[0].TakenAt.Equal(first.TakenAt) || snapshots[1].Counts[StatusPending] != 3 {
+		t.Errorf("Unexpected snapshots %+v", snapshots)
+	}
+	if snapshots, err := q.Snapshots(first.TakenAt.Add(time.Hour)); err != nil || len(snapshots) != 1 {
+		t.Errorf("Expected only the later snapshot, got %+v, %v", snapshots, err)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	standby, standbyCleanup := setupTestDB(t)
+	defer standbyCleanup()
+
+	q := New(db, "test_queue")
+	seeded, err := q.Enqueue("before replication")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if err := q.SetConfig(QueueConfig{MaxRetries: 7}); err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+
+	replica, err := NewSQLiteReplica(standby)
+	if err != nil {
+		t.Fatalf("Failed to set up replica: %v", err)
+	}
+	r, err := NewReplicator(db, replica)
+	if err != nil {
+		t.Fatalf("Failed to set up replicator: %v", err)
+	}
+
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		id, err := q.EnqueueWithOptions(i, EnqueueOptions{Metadata: map[string]string{"n": fmt.Sprint(i)}})
+		if err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	var pauses []int64
+	for i := 0; i < 2; i++ {
+		id, err := q.SchedulePause(PauseWindow{Start: start.Add(time.Duration(i) * time.Hour), End: start.Add(time.Duration(i)*time.Hour + time.Minute)})
+		if err != nil {
+			t.Fatalf("Failed to schedule pause: %v", err)
+		}
+		pauses = append(pauses, id)
+	}
+	if n, err := r.ReplicateOnce(10); err != nil || n == 0 {
+		t.Fatalf("Failed to replicate: %d, %v", n, err)
+	}
+	if err := q.DeletePause(pauses[0]); err != nil {
+		t.Fatalf("Failed to delete pause: %v", err)
+	}
+	if err := q.SetConfig(QueueConfig{MaxRetries: 9, DefaultDelay: time.Minute}); err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+
+	for _, id := range []int64{seeded, ids[0]} {
+		item, err := q.Dequeue()
+		if err != nil || item == nil || item.ID != id {
+			t.Fatalf("Failed to dequeue item: %+v, %v", item, err)
+		}
+		if err := q.Complete(item.ID); err != nil {
+			t.Fatalf("Failed to complete item: %v", err)
+		}
+		if id == seeded {
+			if n, err := q.Purge(time.Now().Add(time.Hour)); err != nil || n != 1 {
+				t.Fatalf("Failed to purge item: %d, %v", n, err)
+			}
+		}
+	}
+
+	// Small batches still converge to the state of the primary
+	for {
+		n, err := r.ReplicateOnce(2)
+		if err != nil {
+			t.Fatalf("Failed to replicate: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	var want, got []*QueueItem
+	if err := q.Each("", func(item *QueueItem) error { want = append(want, item); return nil }); err != nil {
+		t.Fatalf("Failed to read items: %v", err)
+	}
+	if err := New(standby, "test_queue").Each("", func(item *QueueItem) error { got = append(got, item); return nil }); err != nil {
+		t.Fatalf("Failed to read items: %v", err)
+	}
+	if len(got) != 3 || len(want) != 3 {
+		t.Fatalf("Expected 3 items on both sides, got %d and %d", len(want), len(got))
+	}
+	for i := range want {
+		if want[i].ID != got[i].ID || want[i].Status != got[i].Status || string(want[i].Payload) != string(got[i].Payload) ||
+			fmt.Sprint(want[i].Metadata) != fmt.Sprint(got[i].Metadata) || !want[i].ScheduledAt.Equal(got[i].ScheduledAt) {
+			t.Errorf("Item %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+	standbyQueue := New(standby, "test_queue")
+	if config, err := standbyQueue.Config(); err != nil || config.MaxRetries != 9 || config.DefaultDelay != time.Minute {
+		t.Errorf("Expected the config of the primary, got %+v, %v", config, err)
+	}
+	if windows, err := standbyQueue.Pauses(); err != nil || len(windows) != 1 || windows[0].ID != pauses[1] || !windows[0].Start.Equal(start.Add(time.Hour)) {
+		t.Errorf("Expected the remaining pause of the primary, got %+v, %v", windows, err)
+	}
+
+	// A new replica can't start from a pruned change log
+	other, otherCleanup := setupTestDB(t)
+	defer otherCleanup()
+	fresh, err := NewSQLiteReplica(other)
+	if err != nil {
+		t.Fatalf("Failed to set up replica: %v", err)
+	}
+	r, err = NewReplicator(db, fresh)
+	if err != nil {
+		t.Fatalf("Failed to set up replicator: %v", err)
+	}
+	if _, err := r.ReplicateOnce(10); !errors.Is(err, ErrReplicaBehind) {
+		t.Errorf("Expected ErrReplicaBehind, got %v", err)
+	}
+
+	// Change logs of older versions only recorded the items
+	old, oldCleanup := setupTestDB(t)
+	defer oldCleanup()
+	_, err = old.Exec(`
+		CREATE TABLE queue_changes (seq INTEGER PRIMARY KEY AUTOINCREMENT, item_id INTEGER NOT NULL);
+		CREATE TRIGGER queue_changes_insert AFTER INSERT ON queue_items BEGIN
+			INSERT INTO queue_changes (item_id) VALUES (NEW.id);
+		END;
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create old change log: %v", err)
+	}
+	oldQueue := New(old, "test_queue")
+	if _, err := oldQueue.Enqueue("pending change"); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if err := oldQueue.SetConfig(QueueConfig{MaxRetries: 2}); err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+	upgraded, upgradedCleanup := setupTestDB(t)
+	defer upgradedCleanup()
+	upgradedReplica, err := NewSQLiteReplica(upgraded)
+	if err != nil {
+		t.Fatalf("Failed to set up replica: %v", err)
+	}
+	// The upgrade reads the change log within its transaction, so it does
+	// not wait for a second connection
+	old.SetMaxOpenConns(1)
+	if r, err = NewReplicator(old, upgradedReplica); err != nil {
+		t.Fatalf("Failed to upgrade change log: %v", err)
+	}
+	if n, err := r.ReplicateOnce(10); err != nil || n != 2 {
+		t.Errorf("Expected the pending item and the config, got %d, %v", n, err)
+	}
+	if config, err := New(upgraded, "test_queue").Config(); err != nil || config.MaxRetries != 2 {
+		t.Errorf("Expected the config of the primary, got %+v, %v", config, err)
+	}
+}
+
+func TestHTTPReplica(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	standby, standbyCleanup := setupTestDB(t)
+	defer standbyCleanup()
+
+	sqliteReplica, err := NewSQLiteReplica(standby)
+	if err != nil {
+		t.Fatalf("Failed to set up replica: %v", err)
+	}
+	server := httptest.NewServer(ReplicaHandler(sqliteReplica))
+	defer server.Close()
+
+	q := New(db, "test_queue")
+	if err := q.SetConfig(QueueConfig{MaxRetries: 4, DeadLetterQueue: "dead"}); err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+	id, err := q.EnqueueWithOptions(map[string]string{"to": "a@example.com"}, EnqueueOptions{
+		Metadata: map[string]string{"trace": "abc"},
+		Delay:    time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	replica := NewHTTPReplica(server.URL, nil)
+	r, err := NewReplicator(db, replica)
+	if err != nil {
+		t.Fatalf("Failed to set up replicator: %v", err)
+	}
+	if n, err := r.ReplicateOnce(10); err != nil || n != 2 {
+		t.Fatalf("Expected 2 changes replicated, got %d, %v", n, err)
+	}
+	if _, err := db.Exec(`DELETE FROM queue_items WHERE id = ?`, id); err != nil {
+		t.Fatalf("Failed to delete item: %v", err)
+	}
+	next, err := q.Enqueue("next")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if n, err := r.ReplicateOnce(10); err != nil || n != 2 {
+		t.Fatalf("Expected 2 changes replicated, got %d, %v", n, err)
+	}
+	if position, err := replica.Position(); err != nil || position == 0 {
+		t.Errorf("Expected the position of the standby, got %d, %v", position, err)
+	}
+
+	standbyQueue := New(standby, "test_queue")
+	if item, err := standbyQueue.Get(id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the deleted item to be gone, got %+v, %v", item, err)
+	}
+	want, err := q.Get(next)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	got, err := standbyQueue.Get(next)
+	if err != nil || got == nil {
+		t.Fatalf("Failed to get replicated item: %+v, %v", got, err)
+	}
+	if string(got.Payload) != string(want.Payload) || got.Status != want.Status || !got.CreatedAt.Equal(want.CreatedAt) || !got.ScheduledAt.Equal(want.ScheduledAt) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if config, err := standbyQueue.Config(); err != nil || config.MaxRetries != 4 || config.DeadLetterQueue != "dead" {
+		t.Errorf("Expected the config of the primary, got %+v, %v", config, err)
+	}
+
+	// Only the replicated tables and their columns are written
+	for _, change := range []Change{
+		{Seq: 100, Table: "sqlite_master", Key: int64(1), Row: map[string]any{"name": "x"}},
+		{Seq: 100, Table: "queue_items", Key: int64(1), Row: map[string]any{"id) VALUES (1); DROP TABLE queue_items; --": int64(1)}},
+	} {
+		if err := replica.Apply([]Change{change}); err == nil {
+			t.Errorf("Expected change of %s to be rejected", change.Table)
+		}
+	}
+}
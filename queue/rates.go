@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+)
+
+// Rates counts what happened to a queue over a recent window
+type Rates struct {
+	Window time.Duration `json:"window"`
+
+	// Enqueued is the number of items created within the window
+	Enqueued int `json:"enqueued"`
+	// Completed and Failed are the number of items that finished within the
+	// window with that status
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	// Errors is the number of attempts that ended with a handler error
+	// within the window, including those retried afterwards
+	Errors int `json:"errors"`
+}
+
+// Rate identifies one of the counts of Rates
+type Rate string
+
+const (
+	RateEnqueued  Rate = "enqueued"
+	RateCompleted Rate = "completed"
+	RateFailed    Rate = "failed"
+	RateErrors    Rate = "errors"
+)
+
+// PerMinute returns the given count of r as a rate per minute
+func (r Rates) PerMinute(rate Rate) float64 {
+	if r.Window <= 0 {
+		return 0
+	}
+	var count int
+	switch rate {
+	case RateEnqueued:
+		count = r.Enqueued
+	case RateCompleted:
+		count = r.Completed
+	case RateFailed:
+		count = r.Failed
+	case RateErrors:
+		count = r.Errors
+	}
+	return float64(count) / r.Window.Minutes()
+}
+
+// Rates counts the items enqueued, completed and failed, and the handler
+// errors, over the window ending now. Items deleted by retention are not
+// counted.
+func (q *LaQueue) Rates(window time.Duration) (Rates, error) {
+	stmt, err := q.stmt(`
+		SELECT
+			(SELECT COUNT(*) FROM queue_items WHERE queue_name = ? AND created_at >= ?),
+			(SELECT COUNT(*) FROM queue_items WHERE queue_name = ? AND status = 'completed' AND finished_at >= ?),
+			(SELECT COUNT(*) FROM queue_items WHERE queue_name = ? AND status = 'failed' AND finished_at >= ?),
+			(SELECT COUNT(*) FROM queue_attempts WHERE queue_name = ? AND error IS NOT NULL AND finished_at >= ?)
+	`)
+	if err != nil {
+		return Rates{}, err
+	}
+
+	since := q.clock.Now().Add(-window)
+	rates := Rates{Window: window}
+	err = stmt.QueryRow(
+		q.queueName, since,
+		q.queueName, since,
+		q.queueName, since,
+		q.queueName, since,
+	).Scan(&rates.Enqueued, &rates.Completed, &rates.Failed, &rates.Errors)
+	return rates, err
+}
+
+// Alarm fires when a rate per minute leaves its bounds, e.g. more than 10
+// failed items per minute, or fewer than 1 completed item per minute while
+// traffic is expected. Zero bounds are not checked.
+type Alarm struct {
+	Name  string  `json:"name"`
+	Rate  Rate    `json:"rate"`
+	Above float64 `json:"above,omitempty"`
+	Below float64 `json:"below,omitempty"`
+}
+
+// AlarmState is the outcome of evaluating an Alarm
+type AlarmState struct {
+	Alarm
+	Value  float64 `json:"value"`
+	Firing bool    `json:"firing"`
+}
+
+func (s AlarmState) String() string {
+	state := "ok"
+	if s.Firing {
+		state = "FIRING"
+	}
+	return fmt.Sprintf("%s: %s at %.2f/min (%s)", s.Name, s.Rate, s.Value, state)
+}
+
+// Evaluate checks alarms against the rates and returns their states, in
+// order
+func (r Rates) Evaluate(alarms ...Alarm) []AlarmState {
+	states := make([]AlarmState, len(alarms))
+	for i, alarm := range alarms {
+		value := r.PerMinute(alarm.Rate)
+		states[i] = AlarmState{
+			Alarm:  alarm,
+			Value:  value,
+			Firing: (alarm.Above > 0 && value > alarm.Above) || (alarm.Below > 0 && value < alarm.Below),
+		}
+	}
+	return states
+}
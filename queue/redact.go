@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Redactor rewrites a payload before it is displayed by operational tooling,
+// e.g. to mask the secrets it carries. Redactors only affect what is shown:
+// stored items and what handlers receive are left untouched.
+type Redactor func(payload []byte) []byte
+
+// DefaultRedactedFields are the field names masked by RedactFields besides
+// the ones it is given
+var DefaultRedactedFields = []string{
+	"password", "passwd", "secret", "token", "api_key", "apikey",
+	"authorization", "cookie", "card_number", "cvv", "ssn",
+}
+
+// redacted replaces masked values
+const redacted = "[REDACTED]"
+
+// RedactFields returns a Redactor for JSON payloads that masks, at any depth:
+//   - the values of fields whose name contains one of DefaultRedactedFields
+//     or names, ignoring case, so that "token" also masks "access_token"
+//   - strings and numbers that look like card numbers, keeping their last
+//     four digits
+//
+// Payloads that aren't JSON are returned unchanged.
+func RedactFields(names ...string) Redactor {
+	var patterns []string
+	for _, list := range [][]string{DefaultRedactedFields, names} {
+		for _, name := range list {
+			patterns = append(patterns, strings.ToLower(name))
+		}
+	}
+	sensitive := func(field string) bool {
+		field = strings.ToLower(field)
+		for _, pattern := range patterns {
+			if strings.Contains(field, pattern) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var redact func(value any) any
+	redact = func(value any) any {
+		switch v := value.(type) {
+		case map[string]any:
+			for key, field := range v {
+				if sensitive(key) {
+					v[key] = redacted
+				} else {
+					v[key] = redact(field)
+				}
+			}
+		case []any:
+			for i, element := range v {
+				v[i] = redact(element)
+			}
+		case string:
+			if digits, ok := cardNumber(v); ok {
+				return maskCard(digits)
+			}
+		case json.Number:
+			if digits, ok := cardNumber(v.String()); ok {
+				return maskCard(digits)
+			}
+		}
+		return value
+	}
+
+	return func(payload []byte) []byte {
+		decoder := json.NewDecoder(bytes.NewReader(payload))
+		decoder.UseNumber()
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return payload
+		}
+		out, err := json.Marshal(redact(value))
+		if err != nil {
+			return payload
+		}
+		return out
+	}
+}
+
+// cardNumber returns the digits of s if it looks like a payment card number:
+// 13 to 19 digits, optionally grouped with spaces or dashes, passing the
+// Luhn check
+func cardNumber(s string) (string, bool) {
+	digits := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case c == ' ' || c == '-':
+		default:
+			return "", false
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return "", false
+	}
+
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return string(digits), sum%10 == 0
+}
+
+// maskCard masks all but the last four digits of a card number
+func maskCard(digits string) string {
+	return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+}
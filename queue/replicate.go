@@ -0,0 +1,419 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrReplicaBehind is returned by ReplicateOnce when changes the replica has
+// not applied were already dropped from the change log, e.g. because the
+// replica is new while the change log was enabled long ago. The replica must
+// be seeded again from a copy of the primary database.
+var ErrReplicaBehind = errors.New("queue: replica is behind the change log")
+
+// replicatedTables lists the tables streamed to replicas, with the column
+// identifying their rows: the items, and the settings and pauses of the
+// queues, so that a standby processes items as the primary would
+var replicatedTables = []struct{ name, key string }{
+	{"queue_items", "id"},
+	{"queue_configs", "queue_name"},
+	{"queue_pauses", "id"},
+}
+
+// replicatedKey returns the key column of a replicated table, or false if
+// the table isn't replicated
+func replicatedKey(table string) (string, bool) {
+	for _, t := range replicatedTables {
+		if t.name == table {
+			return t.key, true
+		}
+	}
+	return "", false
+}
+
+// changeLog returns the schema recording the keys of the rows changed by
+// every insert, update and delete of the replicated tables, in commit order,
+// for Replicator. Keys keep the type of their column.
+func changeLog() string {
+	var b strings.Builder
+	b.WriteString(`
+		CREATE TABLE IF NOT EXISTS queue_changes (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			table_name TEXT NOT NULL,
+			row_key NOT NULL
+		);
+	`)
+	for _, t := range replicatedTables {
+		for _, op := range []struct{ event, row string }{{"INSERT", "NEW"}, {"UPDATE", "NEW"}, {"DELETE", "OLD"}} {
+			fmt.Fprintf(&b, `
+				CREATE TRIGGER IF NOT EXISTS queue_changes_%[1]s_%[2]s AFTER %[2]s ON %[1]s BEGIN
+					INSERT INTO queue_changes (table_name, row_key) VALUES ('%[1]s', %[3]s.%[4]s);
+				END;
+			`, t.name, op.event, op.row, t.key)
+		}
+	}
+	return b.String()
+}
+
+// EnableChangeLog starts recording the changes of the items, settings and
+// pauses of db for replication. The rows already present are recorded as
+// changed, so that a replica starting from an empty database receives them
+// too. It does nothing if the change log is already enabled, besides
+// upgrading a change log enabled by an older version, which only recorded
+// the items.
+func EnableChangeLog(db *sql.DB) error {
+	mu := writeLock(db)
+	mu.Lock()
+	defer mu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var enabled bool
+	err = tx.QueryRow(`SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'queue_changes'`).Scan(&enabled)
+	if err != nil {
+		return err
+	}
+	seed := replicatedTables
+	if enabled {
+		columns, err := tableColumns(tx, "queue_changes")
+		if err != nil || columns["table_name"] {
+			return err
+		}
+		// Keep the pending changes of the items, which keep their Seq, and
+		// record the settings and pauses as changed
+		_, err = tx.Exec(`
+			DROP TRIGGER IF EXISTS queue_changes_insert;
+			DROP TRIGGER IF EXISTS queue_changes_update;
+			DROP TRIGGER IF EXISTS queue_changes_delete;
+			ALTER TABLE queue_changes RENAME COLUMN item_id TO row_key;
+			ALTER TABLE queue_changes ADD COLUMN table_name TEXT NOT NULL DEFAULT 'queue_items';
+		`)
+		if err != nil {
+			return err
+		}
+		seed = seed[1:]
+	}
+	if _, err := tx.Exec(changeLog()); err != nil {
+		return err
+	}
+	for _, t := range seed {
+		_, err := tx.Exec(fmt.Sprintf(`
+			INSERT INTO queue_changes (table_name, row_key) SELECT '%[1]s', %[2]s FROM %[1]s ORDER BY %[2]s
+		`, t.name, t.key))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DisableChangeLog stops recording the changes of db and drops the changes
+// not replicated yet
+func DisableChangeLog(db *sql.DB) error {
+	mu := writeLock(db)
+	mu.Lock()
+	defer mu.Unlock()
+
+	var drop strings.Builder
+	for _, t := range replicatedTables {
+		for _, event := range []string{"INSERT", "UPDATE", "DELETE"} {
+			fmt.Fprintf(&drop, "DROP TRIGGER IF EXISTS queue_changes_%s_%s;\n", t.name, event)
+		}
+	}
+	drop.WriteString("DROP TABLE IF EXISTS queue_changes;")
+	_, err := db.Exec(drop.String())
+	return err
+}
+
+// Change is the state of a row of a replicated table after it was changed
+// on the primary
+type Change struct {
+	// Seq orders the changes of the primary
+	Seq int64 `json:"seq"`
+
+	// Table is the table of the row, e.g. queue_items, and Key the value of
+	// the column identifying it, e.g. the ID of an item
+	Table string `json:"table"`
+	Key   any    `json:"key"`
+
+	// Row holds the columns of the row, or is nil if it was deleted
+	Row map[string]any `json:"row,omitempty"`
+}
+
+// Replica receives the changes of a primary database, e.g. a standby file
+// (see SQLiteReplica) or a remote endpoint
+type Replica interface {
+	// Position returns the Seq of the last change applied, or 0
+	Position() (int64, error)
+
+	// Apply applies changes and stores the Seq of the last one as the
+	// position, atomically. Changes only carry the latest state of each
+	// item, so they can be applied in any order.
+	Apply(changes []Change) error
+}
+
+// Replicator streams the committed changes of the items, settings and pauses
+// of a primary database to a replica, so that a warm standby can take over
+// processing if the primary is lost. Items that were processing when the primary was lost
+// stay processing on the standby. A primary has a single replica: applied
+// changes are dropped from the change log.
+type Replicator struct {
+	db      *sql.DB
+	replica Replica
+	writeMu *sync.Mutex
+}
+
+// NewReplicator enables the change log of db (see EnableChangeLog) and
+// returns a replicator of its changes to replica
+func NewReplicator(db *sql.DB, replica Replica) (*Replicator, error) {
+	if err := EnableChangeLog(db); err != nil {
+		return nil, fmt.Errorf("failed to enable the change log: %w", err)
+	}
+	return &Replicator{db: db, replica: replica, writeMu: writeLock(db)}, nil
+}
+
+// ReplicateOnce sends up to limit changes following the position of the
+// replica, then drops them from the change log, and returns how many were
+// sent. Changes of the same row are merged into its latest state.
+func (r *Replicator) ReplicateOnce(limit int) (int, error) {
+	position, err := r.replica.Position()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the replica position: %w", err)
+	}
+
+	changes, n, err := r.read(position, limit)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	if err := r.replica.Apply(changes); err != nil {
+		return 0, fmt.Errorf("failed to apply changes: %w", err)
+	}
+
+	last := changes[len(changes)-1].Seq
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if _, err := r.db.Exec(`DELETE FROM queue_changes WHERE seq <= ?`, last); err != nil {
+		return n, dbError(err)
+	}
+	return n, nil
+}
+
+// read returns the changes following position, merged per row and ordered
+// by Seq, along with the number of change log entries they cover
+func (r *Replicator) read(position int64, limit int) ([]Change, int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	// Changes after the replica position that are no longer logged were
+	// dropped without being applied
+	var oldest, issued sql.NullInt64
+	err = tx.QueryRow(`
+		SELECT
+			(SELECT MIN(seq) FROM queue_changes),
+			(SELECT seq FROM sqlite_sequence WHERE name = 'queue_changes')
+	`).Scan(&oldest, &issued)
+	if err != nil {
+		return nil, 0, err
+	}
+	if (oldest.Valid && position < oldest.Int64-1) || (!oldest.Valid && position < issued.Int64) {
+		return nil, 0, ErrReplicaBehind
+	}
+
+	rows, err := tx.Query(`SELECT seq, table_name, row_key FROM queue_changes WHERE seq > ? ORDER BY seq LIMIT ?`, position, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	type rowRef struct {
+		table string
+		key   any
+	}
+	latest := make(map[rowRef]int64)
+	n := 0
+	for rows.Next() {
+		var (
+			seq int64
+			ref rowRef
+		)
+		if err := rows.Scan(&seq, &ref.table, &ref.key); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		latest[ref] = seq
+		n++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || n == 0 {
+		return nil, 0, err
+	}
+
+	changes := make([]Change, 0, len(latest))
+	keys := make(map[string][]any)
+	for ref, seq := range latest {
+		changes = append(changes, Change{Seq: seq, Table: ref.table, Key: ref.key})
+		keys[ref.table] = append(keys[ref.table], ref.key)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Seq < changes[j].Seq })
+
+	states := make(map[rowRef]map[string]any)
+	for table, tableKeys := range keys {
+		keyColumn, ok := replicatedKey(table)
+		if !ok {
+			return nil, 0, fmt.Errorf("queue: change of unknown table %s", table)
+		}
+		err := readRows(tx, table, keyColumn, tableKeys, func(row map[string]any) {
+			states[rowRef{table, row[keyColumn]}] = row
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	for i := range changes {
+		changes[i].Row = states[rowRef{changes[i].Table, changes[i].Key}]
+	}
+	return changes, n, nil
+}
+
+// readRows calls fn with the columns of the rows of table whose keyColumn
+// is one of keys
+func readRows(tx *sql.Tx, table, keyColumn string, keys []any, fn func(row map[string]any)) error {
+	rows, err := tx.Query(`SELECT * FROM `+table+` WHERE `+keyColumn+` IN (?`+strings.Repeat(", ?", len(keys)-1)+`)`, keys...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		values := make([]any, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			row[column] = values[i]
+		}
+		fn(row)
+	}
+	return rows.Err()
+}
+
+// SQLiteReplica applies changes to a standby queue database, which can be
+// used as the queue database once the primary is lost
+type SQLiteReplica struct {
+	db      *sql.DB
+	writeMu *sync.Mutex
+}
+
+// replicaSchema holds the position of a standby database
+const replicaSchema = `
+	CREATE TABLE IF NOT EXISTS queue_replica (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		position INTEGER NOT NULL
+	)
+`
+
+// NewSQLiteReplica prepares db to receive the changes of a primary database
+func NewSQLiteReplica(db *sql.DB) (*SQLiteReplica, error) {
+	if err := InitSchema(db); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(replicaSchema); err != nil {
+		return nil, err
+	}
+	return &SQLiteReplica{db: db, writeMu: writeLock(db)}, nil
+}
+
+// Position returns the Seq of the last change applied to the standby
+func (s *SQLiteReplica) Position() (int64, error) {
+	var position int64
+	err := s.db.QueryRow(`SELECT position FROM queue_replica WHERE id = 1`).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return position, err
+}
+
+// Apply writes the changes to the standby in a single transaction
+func (s *SQLiteReplica) Apply(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	// Changes may come from the network, so only known tables and columns
+	// make it into the queries
+	known := make(map[string]map[string]bool)
+	for _, t := range replicatedTables {
+		columns, err := tableColumns(s.db, t.name)
+		if err != nil {
+			return err
+		}
+		known[t.name] = columns
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, change := range changes {
+		keyColumn, ok := replicatedKey(change.Table)
+		if !ok {
+			return fmt.Errorf("queue: change of unknown table %q", change.Table)
+		}
+		if change.Row == nil {
+			if _, err := tx.Exec(`DELETE FROM `+change.Table+` WHERE `+keyColumn+` = ?`, change.Key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		columns := make([]string, 0, len(change.Row))
+		for column := range change.Row {
+			if !known[change.Table][column] {
+				return fmt.Errorf("queue: change of unknown column %s.%q", change.Table, column)
+			}
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		values := make([]any, len(columns))
+		for i, column := range columns {
+			values[i] = change.Row[column]
+		}
+		query := `INSERT OR REPLACE INTO ` + change.Table + ` (` + strings.Join(columns, ", ") + `) VALUES (?` +
+			strings.Repeat(", ?", len(columns)-1) + `)`
+		if _, err := tx.Exec(query, values...); err != nil {
+			return fmt.Errorf("%s %v: %w", change.Table, change.Key, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO queue_replica (id, position) VALUES (1, ?)
+		ON CONFLICT (id) DO UPDATE SET position = excluded.position
+	`, changes[len(changes)-1].Seq)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
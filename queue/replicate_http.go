@@ -0,0 +1,213 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxReplicaRequest bounds the size of a batch of changes accepted by
+// ReplicaHandler
+const maxReplicaRequest = 64 << 20
+
+// wireValue is the JSON form of a column value. Values are tagged with their
+// type, so that blobs, times and integers survive the round trip; a value
+// with no field set is NULL.
+type wireValue struct {
+	Int  *int64     `json:"int,omitempty"`
+	Real *float64   `json:"real,omitempty"`
+	Text *string    `json:"text,omitempty"`
+	Blob *[]byte    `json:"blob,omitempty"`
+	Time *time.Time `json:"time,omitempty"`
+}
+
+// wireChange is the JSON form of a Change
+type wireChange struct {
+	Seq   int64                `json:"seq"`
+	Table string               `json:"table"`
+	Key   wireValue            `json:"key"`
+	Row   map[string]wireValue `json:"row,omitempty"`
+}
+
+// positionResponse is the body of a position response
+type positionResponse struct {
+	Position int64 `json:"position"`
+}
+
+// toWire returns the JSON form of a value scanned from the database
+func toWire(v any) (wireValue, error) {
+	var w wireValue
+	switch v := v.(type) {
+	case nil:
+	case int64:
+		w.Int = &v
+	case float64:
+		w.Real = &v
+	case string:
+		w.Text = &v
+	case []byte:
+		w.Blob = &v
+	case time.Time:
+		w.Time = &v
+	default:
+		return w, fmt.Errorf("queue: cannot replicate value of type %T", v)
+	}
+	return w, nil
+}
+
+// value returns the value held by w
+func (w wireValue) value() any {
+	switch {
+	case w.Int != nil:
+		return *w.Int
+	case w.Real != nil:
+		return *w.Real
+	case w.Text != nil:
+		return *w.Text
+	case w.Blob != nil:
+		return *w.Blob
+	case w.Time != nil:
+		return w.Time.UTC()
+	}
+	return nil
+}
+
+// encodeChanges returns the JSON form of changes
+func encodeChanges(changes []Change) ([]byte, error) {
+	out := make([]wireChange, len(changes))
+	for i, change := range changes {
+		key, err := toWire(change.Key)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = wireChange{Seq: change.Seq, Table: change.Table, Key: key}
+		if change.Row == nil {
+			continue
+		}
+		out[i].Row = make(map[string]wireValue, len(change.Row))
+		for column, v := range change.Row {
+			if out[i].Row[column], err = toWire(v); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", change.Table, column, err)
+			}
+		}
+	}
+	return json.Marshal(out)
+}
+
+// decodeChanges reads changes encoded by encodeChanges from r
+func decodeChanges(r io.Reader) ([]Change, error) {
+	var in []wireChange
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return nil, err
+	}
+	changes := make([]Change, len(in))
+	for i, change := range in {
+		changes[i] = Change{Seq: change.Seq, Table: change.Table, Key: change.Key.value()}
+		if change.Row == nil {
+			continue
+		}
+		changes[i].Row = make(map[string]any, len(change.Row))
+		for column, v := range change.Row {
+			changes[i].Row[column] = v.value()
+		}
+	}
+	return changes, nil
+}
+
+// ReplicaHandler returns an HTTP handler exposing replica to an HTTPReplica,
+// as GET /replica/position and POST /replica/changes, so that a standby can
+// be fed over the network. Authentication is left to the application
+// wrapping it.
+func ReplicaHandler(replica Replica) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /replica/position", func(w http.ResponseWriter, r *http.Request) {
+		position, err := replica.Position()
+		if err != nil {
+			http.Error(w, "failed to read position", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(positionResponse{Position: position})
+	})
+	mux.HandleFunc("POST /replica/changes", func(w http.ResponseWriter, r *http.Request) {
+		changes, err := decodeChanges(io.LimitReader(r.Body, maxReplicaRequest))
+		if err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := replica.Apply(changes); err != nil {
+			http.Error(w, "failed to apply changes: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// HTTPReplica sends changes to a ReplicaHandler served by another process,
+// e.g. on the standby host
+type HTTPReplica struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPReplica returns a Replica posting changes to the ReplicaHandler
+// served at baseURL. A nil client uses http.DefaultClient.
+func NewHTTPReplica(baseURL string, client *http.Client) *HTTPReplica {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPReplica{url: strings.TrimSuffix(baseURL, "/") + "/replica", client: client}
+}
+
+// Position returns the Seq of the last change applied by the remote replica
+func (h *HTTPReplica) Position() (int64, error) {
+	resp, err := h.client.Get(h.url + "/position")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, replicaStatusError("reading position", resp)
+	}
+	var out positionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, err
+	}
+	return out.Position, nil
+}
+
+// Apply sends the changes to the remote replica, which applies them in a
+// single transaction
+func (h *HTTPReplica) Apply(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	body, err := encodeChanges(changes)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Post(h.url+"/changes", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return replicaStatusError("applying changes", resp)
+	}
+	return nil
+}
+
+// replicaStatusError returns the error of an unexpected response of a
+// ReplicaHandler
+func replicaStatusError(action string, resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("queue: %s failed with status %d: %s", action, resp.StatusCode, strings.TrimSpace(string(message)))
+}
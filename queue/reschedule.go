@@ -0,0 +1,100 @@
+package queue
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RescheduleFilter selects the pending items moved by RescheduleWhere
+type RescheduleFilter struct {
+	// From and Until bound the current scheduled time of the items, Until
+	// excluded. Zero values leave the corresponding side unbounded.
+	From  time.Time
+	Until time.Time
+
+	// Selector restricts the items to those whose metadata contains all of
+	// these key/value pairs, as DequeueOptions.Selector does
+	Selector map[string]string
+}
+
+// Reschedule describes the new scheduled time of rescheduled items: either
+// a fixed time, or a shift of their current one. Exactly one must be set.
+type Reschedule struct {
+	At    time.Time
+	Shift time.Duration
+}
+
+// RescheduleWhere moves the scheduled time of every pending item matching
+// filter at once, e.g. to push tonight's batch back two hours during an
+// incident. It returns the number of items rescheduled.
+func (q *LaQueue) RescheduleWhere(filter RescheduleFilter, change Reschedule) (int64, error) {
+	if change.At.IsZero() == (change.Shift == 0) {
+		return 0, errors.New("queue: reschedule needs either a time or a shift")
+	}
+
+	conditions := []string{"queue_name = ?", "status = 'pending'"}
+	args := []any{q.queueName}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "scheduled_at >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "scheduled_at < ?")
+		args = append(args, filter.Until.UTC())
+	}
+	keys := make([]string, 0, len(filter.Selector))
+	for key := range filter.Selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		conditions = append(conditions, "json_extract(metadata, ?) = ?")
+		args = append(args, jsonPath(key), filter.Selector[key])
+	}
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	var moved int64
+	err := q.writeTx(func(tx *sql.Tx) error {
+		// Shifts are applied in Go, as the driver parses the stored times
+		rows, err := tx.Query(`SELECT id, scheduled_at FROM queue_items WHERE `+strings.Join(conditions, " AND "), args...)
+		if err != nil {
+			return err
+		}
+		type move struct {
+			id int64
+			at time.Time
+		}
+		var moves []move
+		for rows.Next() {
+			var m move
+			if err := rows.Scan(&m.id, &m.at); err != nil {
+				rows.Close()
+				return err
+			}
+			if change.Shift != 0 {
+				m.at = m.at.UTC().Add(change.Shift)
+			} else {
+				m.at = change.At.UTC()
+			}
+			moves = append(moves, m)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, m := range moves {
+			if _, err := tx.Exec(`UPDATE queue_items SET scheduled_at = ? WHERE id = ?`, m.at, m.id); err != nil {
+				return err
+			}
+		}
+		moved = int64(len(moves))
+		return nil
+	})
+	return moved, err
+}
@@ -0,0 +1,25 @@
+package queue
+
+// ResetAttempts sets the attempt count of items back to zero, giving them
+// their full retry budget again, e.g. before re-driving them once the bug
+// that made them fail is fixed. Either all of them are reset or, if one of
+// them doesn't exist in this queue, none is.
+func (q *LaQueue) ResetAttempts(ids ...int64) error {
+	return q.updateItems(`UPDATE queue_items SET attempts = 0 WHERE id = ? AND queue_name = ?`, ids, ErrNotFound)
+}
+
+// ResetAttemptsByStatus sets the attempt count of every item with the given
+// status back to zero, and returns the number of items reset
+func (q *LaQueue) ResetAttemptsByStatus(status string) (int64, error) {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	result, err := q.exec(`
+		UPDATE queue_items SET attempts = 0
+		WHERE queue_name = ? AND status = ? AND attempts > 0
+	`, q.queueName, status)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
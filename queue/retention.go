@@ -0,0 +1,95 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PurgeResults drops the stored results of items completed before the given
+// time, keeping the items themselves. It returns the number of items affected.
+func (q *LaQueue) PurgeResults(before time.Time) (int64, error) {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	result, err := q.exec(`
+		UPDATE queue_items
+		SET result = NULL
+		WHERE queue_name = ? AND status = 'completed' AND finished_at < ? AND result IS NOT NULL
+	`, q.queueName, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PurgeOptions selects the items deleted by PurgeWithOptions
+type PurgeOptions struct {
+	// Since and Before restrict the purge to items finished at or after
+	// Since, and before Before. A zero Before purges up to now.
+	Since  time.Time
+	Before time.Time
+
+	// Status restricts the purge to the completed or the failed items
+	Status string
+}
+
+// Purge deletes completed and failed items finished before the given time,
+// along with their attempt logs and annotations. It returns the number of
+// items deleted.
+func (q *LaQueue) Purge(before time.Time) (int64, error) {
+	return q.PurgeWithOptions(PurgeOptions{Before: before})
+}
+
+// PurgeWithOptions is Purge restricted to the items selected by opts, e.g.
+// those failed yesterday
+func (q *LaQueue) PurgeWithOptions(opts PurgeOptions) (int64, error) {
+	conditions := []string{"queue_name = ?", "status IN ('completed', 'failed')"}
+	args := []any{q.queueName}
+	switch opts.Status {
+	case "":
+	case StatusCompleted, StatusFailed:
+		conditions = append(conditions, "status = ?")
+		args = append(args, opts.Status)
+	default:
+		return 0, fmt.Errorf("queue: cannot purge %s items", opts.Status)
+	}
+	if !opts.Since.IsZero() {
+		conditions = append(conditions, "finished_at >= ?")
+		args = append(args, opts.Since.UTC())
+	}
+	before := opts.Before
+	if before.IsZero() {
+		before = q.clock.Now()
+	}
+	conditions = append(conditions, "finished_at < ?")
+	args = append(args, before.UTC())
+	where := strings.Join(conditions, " AND ")
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	var deleted int64
+	err := q.writeTx(func(tx *sql.Tx) error {
+		for _, table := range []string{"queue_attempts", "queue_annotations"} {
+			_, err := tx.Exec(`
+				DELETE FROM `+table+`
+				WHERE queue_name = ? AND item_id IN (
+					SELECT id FROM queue_items WHERE `+where+`
+				)
+			`, append([]any{q.queueName}, args...)...)
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.Exec(`DELETE FROM queue_items WHERE `+where, args...)
+		if err != nil {
+			return err
+		}
+		deleted, err = result.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
@@ -0,0 +1,209 @@
+package queue
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// schema creates the base tables used by the queue
+const schema = `
+	CREATE TABLE IF NOT EXISTS queue_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		queue_name TEXT NOT NULL,
+		payload BLOB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		scheduled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		status TEXT DEFAULT 'pending',
+		attempts INTEGER DEFAULT 0,
+		last_attempt_at TIMESTAMP,
+		UNIQUE(id, queue_name)
+	);
+	CREATE INDEX IF NOT EXISTS idx_queue_status ON queue_items (queue_name, status, scheduled_at);
+
+	CREATE TABLE IF NOT EXISTS queue_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		item_id INTEGER NOT NULL,
+		queue_name TEXT NOT NULL,
+		attempt INTEGER NOT NULL,
+		worker_id TEXT,
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP NOT NULL,
+		error TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_queue_attempts_item ON queue_attempts (queue_name, item_id, attempt);
+
+	CREATE TABLE IF NOT EXISTS queue_idempotency_keys (
+		queue_name TEXT NOT NULL,
+		key TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (queue_name, key)
+	);
+
+	CREATE TABLE IF NOT EXISTS queue_configs (
+		queue_name TEXT PRIMARY KEY,
+		max_retries INTEGER NOT NULL DEFAULT 0,
+		retention INTEGER NOT NULL DEFAULT 0,
+		result_retention INTEGER NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS queue_pauses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		queue_name TEXT NOT NULL,
+		starts_at INTEGER NOT NULL,
+		ends_at INTEGER NOT NULL,
+		repeat_every INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_queue_pauses ON queue_pauses (queue_name);
+
+	CREATE TABLE IF NOT EXISTS queue_annotations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		queue_name TEXT NOT NULL,
+		item_id INTEGER NOT NULL,
+		note TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_queue_annotations_item ON queue_annotations (queue_name, item_id);
+
+	CREATE TABLE IF NOT EXISTS queue_snapshots (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		queue_name TEXT NOT NULL,
+		taken_at TIMESTAMP NOT NULL,
+		counts TEXT NOT NULL,
+		max_id INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_queue_snapshots ON queue_snapshots (queue_name, taken_at);
+`
+
+// column describes a column added to a table after the initial schema
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+// migrations lists the columns added to the tables over time, in order.
+// Databases created by older versions get them added by InitSchema.
+var migrations = []column{
+	{"queue_items", "result", "BLOB"},
+	{"queue_items", "retry_schedule", "TEXT"},
+	{"queue_items", "lock_key", "TEXT"},
+	{"queue_items", "finished_at", "TIMESTAMP"},
+	{"queue_items", "metadata", "TEXT"},
+	{"queue_items", "checksum", "TEXT"},
+	{"queue_items", "claimed_by", "TEXT"},
+	{"queue_items", "payload_version", "INTEGER NOT NULL DEFAULT 0"},
+	{"queue_items", "priority", "INTEGER NOT NULL DEFAULT 0"},
+	{"queue_items", "group_key", "TEXT"},
+	{"queue_items", "checkpoint", "BLOB"},
+	{"queue_configs", "default_delay", "INTEGER NOT NULL DEFAULT 0"},
+	{"queue_items", "external_id", "TEXT"},
+	{"queue_configs", "dead_letter_queue", "TEXT"},
+	{"queue_configs", "discard_failed", "INTEGER NOT NULL DEFAULT 0"},
+	{"queue_items", "dedup_key", "TEXT"},
+	{"queue_items", "requires", "TEXT"},
+	{"queue_items", "content_type", "TEXT"},
+}
+
+// indexes lists the indexes created once all columns exist
+var indexes = []string{
+	`CREATE INDEX IF NOT EXISTS idx_queue_finished ON queue_items (queue_name, status, finished_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_queue_lock_key ON queue_items (queue_name, lock_key, status) WHERE lock_key IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_queue_group_key ON queue_items (queue_name, group_key, status) WHERE group_key IS NOT NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_queue_external_id ON queue_items (queue_name, external_id) WHERE external_id IS NOT NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_queue_dedup_key ON queue_items (queue_name, dedup_key) WHERE dedup_key IS NOT NULL`,
+}
+
+// timeColumns lists the columns holding times compared by the queue. Older
+// versions wrote some of them in the local zone of the process.
+var timeColumns = []column{
+	{"queue_items", "created_at", ""},
+	{"queue_items", "scheduled_at", ""},
+	{"queue_items", "last_attempt_at", ""},
+	{"queue_items", "finished_at", ""},
+	{"queue_attempts", "started_at", ""},
+	{"queue_attempts", "finished_at", ""},
+}
+
+// InitSchema creates the tables required by the queue if they don't exist,
+// adds any column missing from databases created by older versions and
+// converts the times they wrote in local time to UTC
+func InitSchema(db *sql.DB) error {
+	mu := writeLock(db)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+
+	existing := make(map[string]map[string]bool)
+	for _, col := range migrations {
+		if existing[col.table] == nil {
+			columns, err := tableColumns(db, col.table)
+			if err != nil {
+				return err
+			}
+			existing[col.table] = columns
+		}
+		if existing[col.table][col.name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.name, col.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", col.table, col.name, err)
+		}
+	}
+
+	for _, index := range indexes {
+		if _, err := db.Exec(index); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+
+	// Times are compared as strings, so convert those written with another
+	// offset than UTC, keeping their precision to the millisecond
+	for _, col := range timeColumns {
+		_, err := db.Exec(fmt.Sprintf(`
+			UPDATE %[1]s SET %[2]s = strftime('%%Y-%%m-%%d %%H:%%M:%%f+00:00', %[2]s)
+			WHERE %[2]s GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND %[2]s NOT GLOB '*+00:00'
+		`, col.table, col.name))
+		if err != nil {
+			return fmt.Errorf("failed to convert %s.%s to UTC: %w", col.table, col.name, err)
+		}
+	}
+
+	return nil
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// tableColumns returns the set of column names of a table, read through db
+// or, within a transaction, through its *sql.Tx
+func tableColumns(db queryer, table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,155 @@
+package queue
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Snapshot is the state of a queue frozen at a point in time, for trend
+// reporting and reconciliation
+type Snapshot struct {
+	ID        int64     `json:"id"`
+	QueueName string    `json:"queue_name"`
+	TakenAt   time.Time `json:"taken_at"`
+
+	// Counts is the number of items of each status. Statuses without items
+	// are left out.
+	Counts map[string]int `json:"counts"`
+
+	// MaxID is the highest item ID of the queue, so that items enqueued
+	// after the snapshot can be told apart
+	MaxID int64 `json:"max_id"`
+}
+
+// countsQuery counts the items of each status of a queue
+const countsQuery = `
+	SELECT status, COUNT(*) FROM queue_items
+	WHERE queue_name = ?
+	GROUP BY status
+`
+
+// Counts returns the number of items of each status of the queue, leaving
+// out statuses without items, as FreezeSnapshot does without recording them
+func (q *LaQueue) Counts() (map[string]int, error) {
+	return q.CountsWithOptions(ListOptions{})
+}
+
+// CountsWithOptions is Counts restricted to the items List would return for
+// opts, e.g. those created in the last hour. Sort, Descending and Limit are
+// ignored.
+func (q *LaQueue) CountsWithOptions(opts ListOptions) (map[string]int, error) {
+	conditions, args := q.listConditions(opts)
+	stmt, err := q.stmt(`
+		SELECT status, COUNT(*) FROM queue_items
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		GROUP BY status
+	`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanCounts(rows)
+}
+
+// scanCounts reads the rows of countsQuery and closes them
+func scanCounts(rows *sql.Rows) (map[string]int, error) {
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var (
+			status string
+			count  int
+		)
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+// FreezeSnapshot records the number of items of each status and the highest
+// item ID of the queue in the queue_snapshots table. Both are read in the
+// same transaction as the snapshot is written, so they are consistent with
+// each other.
+func (q *LaQueue) FreezeSnapshot() (*Snapshot, error) {
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	var snapshot *Snapshot
+	err := q.writeTx(func(tx *sql.Tx) error {
+		snapshot = &Snapshot{QueueName: q.queueName, TakenAt: q.clock.Now()}
+
+		rows, err := tx.Query(countsQuery, q.queueName)
+		if err != nil {
+			return err
+		}
+		if snapshot.Counts, err = scanCounts(rows); err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM queue_items WHERE queue_name = ?`, q.queueName).Scan(&snapshot.MaxID)
+		if err != nil {
+			return err
+		}
+
+		counts, err := json.Marshal(snapshot.Counts)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(`
+			INSERT INTO queue_snapshots (queue_name, taken_at, counts, max_id)
+			VALUES (?, ?, ?, ?)
+		`, q.queueName, snapshot.TakenAt, string(counts), snapshot.MaxID)
+		if err != nil {
+			return err
+		}
+		snapshot.ID, err = result.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Snapshots returns the snapshots of the queue taken since the given time,
+// oldest first
+func (q *LaQueue) Snapshots(since time.Time) ([]Snapshot, error) {
+	stmt, err := q.stmt(`
+		SELECT id, queue_name, taken_at, counts, max_id
+		FROM queue_snapshots
+		WHERE queue_name = ? AND taken_at >= ?
+		ORDER BY taken_at ASC, id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(q.queueName, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []Snapshot
+	for rows.Next() {
+		var (
+			s      Snapshot
+			counts string
+		)
+		if err := rows.Scan(&s.ID, &s.QueueName, &s.TakenAt, &counts, &s.MaxID); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(counts), &s.Counts); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
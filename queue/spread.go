@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"database/sql"
+	"math/rand/v2"
+	"time"
+)
+
+// maxRetrySpread bounds how far RetrySpread pushes a retry past its delay
+const maxRetrySpread = time.Hour
+
+// RetrySpread reschedules a failed item like RetryWithDelay, but lets at most
+// perSecond retries of the queue become eligible within any second: when the
+// second the item would be retried in is full, e.g. when thousands of items
+// fail at once during an outage, the item is pushed to the next second with
+// room, up to an hour later. The time within the second is jittered. Retries
+// are counted in the database, so the limit holds across workers and
+// processes. It returns the time the item is scheduled at.
+func (q *LaQueue) RetrySpread(id int64, delay time.Duration, perSecond int) (time.Time, error) {
+	start := q.clock.Now().Add(delay)
+	if perSecond <= 0 {
+		return start, q.RetryWithDelay(id, delay)
+	}
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+
+	var scheduledAt time.Time
+	err := q.writeTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`
+			SELECT scheduled_at FROM queue_items
+			WHERE queue_name = ? AND status = 'pending' AND attempts > 0 AND scheduled_at >= ? AND scheduled_at < ?
+		`, q.queueName, start, start.Add(maxRetrySpread))
+		if err != nil {
+			return err
+		}
+		retries := make(map[int]int)
+		for rows.Next() {
+			var at time.Time
+			if err := rows.Scan(&at); err != nil {
+				rows.Close()
+				return err
+			}
+			retries[int(at.Sub(start)/time.Second)]++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		second := 0
+		for retries[second] >= perSecond && second < int(maxRetrySpread/time.Second) {
+			second++
+		}
+		scheduledAt = start.Add(time.Duration(second)*time.Second + rand.N(time.Second))
+
+		_, err = tx.Exec(`
+			UPDATE queue_items
+			SET status = 'pending', scheduled_at = ?
+			WHERE id = ? AND queue_name = ?
+		`, scheduledAt, id, q.queueName)
+		return err
+	})
+	return scheduledAt, err
+}
@@ -0,0 +1,148 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrItemFailed is returned by EnqueueAndWait when the item ends up failed
+var ErrItemFailed = errors.New("queue: item failed")
+
+// WatchInterval is how often Watch checks the database for items finished by
+// other processes. Items finished within the same process are reported
+// without waiting for the next poll.
+var WatchInterval = 500 * time.Millisecond
+
+// Result reports how a watched item finished
+type Result struct {
+	ID     int64
+	Status string
+	Err    error
+}
+
+// watchRegistry tracks in-process watchers of items, per database handle
+type watchRegistry struct {
+	mu      sync.Mutex
+	waiters map[int64][]chan struct{}
+}
+
+var watchRegistries sync.Map
+
+// registryFor returns the watch registry associated with db
+func registryFor(db *sql.DB) *watchRegistry {
+	r, _ := watchRegistries.LoadOrStore(db, &watchRegistry{waiters: make(map[int64][]chan struct{})})
+	return r.(*watchRegistry)
+}
+
+// subscribe returns a channel signalled when the item is finished in-process
+func (r *watchRegistry) subscribe(id int64) chan struct{} {
+	ch := make(chan struct{}, 1)
+	r.mu.Lock()
+	r.waiters[id] = append(r.waiters[id], ch)
+	r.mu.Unlock()
+	return ch
+}
+
+// unsubscribe removes a channel returned by subscribe
+func (r *watchRegistry) unsubscribe(id int64, ch chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	waiters := r.waiters[id]
+	for i, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(r.waiters, id)
+	} else {
+		r.waiters[id] = waiters
+	}
+}
+
+// notifyWatchers wakes up the in-process watchers of an item
+func notifyWatchers(db *sql.DB, id int64) {
+	r := registryFor(db)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ch := range r.waiters[id] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Watch returns a channel that receives a single Result once the item is
+// completed, failed or quarantined as corrupt, and is then closed. If ctx is done first, the Result
+// carries the context's error.
+func (q *LaQueue) Watch(ctx context.Context, id int64) <-chan Result {
+	results := make(chan Result, 1)
+
+	go func() {
+		defer close(results)
+
+		registry := registryFor(q.db)
+		wake := registry.subscribe(id)
+		defer registry.unsubscribe(id, wake)
+
+		ticker := time.NewTicker(WatchInterval)
+		defer ticker.Stop()
+
+		for {
+			item, err := q.Get(id)
+			if err != nil {
+				results <- Result{ID: id, Err: err}
+				return
+			}
+			if item.Status == StatusCompleted || item.Status == StatusFailed || item.Status == StatusCorrupt {
+				results <- Result{ID: id, Status: item.Status}
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				results <- Result{ID: id, Status: item.Status, Err: ctx.Err()}
+				return
+			case <-wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return results
+}
+
+// EnqueueAndWait enqueues payload, waits for a worker to finish it and returns
+// the result stored with CompleteWithResult (nil if none was stored). It
+// returns ErrItemFailed if the item failed, or ctx's error if ctx is done first.
+func (q *LaQueue) EnqueueAndWait(ctx context.Context, payload any) ([]byte, error) {
+	id, err := q.Enqueue(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	result := <-q.Watch(ctx, id)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	if result.Status == StatusFailed {
+		return nil, fmt.Errorf("%w: item %d", ErrItemFailed, id)
+	}
+	if result.Status == StatusCorrupt {
+		return nil, &CorruptItemError{ID: id}
+	}
+
+	item, err := q.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return item.Result, nil
+}
@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Alert kinds
+const (
+	AlertFailures    = "failures"
+	AlertDeadLetters = "dead_letters"
+)
+
+// Alert describes a threshold crossed by a queue
+type Alert struct {
+	QueueName string        `json:"queue_name"`
+	Kind      string        `json:"kind"`
+	Count     int           `json:"count"`
+	Threshold int           `json:"threshold"`
+	Window    time.Duration `json:"window"`
+	Time      time.Time     `json:"time"`
+}
+
+// String returns a human readable description of the alert
+func (a Alert) String() string {
+	what := "handler failures"
+	if a.Kind == AlertDeadLetters {
+		what = "items marked as failed"
+	}
+	return fmt.Sprintf("laqueue: queue %s had %d %s in the last %v (threshold %d)", a.QueueName, a.Count, what, a.Window, a.Threshold)
+}
+
+// Notifier delivers alerts, e.g. to Slack, a pager or by email
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// AlertConfig configures alerting for a worker's queue
+type AlertConfig struct {
+	Notifier Notifier
+
+	// FailureThreshold alerts when this many handler errors happen within
+	// Window. DeadLetterThreshold alerts when this many items are marked as
+	// failed within Window. Zero disables the corresponding alert.
+	FailureThreshold    int
+	DeadLetterThreshold int
+	Window              time.Duration
+
+	// Cooldown is the minimum time between two alerts of the same kind.
+	// Defaults to Window.
+	Cooldown time.Duration
+}
+
+// WebhookNotifier posts alerts as JSON to a URL. The body includes a "text"
+// field, so it can be used directly with Slack incoming webhooks.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify implements Notifier
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(struct {
+		Alert
+		Text string `json:"text"`
+	}{alert, alert.String()})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// alertTimeout bounds the time spent delivering one alert
+const alertTimeout = 10 * time.Second
+
+// alertCounter counts occurrences within a sliding window and fires once the
+// threshold is reached, at most once per cooldown
+type alertCounter struct {
+	threshold int
+	events    []time.Time
+	lastFired time.Time
+}
+
+// alerter tracks failures of a queue and sends alerts when thresholds are crossed
+type alerter struct {
+	queueName string
+	config    AlertConfig
+
+	mu          sync.Mutex
+	failures    alertCounter
+	deadLetters alertCounter
+}
+
+// newAlerter returns an alerter, or nil if alerting is not configured
+func newAlerter(queueName string, config *AlertConfig) *alerter {
+	if config == nil || config.Notifier == nil {
+		return nil
+	}
+	c := *config
+	if c.Window == 0 {
+		c.Window = 5 * time.Minute
+	}
+	if c.Cooldown == 0 {
+		c.Cooldown = c.Window
+	}
+	return &alerter{
+		queueName:   queueName,
+		config:      c,
+		failures:    alertCounter{threshold: c.FailureThreshold},
+		deadLetters: alertCounter{threshold: c.DeadLetterThreshold},
+	}
+}
+
+// failure records a handler error
+func (a *alerter) failure(now time.Time) {
+	if a != nil {
+		a.record(&a.failures, AlertFailures, now)
+	}
+}
+
+// deadLetter records an item marked as failed
+func (a *alerter) deadLetter(now time.Time) {
+	if a != nil {
+		a.record(&a.deadLetters, AlertDeadLetters, now)
+	}
+}
+
+// record adds an occurrence to the counter and sends an alert if needed
+func (a *alerter) record(c *alertCounter, kind string, now time.Time) {
+	if c.threshold <= 0 {
+		return
+	}
+
+	a.mu.Lock()
+	cutoff := now.Add(-a.config.Window)
+	i := 0
+	for i < len(c.events) && !c.events[i].After(cutoff) {
+		i++
+	}
+	c.events = append(c.events[i:], now)
+
+	count := len(c.events)
+	fire := count >= c.threshold && now.Sub(c.lastFired) >= a.config.Cooldown
+	if fire {
+		c.lastFired = now
+	}
+	a.mu.Unlock()
+
+	if !fire {
+		return
+	}
+
+	alert := Alert{
+		QueueName: a.queueName,
+		Kind:      kind,
+		Count:     count,
+		Threshold: c.threshold,
+		Window:    a.config.Window,
+		Time:      now,
+	}
+
+	// Deliver in the background so a slow notifier doesn't hold up processing
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+		defer cancel()
+		if err := a.config.Notifier.Notify(ctx, alert); err != nil {
+			log.Printf("Error sending alert for queue %s: %v", a.queueName, err)
+		}
+	}()
+}
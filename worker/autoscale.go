@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// latencySmoothing is the weight of the latest observation in the moving
+// average of processing latency
+const latencySmoothing = 0.2
+
+// autoscaler sizes the worker's pool between min and max goroutines from the
+// pending depth of the queue and the observed processing latency
+type autoscaler struct {
+	min int
+	max int
+
+	mu      sync.Mutex
+	latency time.Duration
+}
+
+// newAutoscaler returns an autoscaler, or nil if min and max leave no room to scale
+func newAutoscaler(min, max int) *autoscaler {
+	if max <= min {
+		return nil
+	}
+	return &autoscaler{min: min, max: max}
+}
+
+// observe records the processing latency of one item
+func (a *autoscaler) observe(d time.Duration) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.latency == 0 {
+		a.latency = d
+		return
+	}
+	a.latency = time.Duration(latencySmoothing*float64(d) + (1-latencySmoothing)*float64(a.latency))
+}
+
+// desired returns the number of goroutines needed to drain depth pending
+// items within one poll interval, clamped to [min, max]
+func (a *autoscaler) desired(depth int, interval time.Duration) int {
+	a.mu.Lock()
+	latency := a.latency
+	a.mu.Unlock()
+
+	needed := depth
+	if latency > 0 {
+		// Each goroutine processes about interval/latency items per interval
+		needed = int(math.Ceil(float64(depth) * float64(latency) / float64(interval)))
+	}
+
+	return max(a.min, min(a.max, needed))
+}
@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// retryBudget limits the number of retries allowed within a sliding time window
+type retryBudget struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	retries []time.Time
+}
+
+// newRetryBudget returns a budget allowing limit retries per window, or nil
+// if limit is not positive (unlimited retries)
+func newRetryBudget(limit int, window time.Duration) *retryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &retryBudget{
+		limit:  limit,
+		window: window,
+	}
+}
+
+// allow records a retry at now and reports whether it fits in the budget
+func (b *retryBudget) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Drop retries that fell out of the window
+	cutoff := now.Add(-b.window)
+	i := 0
+	for i < len(b.retries) && !b.retries[i].After(cutoff) {
+		i++
+	}
+	b.retries = b.retries[i:]
+
+	if len(b.retries) >= b.limit {
+		return false
+	}
+	b.retries = append(b.retries, now)
+	return true
+}
@@ -0,0 +1,214 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// contextKey is the type of the keys used to store job values in a handler's context
+type contextKey int
+
+const (
+	itemKey contextKey = iota
+	resultKey
+	queueKey
+	txKey
+	groupKey
+)
+
+// ErrNoJob is returned by SetResult when the context does not belong to a job
+var ErrNoJob = errors.New("worker: context does not carry a job")
+
+// resultHolder receives the result set by a handler
+type resultHolder struct {
+	value json.RawMessage
+}
+
+// withResultHolder returns a copy of ctx in which handlers can store a result
+func withResultHolder(ctx context.Context) (context.Context, *resultHolder) {
+	holder := &resultHolder{}
+	return context.WithValue(ctx, resultKey, holder), holder
+}
+
+// any returns the stored result, or nil if none was set
+func (h *resultHolder) any() any {
+	if h.value == nil {
+		return nil
+	}
+	return h.value
+}
+
+// SetResult stores v as the result of the job being processed. It is saved
+// with the item when the handler returns successfully and can be read back by
+// producers, e.g. through queue.EnqueueAndWait.
+func SetResult(ctx context.Context, v any) error {
+	holder, ok := ctx.Value(resultKey).(*resultHolder)
+	if !ok {
+		return ErrNoJob
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	holder.value = data
+	return nil
+}
+
+// withItem returns a copy of ctx carrying the queue item being processed
+func withItem(ctx context.Context, item *queue.QueueItem) context.Context {
+	return context.WithValue(ctx, itemKey, item)
+}
+
+// withQueue returns a copy of ctx carrying the queue the item was claimed from
+func withQueue(ctx context.Context, q *queue.LaQueue) context.Context {
+	return context.WithValue(ctx, queueKey, q)
+}
+
+// ExactlyOnce runs fn at most once per key across retries of the job's queue,
+// guarding handler side effects against at-least-once delivery. Writes made
+// through tx commit together with the key. In transactional handlers, tx is
+// the job's transaction (see TxFromContext). See queue.LaQueue.ExactlyOnce.
+func ExactlyOnce(ctx context.Context, key string, fn func(tx *sql.Tx) error) error {
+	q, ok := ctx.Value(queueKey).(*queue.LaQueue)
+	if !ok {
+		return ErrNoJob
+	}
+	if tx, ok := TxFromContext(ctx); ok {
+		_, err := q.ExactlyOnceTx(tx, key, fn)
+		return err
+	}
+	_, err := q.ExactlyOnce(key, fn)
+	return err
+}
+
+// withTx returns a copy of ctx carrying the transaction of a transactional job
+func withTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey, tx)
+}
+
+// TxFromContext returns the transaction of a job processed by a worker with
+// Config.Transactional set. Writes made through it commit together with the
+// item's completion, and are rolled back if the handler fails.
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txKey).(*sql.Tx)
+	return tx, ok && tx != nil
+}
+
+// withGroup returns a copy of ctx carrying all the items claimed together
+func withGroup(ctx context.Context, items []*queue.QueueItem) context.Context {
+	return context.WithValue(ctx, groupKey, items)
+}
+
+// GroupFromContext returns the items processed together by a GroupProcessFunc,
+// or the single item being processed by a ProcessContextFunc
+func GroupFromContext(ctx context.Context) ([]*queue.QueueItem, bool) {
+	items, ok := ctx.Value(groupKey).([]*queue.QueueItem)
+	return items, ok && len(items) > 0
+}
+
+// itemFromContext returns the queue item stored in ctx, if any
+func itemFromContext(ctx context.Context) (*queue.QueueItem, bool) {
+	item, ok := ctx.Value(itemKey).(*queue.QueueItem)
+	return item, ok && item != nil
+}
+
+// JobIDFromContext returns the ID of the item being processed by the handler
+func JobIDFromContext(ctx context.Context) (int64, bool) {
+	item, ok := itemFromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+	return item.ID, true
+}
+
+// ExternalIDFromContext returns the external ID of the item being processed,
+// if it was given one, see queue.Options.ExternalIDs
+func ExternalIDFromContext(ctx context.Context) (string, bool) {
+	item, ok := itemFromContext(ctx)
+	if !ok || item.ExternalID == "" {
+		return "", false
+	}
+	return item.ExternalID, true
+}
+
+// ContentTypeFromContext returns the content type of the payload of the item
+// being processed, see queue.EnqueueOptions.ContentType
+func ContentTypeFromContext(ctx context.Context) (string, bool) {
+	item, ok := itemFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return item.ContentType, true
+}
+
+// QueueNameFromContext returns the name of the queue the item was claimed from
+func QueueNameFromContext(ctx context.Context) (string, bool) {
+	item, ok := itemFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return item.QueueName, true
+}
+
+// MetadataFromContext returns the metadata the item was enqueued with
+func MetadataFromContext(ctx context.Context) (map[string]string, bool) {
+	item, ok := itemFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return item.Metadata, true
+}
+
+// AttemptFromContext returns the attempt number of the current execution, starting at 1
+func AttemptFromContext(ctx context.Context) (int, bool) {
+	item, ok := itemFromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+	return item.Attempts, true
+}
+
+// SaveCheckpoint stores the progress of the job being processed, so that a
+// retry after a crash or failure can pick up from it with LoadCheckpoint.
+// In transactional handlers, the checkpoint is saved through the job's
+// transaction (see TxFromContext), so it is rolled back along with the
+// writes it accounts for if the handler fails. See
+// queue.LaQueue.SaveCheckpoint.
+func SaveCheckpoint(ctx context.Context, state any) error {
+	item, ok := itemFromContext(ctx)
+	q, hasQueue := ctx.Value(queueKey).(*queue.LaQueue)
+	if !ok || !hasQueue {
+		return ErrNoJob
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if tx, ok := TxFromContext(ctx); ok {
+		err = q.SaveCheckpointTx(tx, item.ID, json.RawMessage(data))
+	} else {
+		err = q.SaveCheckpoint(item.ID, json.RawMessage(data))
+	}
+	if err != nil {
+		return err
+	}
+	item.Checkpoint = data
+	return nil
+}
+
+// LoadCheckpoint decodes the last checkpoint saved for the job being
+// processed into v, or returns false if there is none, e.g. on the first
+// attempt
+func LoadCheckpoint(ctx context.Context, v any) (bool, error) {
+	item, ok := itemFromContext(ctx)
+	if !ok {
+		return false, ErrNoJob
+	}
+	return item.LoadCheckpoint(v)
+}
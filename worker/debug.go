@@ -0,0 +1,113 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// Job describes an item being processed by a worker
+type Job struct {
+	ID        int64     `json:"id"`
+	Attempt   int       `json:"attempt"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// jobTracker holds the items being processed by a worker
+type jobTracker struct {
+	mu   sync.Mutex
+	jobs map[int64]Job
+}
+
+// track records items as being processed since started. The returned
+// function forgets them.
+func (t *jobTracker) track(items []*queue.QueueItem, started time.Time) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.jobs == nil {
+		t.jobs = make(map[int64]Job)
+	}
+	for _, item := range items {
+		t.jobs[item.ID] = Job{ID: item.ID, Attempt: item.Attempts, StartedAt: started}
+	}
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for _, item := range items {
+			delete(t.jobs, item.ID)
+		}
+	}
+}
+
+// InFlight returns the items the worker is processing, oldest first
+func (w *Worker) InFlight() []Job {
+	w.jobs.mu.Lock()
+	defer w.jobs.mu.Unlock()
+
+	jobs := make([]Job, 0, len(w.jobs.jobs))
+	for _, job := range w.jobs.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt) ||
+			jobs[i].StartedAt.Equal(jobs[j].StartedAt) && jobs[i].ID < jobs[j].ID
+	})
+	return jobs
+}
+
+// withJobLabels runs fn with the goroutine labeled with the queue and item
+// it processes, so that the item can be told apart in goroutine dumps
+func (w *Worker) withJobLabels(ctx context.Context, item *queue.QueueItem, fn func(ctx context.Context)) {
+	labels := pprof.Labels("laqueue.queue", item.QueueName, "laqueue.item", strconv.FormatInt(item.ID, 10))
+	pprof.Do(ctx, labels, fn)
+}
+
+// workerStatus is the report of a worker served by DebugHandler
+type workerStatus struct {
+	Queue    string    `json:"queue"`
+	WorkerID string    `json:"worker_id"`
+	Healthy  bool      `json:"healthy"`
+	LastPoll time.Time `json:"last_poll"`
+	Jobs     []Job     `json:"jobs"`
+}
+
+// DebugHandler returns an HTTP handler reporting the state of the workers,
+// to diagnose a wedged worker in production:
+//   - GET /debug/jobs lists the items each worker is processing, with their
+//     start time, as JSON
+//   - GET /debug/stacks dumps the stacks of every goroutine of the process.
+//     Goroutines running a handler are labeled with laqueue.queue and
+//     laqueue.item.
+//
+// Like producer.Handler, it leaves authentication to the application, and
+// should not be exposed publicly.
+func DebugHandler(workers ...*Worker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/jobs", func(rw http.ResponseWriter, r *http.Request) {
+		statuses := make([]workerStatus, 0, len(workers))
+		for _, w := range workers {
+			statuses = append(statuses, workerStatus{
+				Queue:    w.queueName,
+				WorkerID: w.workerID,
+				Healthy:  w.Healthy(),
+				LastPoll: w.LastPoll(),
+				Jobs:     w.InFlight(),
+			})
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(statuses)
+	})
+	mux.HandleFunc("GET /debug/stacks", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		pprof.Lookup("goroutine").WriteTo(rw, 1)
+	})
+	return mux
+}
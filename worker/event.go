@@ -0,0 +1,48 @@
+package worker
+
+import "time"
+
+// EventType identifies the kind of event emitted by a worker
+type EventType string
+
+const (
+	// EventRetryBudgetExceeded is emitted when an item is failed without retry
+	// because the queue's retry budget is exhausted
+	EventRetryBudgetExceeded EventType = "retry_budget_exceeded"
+
+	// EventReleased is emitted when an item interrupted by shutdown, or
+	// processed but not marked as completed, is returned to the queue
+	// instead of being retried or failed
+	EventReleased EventType = "released"
+
+	// EventCorrupt is emitted when an item whose payload fails verification
+	// is quarantined instead of being processed
+	EventCorrupt EventType = "corrupt"
+
+	// EventSlow is emitted when an item has been processing for much longer
+	// than expected, see Config.ExpectedDuration. The item keeps running.
+	EventSlow EventType = "slow"
+)
+
+// Event describes something notable that happened while processing a queue
+type Event struct {
+	Type      EventType
+	QueueName string
+	ItemID    int64
+	Err       error
+	Time      time.Time
+}
+
+// emit delivers an event to the configured OnEvent callback, if any
+func (w *Worker) emit(eventType EventType, itemID int64, err error) {
+	if w.onEvent == nil {
+		return
+	}
+	w.onEvent(Event{
+		Type:      eventType,
+		QueueName: w.queueName,
+		ItemID:    itemID,
+		Err:       err,
+		Time:      w.clock.Now(),
+	})
+}
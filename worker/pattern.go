@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/nicotsx/laqueue/queue"
+)
+
+// subscription holds the queues matching the pattern of a worker, see
+// Config.QueuePattern
+type subscription struct {
+	pattern string
+	options queue.Options
+
+	mu     sync.Mutex
+	queues map[string]*queue.LaQueue
+	names  []string
+	next   int
+}
+
+// newSubscription returns the subscription of a worker to pattern, or nil
+// for a worker bound to a single queue
+func newSubscription(pattern string, options queue.Options) *subscription {
+	if pattern == "" {
+		return nil
+	}
+	return &subscription{pattern: pattern, options: options, queues: make(map[string]*queue.LaQueue)}
+}
+
+// add subscribes to the queue named name, and reports whether it is new
+func (s *subscription) add(db *sql.DB, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.queues[name]; ok {
+		return false
+	}
+	s.queues[name] = queue.NewWithOptions(db, name, s.options)
+	s.names = append(s.names, name)
+	return true
+}
+
+// get returns the subscribed queue named name
+func (s *subscription) get(name string) *queue.LaQueue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queues[name]
+}
+
+// rotate returns the subscribed queues, starting with the next one on each
+// call so that the first queues don't starve the others
+func (s *subscription) rotate() []*queue.LaQueue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queues := make([]*queue.LaQueue, 0, len(s.names))
+	for i := range s.names {
+		queues = append(queues, s.queues[s.names[(s.next+i)%len(s.names)]])
+	}
+	if len(s.names) > 0 {
+		s.next = (s.next + 1) % len(s.names)
+	}
+	return queues
+}
+
+// close releases the resources of the subscribed queues
+func (s *subscription) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, q := range s.queues {
+		errs = append(errs, q.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// discover subscribes the worker to the queues matching its pattern that
+// appeared since the last poll
+func (w *Worker) discover() {
+	if w.sub == nil {
+		return
+	}
+
+	names, err := queue.MatchQueues(w.db, w.sub.pattern)
+	if err != nil {
+		log.Printf("Error listing queues matching %s: %v", w.sub.pattern, err)
+		return
+	}
+	for _, name := range names {
+		if w.sub.add(w.db, name) {
+			log.Printf("Worker for %s subscribed to queue %s", w.sub.pattern, name)
+		}
+	}
+}
+
+// queues returns the queues the worker claims from: its queue, or the queues
+// matching its pattern in turn
+func (w *Worker) queues() []*queue.LaQueue {
+	if w.sub == nil {
+		return []*queue.LaQueue{w.queue}
+	}
+	return w.sub.rotate()
+}
+
+// queueOf returns the queue item was claimed from
+func (w *Worker) queueOf(item *queue.QueueItem) *queue.LaQueue {
+	if w.sub == nil {
+		return w.queue
+	}
+	return w.sub.get(item.QueueName)
+}
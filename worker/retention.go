@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"log"
+	"time"
+)
+
+// retentionInterval is the minimum time between two retention passes
+const retentionInterval = time.Minute
+
+// applyRetention purges old results and items according to the worker's
+// retention settings, at most once per retentionInterval
+func (w *Worker) applyRetention() {
+	if w.resultRetention == 0 && w.retention == 0 {
+		return
+	}
+
+	now := w.clock.Now()
+	if now.Sub(w.lastRetention) < retentionInterval {
+		return
+	}
+	w.lastRetention = now
+
+	for _, q := range w.queues() {
+		if w.resultRetention > 0 {
+			n, err := q.PurgeResults(now.Add(-w.resultRetention))
+			if err != nil {
+				log.Printf("Error purging results: %v", err)
+			} else if n > 0 {
+				log.Printf("Dropped results of %d items from queue %s", n, q.Name())
+			}
+		}
+
+		if w.retention > 0 {
+			n, err := q.Purge(now.Add(-w.retention))
+			if err != nil {
+				log.Printf("Error purging items: %v", err)
+			} else if n > 0 {
+				log.Printf("Purged %d finished items from queue %s", n, q.Name())
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nicotsx/laqueue/internal/sdnotify"
+)
+
+// Run starts the workers and blocks until ctx is done or the process receives
+// SIGINT or SIGTERM. It then stops the workers, waits for the items they are
+// processing to be handled and releases their resources.
+//
+// Under systemd with Type=notify, Run reports readiness once the workers are
+// started and, if WatchdogSec= is set, pings the watchdog as long as every
+// worker is Healthy, so systemd restarts the service if one gets wedged.
+func Run(ctx context.Context, workers ...*Worker) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *Worker) {
+			defer wg.Done()
+			w.Start(ctx)
+		}(w)
+	}
+
+	if _, err := sdnotify.Notify(sdnotify.Ready); err != nil {
+		log.Printf("Error notifying systemd: %v", err)
+	}
+	if timeout, ok := sdnotify.WatchdogInterval(); ok {
+		go watchdog(ctx, timeout, workers)
+	}
+
+	<-ctx.Done()
+	log.Println("Shutting down workers...")
+	sdnotify.Notify(sdnotify.Stopping)
+
+	// Restore default signal handling so a second signal kills the process
+	stop()
+	wg.Wait()
+
+	for _, w := range workers {
+		if err := w.Close(); err != nil {
+			log.Printf("Error closing worker for queue %s: %v", w.queueName, err)
+		}
+	}
+	log.Println("Shutdown complete")
+}
+
+// watchdog pings the systemd watchdog at half its timeout while all workers are healthy
+func watchdog(ctx context.Context, timeout time.Duration, workers []*Worker) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !allHealthy(workers) {
+				continue
+			}
+			if _, err := sdnotify.Notify(sdnotify.Watchdog); err != nil {
+				log.Printf("Error pinging systemd watchdog: %v", err)
+			}
+		}
+	}
+}
+
+// allHealthy reports whether every worker is healthy, logging the first one that isn't
+func allHealthy(workers []*Worker) bool {
+	for _, w := range workers {
+		if !w.Healthy() {
+			log.Printf("Worker for queue %s last polled at %v, withholding watchdog ping", w.queueName, w.LastPoll())
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nicotsx/laqueue/metrics"
+	"github.com/nicotsx/laqueue/queue"
+)
+
+const (
+	// defaultSlowFactor is how many times longer than expected an item must
+	// run to be reported as slow
+	defaultSlowFactor = 3
+
+	// slowMinSamples is the number of successful items observed before the
+	// learned duration is trusted
+	slowMinSamples = 10
+)
+
+// slowDetector tracks how long items are expected to take, either as
+// configured or learned from the moving average of successful runs
+type slowDetector struct {
+	expected time.Duration
+	factor   float64
+
+	mu      sync.Mutex
+	learned time.Duration
+	samples int
+}
+
+// newSlowDetector returns a slowDetector. A zero expected duration is learned.
+func newSlowDetector(expected time.Duration, factor float64) *slowDetector {
+	if factor <= 1 {
+		factor = defaultSlowFactor
+	}
+	return &slowDetector{expected: expected, factor: factor}
+}
+
+// observe records the duration of a successful run
+func (d *slowDetector) observe(duration time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.samples++
+	if d.learned == 0 {
+		d.learned = duration
+		return
+	}
+	d.learned = time.Duration(latencySmoothing*float64(duration) + (1-latencySmoothing)*float64(d.learned))
+}
+
+// threshold returns how long an item is expected to run and how long it may
+// run before being reported as slow, or zeros while the expected duration is
+// still unknown
+func (d *slowDetector) threshold() (expected, slow time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	expected = d.expected
+	if expected == 0 {
+		if d.samples < slowMinSamples {
+			return 0, 0
+		}
+		expected = d.learned
+	}
+	return expected, time.Duration(d.factor * float64(expected))
+}
+
+// watchSlow reports the items if they are still being processed once the
+// slow threshold elapses. The returned function stops watching.
+func (w *Worker) watchSlow(items []*queue.QueueItem) func() {
+	expected, threshold := w.slow.threshold()
+	if threshold <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	ticker := w.clock.NewTicker(threshold)
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		err := fmt.Errorf("still running after %v, expected to take %v", threshold, expected)
+		for _, item := range items {
+			log.Printf("Item %d of queue %s is slow: %v", item.ID, w.queueName, err)
+			if w.metrics != nil {
+				w.metrics.Count(metrics.SlowItems, 1, w.metricTags)
+			}
+			w.emit(EventSlow, item.ID, err)
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,23 @@
+package worker
+
+import "log"
+
+// freezeSnapshots freezes a snapshot of each queue of the worker once per
+// snapshot interval
+func (w *Worker) freezeSnapshots() {
+	if w.snapshotInterval == 0 {
+		return
+	}
+
+	now := w.clock.Now()
+	if now.Sub(w.lastSnapshot) < w.snapshotInterval {
+		return
+	}
+	w.lastSnapshot = now
+
+	for _, q := range w.queues() {
+		if _, err := q.FreezeSnapshot(); err != nil {
+			log.Printf("Error freezing snapshot of queue %s: %v", q.Name(), err)
+		}
+	}
+}
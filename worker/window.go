@@ -0,0 +1,66 @@
+package worker
+
+import "time"
+
+// Window is a recurring period of the day during which a worker may claim
+// items. Start and End are wall clock times given as offsets from midnight,
+// so 9*time.Hour is 09:00 even on days when clocks change; a window whose
+// End is not after its Start wraps past midnight (e.g. 22:00–06:00).
+type Window struct {
+	// Days restricts the window to these days of the week. Empty means every day.
+	// For windows wrapping past midnight, the day is the one the window starts on.
+	Days  []time.Weekday
+	Start time.Duration
+	End   time.Duration
+}
+
+// contains reports whether t, already in the desired location, falls in the window
+func (w Window) contains(t time.Time) bool {
+	// Not t.Sub(midnight), which is an hour off on days when clocks change
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+
+	if w.End > w.Start {
+		return w.onDay(t.Weekday()) && offset >= w.Start && offset < w.End
+	}
+
+	// Wrapping window: the evening part belongs to today, the morning part to yesterday
+	if offset >= w.Start {
+		return w.onDay(t.Weekday())
+	}
+	if offset < w.End {
+		return w.onDay((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+// onDay reports whether the window applies to the given day
+func (w Window) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Weekdays is a convenience value for windows restricted to Monday through Friday
+var Weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+// inWindows reports whether t falls in any of the windows, evaluated in loc.
+// No windows means the worker may always claim items.
+func inWindows(windows []Window, loc *time.Location, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	t = t.In(loc)
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
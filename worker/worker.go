@@ -3,115 +3,777 @@ package worker
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/nicotsx/laqueue/metrics"
 	"github.com/nicotsx/laqueue/queue"
 )
 
 // ProcessFunc is a function that processes a queue item
 type ProcessFunc func(payload []byte) error
 
+// ProcessContextFunc is a function that processes a queue item. The context
+// carries the job's values, see JobIDFromContext and friends.
+type ProcessContextFunc func(ctx context.Context, payload []byte) error
+
+// GroupProcessFunc is a function that processes a group of queue items
+// claimed together, see queue.DequeueGroup. The items are completed or failed
+// together depending on the returned error. The context carries the values
+// of the first item.
+type GroupProcessFunc func(ctx context.Context, items []*queue.QueueItem) error
+
 // Worker represents a worker that processes queue items
 type Worker struct {
-	db          *sql.DB
-	queue       *queue.LaQueue
-	queueName   string
-	processFunc ProcessFunc
-	interval    time.Duration
-	maxRetries  int
+	db            *sql.DB
+	queue         *queue.LaQueue
+	queueName     string
+	sub           *subscription
+	workerID      string
+	dequeueOpts   queue.DequeueOptions
+	processFunc   ProcessContextFunc
+	transactional bool
+	grouped       bool
+	propagators   []queue.Propagator
+	contentTypes  []string
+	clock         queue.Clock
+	interval      time.Duration
+	maxRetries    int
+	schedule      []time.Duration
+	windows       []Window
+	location      *time.Location
+	retryBudget   *retryBudget
+	retryRate     int
+	onEvent       func(Event)
+	alerter       *alerter
+	metrics       metrics.Sink
+	metricTags    map[string]string
+
+	retention       time.Duration
+	resultRetention time.Duration
+	lastRetention   time.Time
+
+	snapshotInterval time.Duration
+	lastSnapshot     time.Time
+
+	autoscaler *autoscaler
+	slow       *slowDetector
+	jobs       jobTracker
+	target     atomic.Int32
+	active     atomic.Int32
+	lastPoll   atomic.Int64
+	wg         sync.WaitGroup
 }
 
 // Config holds configuration options for the worker
 type Config struct {
-	QueueName  string
-	Interval   time.Duration
+	QueueName string
+
+	// QueuePattern, if set, subscribes the worker to every queue whose name
+	// matches it instead of QueueName, e.g. "emails.*" for per-tenant queues
+	// such as "emails.tenant42" (see queue.MatchQueues for the syntax). The
+	// database is searched for new matching queues on every poll, and items
+	// are claimed from each queue in turn. Settings stored for the matching
+	// queues are not read, except for their dead-letter target.
+	QueuePattern string
+
+	// WorkerID identifies the worker in the attempt log and on the items it
+	// claims. Defaults to hostname:pid.
+	WorkerID string
+
+	// Interval is how often the worker polls the queue when it is idle
+	Interval time.Duration
+
+	// MaxRetries, Retention and ResultRetention default to the settings
+	// stored for the queue, if any, then to the built-in defaults
 	MaxRetries int
+
+	// MinConcurrency and MaxConcurrency bound the number of items processed
+	// concurrently. When MaxConcurrency is greater than MinConcurrency, the
+	// pool grows and shrinks with the pending depth and processing latency.
+	// Both default to 1.
+	MinConcurrency int
+	MaxConcurrency int
+
+	// RetrySchedule lists the delays to wait before each retry, replacing the
+	// exponential backoff. When set, items fail once the schedule is exhausted
+	// and MaxRetries is ignored. Items enqueued with their own schedule use it instead.
+	RetrySchedule []time.Duration
+
+	// Selector limits the worker to items whose metadata contains all of
+	// these key/value pairs, e.g. {"region": "eu"} for a region-pinned worker
+	Selector map[string]string
+
+	// Labels describe the worker, e.g. {"host": "gpu-1"}, so that it claims
+	// the items pinned to it, see queue.EnqueueOptions.Requires
+	Labels map[string]string
+
+	// ContentTypes, if set, lists the payload content types the handler
+	// supports (see queue.EnqueueOptions.ContentType). Items of other types
+	// are failed without running the handler.
+	ContentTypes []string
+
+	// Order decides which eligible item is claimed first, see queue.Order
+	Order queue.Order
+
+	// ClaimPolicy, if set, picks the item to claim among the first
+	// Candidates eligible ones, see queue.DequeueOptions
+	ClaimPolicy queue.ClaimPolicy
+	Candidates  int
+
+	// Shard and Shards split the queue between worker instances, each
+	// claiming only the items whose ID modulo Shards equals its Shard, see
+	// queue.DequeueOptions
+	Shard  int
+	Shards int
+
+	// Windows restricts when the worker claims items, e.g. business hours.
+	// Items becoming eligible outside the windows wait for the next one.
+	// Empty means no restriction.
+	Windows []Window
+
+	// Location is the time zone Windows are evaluated in. Defaults to time.Local.
+	Location *time.Location
+
+	// RetryBudget caps the number of retries the queue may schedule within
+	// RetryBudgetWindow. Once exhausted, failing items are marked as failed
+	// immediately. Zero means no budget.
+	RetryBudget       int
+	RetryBudgetWindow time.Duration
+
+	// RetryRate, if set, is the maximum number of retries of the queue
+	// becoming eligible within a second, so that the retries of items failing
+	// together spread out instead of hitting the queue at once, see
+	// queue.LaQueue.RetrySpread
+	RetryRate int
+
+	// Retention deletes completed and failed items this long after they
+	// finished. ResultRetention drops only the stored results, and is usually
+	// shorter. Zero keeps them forever.
+	Retention       time.Duration
+	ResultRetention time.Duration
+
+	// SnapshotInterval, if set, freezes a snapshot of the item counts of the
+	// queue this often, see queue.LaQueue.FreezeSnapshot
+	SnapshotInterval time.Duration
+
+	// Alert, if set, notifies someone when failures cross a threshold
+	Alert *AlertConfig
+
+	// Codecs transform payloads when they are enqueued through and claimed by
+	// the worker, see queue.Options. Producers must use the same chain.
+	Codecs []queue.Codec
+
+	// PayloadVersion and Upgrades let handlers process items enqueued with an
+	// older payload structure, see queue.Options
+	PayloadVersion int
+	Upgrades       map[int]queue.Upgrade
+
+	// Propagators restore the values captured at enqueue time (see
+	// queue.LaQueue.EnqueueContext) into the handler's context
+	Propagators []queue.Propagator
+
+	// ExpectedDuration is how long items usually take to process. Items
+	// still running after SlowFactor times as long (3 by default) are
+	// reported with EventSlow. When zero, it is learned from the items
+	// processed successfully.
+	ExpectedDuration time.Duration
+	SlowFactor       float64
+
+	// ExternalIDs generates the external IDs of the items enqueued through
+	// the worker, see queue.Options
+	ExternalIDs queue.IDGenerator
+
+	// Clock is the source of time of the worker and its queue, e.g. a
+	// queue.ManualClock in tests. Defaults to queue.SystemClock.
+	Clock queue.Clock
+
+	// BusyRetry is how long the worker's writes are retried while another
+	// process holds the database, see queue.Options
+	BusyRetry time.Duration
+
+	// Metrics, if set, receives the worker's metrics (see the metrics package)
+	Metrics metrics.Sink
+
+	// OnEvent, if set, is called for notable events such as an exhausted retry budget
+	OnEvent func(Event)
+
+	// Transactional runs each handler within a database transaction, available
+	// through TxFromContext, in which the item is also marked as completed.
+	// The handler's writes and the ack commit or roll back together.
+	Transactional bool
 }
 
 // New creates a new Worker instance
 func New(db *sql.DB, config Config, processFunc ProcessFunc) *Worker {
+	return NewContext(db, config, func(_ context.Context, payload []byte) error {
+		return processFunc(payload)
+	})
+}
+
+// NewContext creates a Worker whose handler receives the context of the
+// job, e.g. to read its ID with JobIDFromContext or to stop when the worker
+// is stopped
+func NewContext(db *sql.DB, config Config, processFunc ProcessContextFunc) *Worker {
+	if config.Clock == nil {
+		config.Clock = queue.SystemClock
+	}
+	options := queue.Options{
+		Codecs:         config.Codecs,
+		PayloadVersion: config.PayloadVersion,
+		Upgrades:       config.Upgrades,
+		Propagators:    config.Propagators,
+		Clock:          config.Clock,
+		ExternalIDs:    config.ExternalIDs,
+		BusyRetry:      config.BusyRetry,
+	}
+	q := queue.NewWithOptions(db, config.QueueName, options)
+
+	// Settings stored for the queue (see queue.LaQueue.SetConfig) fill in
+	// those left unset
+	var stored queue.QueueConfig
+	if config.QueuePattern == "" {
+		var err error
+		if stored, err = q.Config(); err != nil {
+			log.Printf("Error reading configuration of queue %s: %v", config.QueueName, err)
+		}
+	} else {
+		// Logs, metrics and alerts refer to the worker by its pattern
+		config.QueueName = config.QueuePattern
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = stored.MaxRetries
+	}
+	if config.Retention == 0 {
+		config.Retention = stored.Retention
+	}
+	if config.ResultRetention == 0 {
+		config.ResultRetention = stored.ResultRetention
+	}
+
 	if config.Interval == 0 {
 		config.Interval = 5 * time.Second
 	}
 	if config.MaxRetries == 0 {
 		config.MaxRetries = 3
 	}
+	if config.WorkerID == "" {
+		config.WorkerID = defaultWorkerID()
+	}
+	if config.MinConcurrency < 1 {
+		config.MinConcurrency = 1
+	}
+	if config.MaxConcurrency < config.MinConcurrency {
+		config.MaxConcurrency = config.MinConcurrency
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+	if config.RetryBudgetWindow == 0 {
+		config.RetryBudgetWindow = time.Minute
+	}
+
+	w := &Worker{
+		db:        db,
+		queue:     q,
+		queueName: config.QueueName,
+		sub:       newSubscription(config.QueuePattern, options),
+		workerID:  config.WorkerID,
+		dequeueOpts: queue.DequeueOptions{
+			Selector: config.Selector,
+			Labels:   config.Labels,
+			WorkerID: config.WorkerID,
+			Order:    config.Order,
 
-	return &Worker{
-		db:          db,
-		queue:       queue.New(db, config.QueueName),
-		queueName:   config.QueueName,
-		processFunc: processFunc,
-		interval:    config.Interval,
-		maxRetries:  config.MaxRetries,
+			Policy:     config.ClaimPolicy,
+			Candidates: config.Candidates,
+
+			Shard:  config.Shard,
+			Shards: config.Shards,
+		},
+		processFunc:     processFunc,
+		transactional:   config.Transactional,
+		propagators:     config.Propagators,
+		contentTypes:    config.ContentTypes,
+		clock:           config.Clock,
+		interval:        config.Interval,
+		maxRetries:      config.MaxRetries,
+		schedule:        config.RetrySchedule,
+		windows:         config.Windows,
+		location:        config.Location,
+		retryBudget:     newRetryBudget(config.RetryBudget, config.RetryBudgetWindow),
+		retryRate:       config.RetryRate,
+		onEvent:         config.OnEvent,
+		alerter:         newAlerter(config.QueueName, config.Alert),
+		metrics:         config.Metrics,
+		metricTags:      map[string]string{"queue": config.QueueName},
+		retention:       config.Retention,
+		resultRetention: config.ResultRetention,
+		autoscaler:      newAutoscaler(config.MinConcurrency, config.MaxConcurrency),
+		slow:            newSlowDetector(config.ExpectedDuration, config.SlowFactor),
+
+		snapshotInterval: config.SnapshotInterval,
 	}
+	w.target.Store(int32(config.MinConcurrency))
+
+	return w
 }
 
-// Start begins the worker polling the queue for items to process
+// NewGroup creates a Worker that claims items sharing a group key together
+// (see queue.EnqueueOptions.GroupKey) and hands them to processFunc as a
+// unit. Items without a group key are handed over alone.
+func NewGroup(db *sql.DB, config Config, processFunc GroupProcessFunc) *Worker {
+	w := NewContext(db, config, func(ctx context.Context, payload []byte) error {
+		items, _ := GroupFromContext(ctx)
+		return processFunc(ctx, items)
+	})
+	w.grouped = true
+	return w
+}
+
+// Start begins the worker polling the queue for items to process. It returns
+// once ctx is done and the items being processed have been handled.
 func (w *Worker) Start(ctx context.Context) {
-	ticker := time.NewTicker(w.interval)
+	ticker := w.clock.NewTicker(w.interval)
 	defer ticker.Stop()
 
 	log.Printf("Starting worker for queue: %s", w.queueName)
+	w.lastPoll.Store(w.clock.Now().UnixNano())
 
 	for {
 		select {
 		case <-ctx.Done():
+			w.wg.Wait()
 			log.Printf("Worker stopped: %v", ctx.Err())
 			return
-		case <-ticker.C:
-			w.processNext()
+		case <-ticker.C():
+			w.discover()
+			w.applyRetention()
+			w.freezeSnapshots()
+			w.reportDepth()
+			w.scale()
+			w.dispatch(ctx)
+			w.lastPoll.Store(w.clock.Now().UnixNano())
 		}
 	}
 }
 
-// processNext attempts to process the next item in the queue
-func (w *Worker) processNext() {
-	item, err := w.queue.Dequeue()
-	if err != nil {
-		log.Printf("Error dequeueing item: %v", err)
+// LastPoll returns when the worker last completed a poll of the queue, or
+// the zero time if it hasn't started
+func (w *Worker) LastPoll() time.Time {
+	nanos := w.lastPoll.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// Healthy reports whether the worker's poll loop is running on schedule. A
+// worker stuck for more than two poll intervals, e.g. waiting on a database
+// lock, is considered unhealthy.
+func (w *Worker) Healthy() bool {
+	last := w.LastPoll()
+	return !last.IsZero() && w.clock.Now().Sub(last) < 2*w.interval+time.Second
+}
+
+// scale adjusts the pool size to the current queue depth when autoscaling is enabled
+func (w *Worker) scale() {
+	if w.autoscaler == nil {
 		return
 	}
-	if item == nil {
-		// No items to process
+
+	depth := 0
+	for _, q := range w.queues() {
+		size, err := q.Size()
+		if err != nil {
+			log.Printf("Error reading queue size: %v", err)
+			return
+		}
+		depth += size
+	}
+
+	desired := int32(w.autoscaler.desired(depth, w.interval))
+	if previous := w.target.Swap(desired); previous != desired {
+		log.Printf("Scaling worker for queue %s from %d to %d", w.queueName, previous, desired)
+	}
+}
+
+// reportDepth sends the number of items ready to be claimed to the metrics sink
+func (w *Worker) reportDepth() {
+	if w.metrics == nil {
+		return
+	}
+
+	for _, q := range w.queues() {
+		depth, err := q.Size()
+		if err != nil {
+			log.Printf("Error reading queue size: %v", err)
+			return
+		}
+		w.metrics.Gauge(metrics.QueueDepth, float64(depth), map[string]string{"queue": q.Name()})
+
+		lanes, err := q.Lanes(w.clock.Now().Add(-laneWindow))
+		if err != nil {
+			log.Printf("Error reading queue lanes: %v", err)
+			return
+		}
+		for _, lane := range lanes {
+			tags := map[string]string{"queue": q.Name(), "priority": strconv.Itoa(lane.Priority)}
+			w.metrics.Gauge(metrics.LaneDepth, float64(lane.Depth), tags)
+			w.metrics.Gauge(metrics.LaneLatency, lane.Latency.Seconds(), tags)
+		}
+	}
+}
+
+// laneWindow is the period over which the claim latency of each priority
+// lane is averaged
+const laneWindow = time.Minute
+
+// countOutcome increments the processed items counter for the given outcome
+func (w *Worker) countOutcome(outcome string) {
+	if w.metrics == nil {
+		return
+	}
+	w.metrics.Count(metrics.ItemsProcessed, 1, map[string]string{"queue": w.queueName, "outcome": outcome})
+}
+
+// dispatch claims items and starts goroutines until the pool is full or the queue is empty
+func (w *Worker) dispatch(ctx context.Context) {
+	for w.active.Load() < w.target.Load() {
+		items := w.claim()
+		if items == nil {
+			return
+		}
+
+		w.active.Add(1)
+		w.wg.Add(1)
+		go w.run(ctx, items)
+	}
+}
+
+// run processes items, then keeps claiming items until the queue is drained,
+// the pool shrinks or the worker stops
+func (w *Worker) run(ctx context.Context, items []*queue.QueueItem) {
+	defer w.wg.Done()
+	defer w.active.Add(-1)
+
+	for items != nil {
+		w.process(ctx, items)
+
+		if ctx.Err() != nil || w.active.Load() > w.target.Load() {
+			return
+		}
+		items = w.claim()
+	}
+}
+
+// claim dequeues the next item, or the next group of items for group
+// workers, or returns nil if none is available
+func (w *Worker) claim() []*queue.QueueItem {
+	if !inWindows(w.windows, w.location, w.clock.Now()) {
+		// Outside of the configured windows
+		return nil
+	}
+
+	for _, q := range w.queues() {
+		items, err := w.claimFrom(q)
+		if items != nil || errors.Is(err, queue.ErrBusy) {
+			return items
+		}
+	}
+	return nil
+}
+
+// claimFrom dequeues the next item or group of items from q
+func (w *Worker) claimFrom(q *queue.LaQueue) ([]*queue.QueueItem, error) {
+	for {
+		var (
+			items []*queue.QueueItem
+			err   error
+		)
+		if w.grouped {
+			items, err = q.DequeueGroup(w.dequeueOpts)
+		} else {
+			var item *queue.QueueItem
+			if item, err = q.DequeueWithOptions(w.dequeueOpts); item != nil {
+				items = []*queue.QueueItem{item}
+			}
+		}
+
+		var corrupt *queue.CorruptItemError
+		if errors.As(err, &corrupt) {
+			// The item was quarantined, move on to the next one
+			log.Printf("Item %d is corrupt, quarantined it", corrupt.ID)
+			w.countOutcome("corrupt")
+			w.emit(EventCorrupt, corrupt.ID, err)
+			continue
+		}
+		if errors.Is(err, queue.ErrBusy) {
+			// Another connection holds the write lock, try again next poll
+			log.Printf("Database busy, not dequeueing from queue %s", q.Name())
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("Error dequeueing item: %v", err)
+			return nil, err
+		}
+		return items, nil
+	}
+}
+
+// process runs the handler on claimed items and records the outcome. Items
+// claimed as a group share the outcome of the first one.
+func (w *Worker) process(ctx context.Context, items []*queue.QueueItem) {
+	item := items[0]
+	q := w.queueOf(item)
+	if len(items) > 1 {
+		log.Printf("Processing group %s of %d items from queue", item.GroupKey, len(items))
+	} else {
+		log.Printf("Processing item %d from queue", item.ID)
+	}
+
+	if !w.supports(item.ContentType) {
+		err := fmt.Errorf("unsupported content type %s", item.ContentType)
+		log.Printf("Item %d has an %v, marking as failed", item.ID, err)
+		now := w.clock.Now()
+		for _, item := range items {
+			w.recordAttempt(item, now, now, err)
+		}
+		w.fail(items)
 		return
 	}
 
-	log.Printf("Processing item %d from queue", item.ID)
+	jobCtx, result := withResultHolder(withQueue(withItem(ctx, item), q))
+	jobCtx = withGroup(jobCtx, items)
+	jobCtx = queue.RestoreContext(jobCtx, w.propagators, item.Metadata)
+
+	var tx *sql.Tx
+	if w.transactional {
+		var err error
+		if tx, err = w.db.Begin(); err != nil {
+			log.Printf("Error starting transaction for item %d: %v", item.ID, err)
+			for _, item := range items {
+				if err := q.Release(item.ID); err != nil {
+					log.Printf("Error releasing item: %v", err)
+				}
+			}
+			return
+		}
+		defer tx.Rollback()
+		jobCtx = withTx(jobCtx, tx)
+	}
+
+	started := w.clock.Now()
+	forget := w.jobs.track(items, started)
+	stopWatching := w.watchSlow(items)
+	var err error
+	w.withJobLabels(jobCtx, item, func(jobCtx context.Context) {
+		err = w.processFunc(jobCtx, item.Payload)
+	})
+	stopWatching()
+	forget()
+	finished := w.clock.Now()
+	if err == nil {
+		w.slow.observe(finished.Sub(started))
+	}
+	if tx != nil {
+		// End the transaction first: it may hold the database write lock
+		// that the bookkeeping below needs
+		if err != nil {
+			tx.Rollback()
+		} else if ackErr := w.completeTx(tx, items, result); ackErr != nil {
+			// The handler's writes were rolled back along with the ack, so
+			// the attempt failed as a whole
+			tx.Rollback()
+			err = fmt.Errorf("failed to commit the handler's transaction: %w", ackErr)
+		}
+	}
+	w.autoscaler.observe(finished.Sub(started))
+	if w.metrics != nil {
+		w.metrics.Timing(metrics.ItemDuration, finished.Sub(started), w.metricTags)
+	}
+	for _, item := range items {
+		w.recordAttempt(item, started, finished, err)
+	}
+
+	if err != nil && ctx.Err() != nil {
+		// The worker is shutting down and cancelled the handler: this is not
+		// the item's fault, so hand it back untouched for the next worker
+		for _, item := range items {
+			log.Printf("Item %d interrupted by shutdown, releasing it", item.ID)
+			if err := q.Release(item.ID); err != nil {
+				log.Printf("Error releasing item: %v", err)
+			}
+			w.countOutcome("released")
+			w.emit(EventReleased, item.ID, err)
+		}
+		return
+	}
 
-	if err := w.processFunc(item.Payload); err != nil {
+	if err != nil {
 		log.Printf("Error processing item %d: %v", item.ID, err)
+		w.alerter.failure(w.clock.Now())
 
-		if item.Attempts >= w.maxRetries {
+		delay, retry := w.retryDelay(item)
+		if !retry {
 			log.Printf("Item %d has failed %d times, marking as failed", item.ID, item.Attempts)
-			if err := w.queue.Fail(item.ID); err != nil {
-				log.Printf("Error marking item as failed: %v", err)
-			}
+			w.fail(items)
+		} else if !w.retryBudget.allow(w.clock.Now()) {
+			log.Printf("Retry budget exhausted for queue %s, marking item %d as failed", w.queueName, item.ID)
+			w.fail(items)
+			w.emit(EventRetryBudgetExceeded, item.ID, err)
 		} else {
-			// Exponential backoff for retries
-			delay := time.Duration(1<<uint(item.Attempts)) * time.Second
 			log.Printf("Rescheduling item %d for retry in %v", item.ID, delay)
-			if err := w.queue.RetryWithDelay(item.ID, delay); err != nil {
-				log.Printf("Error rescheduling item: %v", err)
+			for _, item := range items {
+				if _, err := q.RetrySpread(item.ID, delay, w.retryRate); err != nil {
+					log.Printf("Error rescheduling item: %v", err)
+				}
+				w.countOutcome("retried")
 			}
 		}
 		return
 	}
 
-	// Mark the item as completed
-	if err := w.queue.Complete(item.ID); err != nil {
-		log.Printf("Error marking item as completed: %v", err)
+	// Mark the items as completed, along with the result if the handler set one
+	if tx == nil {
+		if len(items) > 1 {
+			err = q.CompleteGroup(itemIDs(items), result.any())
+		} else if result.value != nil {
+			err = q.CompleteWithResult(item.ID, result.value)
+		} else {
+			err = q.Complete(item.ID)
+		}
+	}
+	if err != nil {
+		// Rather than leaving the items processing until they are recovered,
+		// hand them back to be processed again
+		log.Printf("Error marking item as completed, releasing it: %v", err)
+		for _, item := range items {
+			if err := q.Release(item.ID); err != nil {
+				log.Printf("Error releasing item: %v", err)
+			}
+			w.countOutcome("released")
+			w.emit(EventReleased, item.ID, err)
+		}
+		return
+	}
+	for range items {
+		w.countOutcome("completed")
+	}
+}
+
+// supports reports whether the handler accepts payloads of contentType
+func (w *Worker) supports(contentType string) bool {
+	return len(w.contentTypes) == 0 || slices.Contains(w.contentTypes, contentType)
+}
+
+// itemIDs returns the IDs of items
+func itemIDs(items []*queue.QueueItem) []int64 {
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+// completeTx acks items within the handler's transaction and commits them all
+func (w *Worker) completeTx(tx *sql.Tx, items []*queue.QueueItem, result *resultHolder) error {
+	for _, item := range items {
+		if err := w.queueOf(item).CompleteTx(tx, item.ID, result.any()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// fail marks items as failed for good, handing them to the queue's
+// dead-letter target
+func (w *Worker) fail(items []*queue.QueueItem) {
+	for _, item := range items {
+		if err := w.queueOf(item).DeadLetter(item.ID); err != nil {
+			log.Printf("Error marking item as failed: %v", err)
+		}
+		w.alerter.deadLetter(w.clock.Now())
+		w.countOutcome("failed")
 	}
 }
 
+// recordAttempt appends the outcome of an execution to the item's attempt log
+func (w *Worker) recordAttempt(item *queue.QueueItem, started, finished time.Time, err error) {
+	attempt := queue.Attempt{
+		ItemID:     item.ID,
+		Attempt:    item.Attempts,
+		WorkerID:   w.workerID,
+		StartedAt:  started,
+		FinishedAt: finished,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	if err := w.queueOf(item).RecordAttempt(attempt); err != nil {
+		log.Printf("Error recording attempt of item %d: %v", item.ID, err)
+	}
+}
+
+// defaultWorkerID returns an identifier made of the hostname and process ID
+func defaultWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+// retryDelay returns how long to wait before retrying an item that just
+// failed, or false if the item has no retries left
+func (w *Worker) retryDelay(item *queue.QueueItem) (time.Duration, bool) {
+	schedule := item.RetrySchedule
+	if len(schedule) == 0 {
+		schedule = w.schedule
+	}
+
+	if len(schedule) > 0 {
+		if item.Attempts > len(schedule) {
+			return 0, false
+		}
+		return schedule[item.Attempts-1], true
+	}
+
+	if item.Attempts >= w.maxRetries {
+		return 0, false
+	}
+	// Exponential backoff for retries
+	return time.Duration(1<<uint(item.Attempts)) * time.Second, true
+}
+
 // Enqueue adds a new item to the queue
 func (w *Worker) Enqueue(payload any) (int64, error) {
 	return w.queue.Enqueue(payload)
 }
 
+// EnqueueContext adds a new item to the queue, propagating values of ctx
+// to its handler, see Config.Propagators
+func (w *Worker) EnqueueContext(ctx context.Context, payload any, opts queue.EnqueueOptions) (int64, error) {
+	return w.queue.EnqueueContext(ctx, payload, opts)
+}
+
 // EnqueueWithDelay adds a new item to the queue with a specified delay
 func (w *Worker) EnqueueWithDelay(payload any, delay time.Duration) (int64, error) {
 	return w.queue.EnqueueWithDelay(payload, delay)
 }
 
+// Close releases resources held by the worker's queues
+func (w *Worker) Close() error {
+	err := w.queue.Close()
+	if w.sub != nil {
+		err = errors.Join(err, w.sub.close())
+	}
+	return err
+}
@@ -0,0 +1,1100 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/nicotsx/laqueue/queue"
+)
+
+func setupTestDB(t testing.TB) (*sql.DB, func()) {
+	f, err := os.CreateTemp("", "laqueue_worker_test_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	f.Close()
+	dbPath := f.Name()
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	if err := queue.InitSchema(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.Remove(dbPath)
+	}
+
+	return db, cleanup
+}
+
+func TestWorkerProcessesItems(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var processed atomic.Int32
+	w := New(db, Config{
+		QueueName:      "test_queue",
+		Interval:       10 * time.Millisecond,
+		MaxConcurrency: 4,
+	}, func(payload []byte) error {
+		time.Sleep(5 * time.Millisecond)
+		processed.Add(1)
+		return nil
+	})
+	defer w.Close()
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		if _, err := w.Enqueue(map[string]int{"value": i}); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Start(ctx)
+		close(done)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for processed.Load() < n {
+		select {
+		case <-deadline:
+			t.Fatalf("Timed out with %d/%d items processed", processed.Load(), n)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	<-done
+}
+
+func TestHandlerContext(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	type job struct {
+		id      int64
+		queue   string
+		attempt int
+	}
+	seen := make(chan job, 1)
+	w := NewContext(db, Config{
+		QueueName: "test_queue",
+		Interval:  10 * time.Millisecond,
+	}, func(ctx context.Context, payload []byte) error {
+		id, ok := JobIDFromContext(ctx)
+		if !ok {
+			t.Error("Expected the job ID in the handler context")
+		}
+		name, _ := QueueNameFromContext(ctx)
+		attempt, _ := AttemptFromContext(ctx)
+		seen <- job{id, name, attempt}
+		return nil
+	})
+	defer w.Close()
+
+	id, err := w.Enqueue("job")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx)
+
+	select {
+	case got := <-seen:
+		if want := (job{id, "test_queue", 1}); got != want {
+			t.Errorf("Expected the handler to see %+v, got %+v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the handler")
+	}
+
+	if _, ok := JobIDFromContext(context.Background()); ok {
+		t.Error("Expected no job ID outside of a handler")
+	}
+}
+
+func TestAutoscalerDesired(t *testing.T) {
+	a := newAutoscaler(1, 8)
+
+	// Without latency observations, one goroutine per pending item
+	if got := a.desired(3, time.Second); got != 3 {
+		t.Errorf("Expected 3 goroutines, got %d", got)
+	}
+	if got := a.desired(0, time.Second); got != 1 {
+		t.Errorf("Expected the minimum of 1 goroutine, got %d", got)
+	}
+
+	// 100 items at 200ms each need 20s of work, capped at 8 goroutines
+	a.observe(200 * time.Millisecond)
+	if got := a.desired(100, time.Second); got != 8 {
+		t.Errorf("Expected the maximum of 8 goroutines, got %d", got)
+	}
+	// 10 items at 200ms each fit in 2 goroutines per second
+	if got := a.desired(10, time.Second); got != 2 {
+		t.Errorf("Expected 2 goroutines, got %d", got)
+	}
+
+	if newAutoscaler(2, 2) != nil {
+		t.Error("Expected no autoscaler for a fixed pool size")
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(2, time.Minute)
+	start := time.Now()
+
+	if !budget.allow(start) {
+		t.Fatal("Expected first retry to be allowed")
+	}
+	if !budget.allow(start.Add(10 * time.Second)) {
+		t.Fatal("Expected second retry to be allowed")
+	}
+	if budget.allow(start.Add(20 * time.Second)) {
+		t.Fatal("Expected third retry to exceed the budget")
+	}
+
+	// Once the first retry leaves the window, there is room again
+	if !budget.allow(start.Add(61 * time.Second)) {
+		t.Fatal("Expected retry to be allowed after the window moved")
+	}
+
+	// A nil budget never limits retries
+	var unlimited *retryBudget
+	if !unlimited.allow(start) {
+		t.Fatal("Expected nil budget to allow retries")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	w := &Worker{maxRetries: 3}
+
+	// Exponential backoff until MaxRetries
+	if delay, ok := w.retryDelay(&queue.QueueItem{Attempts: 1}); !ok || delay != 2*time.Second {
+		t.Errorf("Expected 2s backoff, got %v (retry=%v)", delay, ok)
+	}
+	if _, ok := w.retryDelay(&queue.QueueItem{Attempts: 3}); ok {
+		t.Error("Expected no retry after MaxRetries attempts")
+	}
+
+	// Queue-level schedule replaces the backoff and MaxRetries
+	w.schedule = []time.Duration{time.Minute, 10 * time.Minute, time.Hour, 6 * time.Hour}
+	if delay, ok := w.retryDelay(&queue.QueueItem{Attempts: 3}); !ok || delay != time.Hour {
+		t.Errorf("Expected 1h delay, got %v (retry=%v)", delay, ok)
+	}
+	if _, ok := w.retryDelay(&queue.QueueItem{Attempts: 5}); ok {
+		t.Error("Expected no retry once the schedule is exhausted")
+	}
+
+	// An item's own schedule wins over the queue's
+	item := &queue.QueueItem{Attempts: 1, RetrySchedule: []time.Duration{5 * time.Second}}
+	if delay, ok := w.retryDelay(item); !ok || delay != 5*time.Second {
+		t.Errorf("Expected 5s delay, got %v (retry=%v)", delay, ok)
+	}
+	item.Attempts = 2
+	if _, ok := w.retryDelay(item); ok {
+		t.Error("Expected no retry once the item schedule is exhausted")
+	}
+}
+
+func TestWindows(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("Time zone data unavailable: %v", err)
+	}
+
+	businessHours := []Window{{Days: Weekdays, Start: 8 * time.Hour, End: 20 * time.Hour}}
+
+	tests := []struct {
+		name    string
+		windows []Window
+		at      time.Time
+		want    bool
+	}{
+		{"no windows", nil, time.Date(2024, 6, 1, 3, 0, 0, 0, paris), true},
+		{"weekday morning", businessHours, time.Date(2024, 6, 3, 9, 30, 0, 0, paris), true},
+		{"weekday night", businessHours, time.Date(2024, 6, 3, 3, 0, 0, 0, paris), false},
+		{"end is exclusive", businessHours, time.Date(2024, 6, 3, 20, 0, 0, 0, paris), false},
+		{"saturday", businessHours, time.Date(2024, 6, 1, 10, 0, 0, 0, paris), false},
+		// 06:30 UTC is 08:30 in Paris during summer time
+		{"evaluated in location", businessHours, time.Date(2024, 6, 3, 6, 30, 0, 0, time.UTC), true},
+		{"wrapping evening", []Window{{Days: []time.Weekday{time.Friday}, Start: 22 * time.Hour, End: 6 * time.Hour}},
+			time.Date(2024, 6, 7, 23, 0, 0, 0, paris), true},
+		{"wrapping next morning", []Window{{Days: []time.Weekday{time.Friday}, Start: 22 * time.Hour, End: 6 * time.Hour}},
+			time.Date(2024, 6, 8, 5, 0, 0, 0, paris), true},
+		{"wrapping other day", []Window{{Days: []time.Weekday{time.Friday}, Start: 22 * time.Hour, End: 6 * time.Hour}},
+			time.Date(2024, 6, 7, 5, 0, 0, 0, paris), false},
+		// Days when clocks change are 23 or 25 hours long: windows follow the wall clock
+		{"spring forward", []Window{{Start: 8 * time.Hour, End: 20 * time.Hour}},
+			time.Date(2024, 3, 31, 8, 30, 0, 0, paris), true},
+		{"fall back", []Window{{Start: 8 * time.Hour, End: 20 * time.Hour}},
+			time.Date(2024, 10, 27, 19, 30, 0, 0, paris), true},
+	}
+
+	for _, tt := range tests {
+		if got := inWindows(tt.windows, paris, tt.at); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	alerts := make(chan Alert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("Failed to decode alert: %v", err)
+		}
+		alerts <- alert
+	}))
+	defer server.Close()
+
+	a := newAlerter("webhooks", &AlertConfig{
+		Notifier:         &WebhookNotifier{URL: server.URL},
+		FailureThreshold: 3,
+		Window:           time.Minute,
+	})
+
+	start := time.Now()
+	a.failure(start)
+	a.failure(start.Add(time.Second))
+	// Dead letters are not monitored in this configuration
+	a.deadLetter(start.Add(2 * time.Second))
+	a.failure(start.Add(3 * time.Second))
+
+	select {
+	case alert := <-alerts:
+		if alert.Kind != AlertFailures || alert.Count != 3 || alert.QueueName != "webhooks" {
+			t.Errorf("Unexpected alert: %+v", alert)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for alert")
+	}
+
+	// Further failures within the cooldown don't alert again
+	a.failure(start.Add(4 * time.Second))
+	select {
+	case alert := <-alerts:
+		t.Errorf("Unexpected alert during cooldown: %+v", alert)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if newAlerter("webhooks", nil) != nil {
+		t.Error("Expected no alerter without configuration")
+	}
+}
+
+func TestShutdownReleasesItems(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	started := make(chan struct{})
+	w := NewContext(db, Config{
+		QueueName: "test_queue",
+		Interval:  10 * time.Millisecond,
+	}, func(ctx context.Context, payload []byte) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	defer w.Close()
+
+	id, err := w.Enqueue(map[string]string{"message": "long job"})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Start(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the handler to start")
+	}
+	cancel()
+	<-done
+
+	item, err := queue.New(db, "test_queue").Get(id)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if item.Status != queue.StatusPending {
+		t.Errorf("Expected released item to be pending, got '%s'", item.Status)
+	}
+	if item.Attempts != 0 {
+		t.Errorf("Expected the interrupted attempt not to count, got %d attempts", item.Attempts)
+	}
+}
+
+func TestTransactionalHandler(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := db.Exec(`CREATE TABLE charges (id INTEGER PRIMARY KEY, amount INTEGER)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	var calls atomic.Int32
+	w := NewContext(db, Config{
+		QueueName:     "test_queue",
+		Interval:      10 * time.Millisecond,
+		RetrySchedule: []time.Duration{0},
+		Transactional: true,
+	}, func(ctx context.Context, payload []byte) error {
+		tx, ok := TxFromContext(ctx)
+		if !ok {
+			return errors.New("no transaction in context")
+		}
+		if _, err := tx.Exec(`INSERT INTO charges (amount) VALUES (100)`); err != nil {
+			return err
+		}
+		// The first attempt fails after writing: its insert must be rolled back
+		if calls.Add(1) == 1 {
+			return errors.New("boom")
+		}
+		return SetResult(ctx, "charged")
+	})
+	defer w.Close()
+
+	id, err := w.Enqueue("charge")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Start(ctx)
+		close(done)
+	}()
+
+	q := queue.New(db, "test_queue")
+	defer q.Close()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		item, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Failed to get item: %v", err)
+		}
+		if item.Status == queue.StatusCompleted {
+			if string(item.Result) != `"charged"` {
+				t.Errorf("Expected result to be stored, got %s", item.Result)
+			}
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("Timed out with item %s", item.Status)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	<-done
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM charges`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count charges: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 charge, got %d", count)
+	}
+}
+
+func TestTransactionalExactlyOnce(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := db.Exec(`CREATE TABLE charges (id INTEGER PRIMARY KEY, amount INTEGER)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	w := NewContext(db, Config{
+		QueueName:     "test_queue",
+		Transactional: true,
+	}, func(ctx context.Context, payload []byte) error {
+		// The job's transaction holds the write lock once it wrote: the
+		// helper must run within it rather than wait for it
+		tx, _ := TxFromContext(ctx)
+		if _, err := tx.Exec(`INSERT INTO charges (amount) VALUES (0)`); err != nil {
+			return err
+		}
+		return ExactlyOnce(ctx, "charge-1", func(tx *sql.Tx) error {
+			_, err := tx.Exec(`INSERT INTO charges (amount) VALUES (100)`)
+			return err
+		})
+	})
+	defer w.Close()
+
+	for i := 0; i < 2; i++ {
+		id, err := w.Enqueue("charge")
+		if err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		w.process(context.Background(), w.claim())
+
+		item, err := queue.New(db, "test_queue").Get(id)
+		if err != nil {
+			t.Fatalf("Failed to get item: %v", err)
+		}
+		if item.Status != queue.StatusCompleted {
+			t.Errorf("Expected item %d to be completed, got %s", id, item.Status)
+		}
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM charges WHERE amount = 100`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count charges: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 charge, got %d", count)
+	}
+}
+
+func TestTransactionalCheckpoint(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := db.Exec(`CREATE TABLE pages (page INTEGER PRIMARY KEY)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	var resumed []int
+	w := NewContext(db, Config{
+		QueueName:     "test_queue",
+		RetrySchedule: []time.Duration{0},
+		Transactional: true,
+	}, func(ctx context.Context, payload []byte) error {
+		var page int
+		if _, err := LoadCheckpoint(ctx, &page); err != nil {
+			return err
+		}
+		resumed = append(resumed, page)
+
+		// The job's transaction holds the write lock once it wrote: the
+		// checkpoint must be saved within it rather than wait for it
+		tx, _ := TxFromContext(ctx)
+		if _, err := tx.Exec(`INSERT INTO pages (page) VALUES (?)`, len(resumed)); err != nil {
+			return err
+		}
+		start := time.Now()
+		if err := SaveCheckpoint(ctx, len(resumed)); err != nil {
+			return fmt.Errorf("checkpoint after %v: %w", time.Since(start), err)
+		}
+		if len(resumed) == 1 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	defer w.Close()
+
+	id, err := w.Enqueue("export")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		w.process(context.Background(), w.claim())
+	}
+
+	// The failed attempt's checkpoint was rolled back along with its page
+	if fmt.Sprint(resumed) != "[0 0]" {
+		t.Errorf("Expected both attempts to start over, got %v", resumed)
+	}
+	q := queue.New(db, "test_queue")
+	item, err := q.Get(id)
+	if err != nil || item.Status != queue.StatusCompleted {
+		t.Fatalf("Expected item %d to be completed, got %+v, %v", id, item, err)
+	}
+	var page int
+	if found, err := q.Checkpoint(id, &page); err != nil || !found || page != 2 {
+		t.Errorf("Expected the checkpoint of the second attempt, got %d, %v, %v", page, found, err)
+	}
+	var pages string
+	if err := db.QueryRow(`SELECT group_concat(page) FROM pages`).Scan(&pages); err != nil || pages != "2" {
+		t.Errorf("Expected only the page of the second attempt, got %q, %v", pages, err)
+	}
+}
+
+func TestTransactionalAckFailure(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := db.Exec(`CREATE TABLE charges (id INTEGER PRIMARY KEY, amount INTEGER)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	// Completing items fails until the trigger is dropped
+	_, err := db.Exec(`
+		CREATE TRIGGER test_fail_ack BEFORE UPDATE OF status ON queue_items WHEN NEW.status = 'completed'
+		BEGIN SELECT RAISE(ABORT, 'ack failed'); END
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+
+	w := NewContext(db, Config{
+		QueueName:     "test_queue",
+		RetrySchedule: []time.Duration{time.Hour},
+		Transactional: true,
+	}, func(ctx context.Context, payload []byte) error {
+		tx, _ := TxFromContext(ctx)
+		_, err := tx.Exec(`INSERT INTO charges (amount) VALUES (100)`)
+		return err
+	})
+	defer w.Close()
+
+	id, err := w.Enqueue("charge")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	w.process(context.Background(), w.claim())
+
+	// The attempt failed with its ack: the item is retried, not left processing
+	item, err := queue.New(db, "test_queue").Get(id)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if item.Status != queue.StatusPending || !item.ScheduledAt.After(time.Now()) {
+		t.Errorf("Expected the item to be rescheduled, got %s at %v", item.Status, item.ScheduledAt)
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM charges`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count charges: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected the charge to be rolled back, got %d", count)
+	}
+}
+
+func TestGroupWorker(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var (
+		calls  atomic.Int32
+		sizes  = make(chan int, 10)
+		failed atomic.Bool
+	)
+	w := NewGroup(db, Config{
+		QueueName:     "test_queue",
+		Interval:      10 * time.Millisecond,
+		RetrySchedule: []time.Duration{0},
+	}, func(ctx context.Context, items []*queue.QueueItem) error {
+		calls.Add(1)
+		sizes <- len(items)
+		// The first attempt fails, and the whole group is retried
+		if !failed.Swap(true) {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	defer w.Close()
+
+	q := queue.New(db, "test_queue")
+	defer q.Close()
+
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		id, err := q.EnqueueWithOptions(i, queue.EnqueueOptions{GroupKey: "invoice-1"})
+		if err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Start(ctx)
+		close(done)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for calls.Load() < 2 {
+		select {
+		case <-deadline:
+			t.Fatalf("Timed out after %d calls", calls.Load())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	<-done
+
+	for i := 0; i < 2; i++ {
+		if size := <-sizes; size != 3 {
+			t.Errorf("Call %d: expected the group of 3 items, got %d", i+1, size)
+		}
+	}
+	for _, id := range ids {
+		item, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("Failed to get item: %v", err)
+		}
+		if item.Status != queue.StatusCompleted || item.Attempts != 2 {
+			t.Errorf("Expected item %d to be completed on its second attempt, got %s after %d", id, item.Status, item.Attempts)
+		}
+	}
+}
+
+type requestIDKey struct{}
+
+func TestContextPropagation(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	propagators := []queue.Propagator{{
+		Key: "request_id",
+		Extract: func(ctx context.Context) (string, bool) {
+			id, ok := ctx.Value(requestIDKey{}).(string)
+			return id, ok
+		},
+		Inject: func(ctx context.Context, value string) context.Context {
+			return context.WithValue(ctx, requestIDKey{}, value)
+		},
+	}}
+
+	seen := make(chan string, 1)
+	w := NewContext(db, Config{
+		QueueName:   "test_queue",
+		Interval:    10 * time.Millisecond,
+		Propagators: propagators,
+	}, func(ctx context.Context, payload []byte) error {
+		id, _ := ctx.Value(requestIDKey{}).(string)
+		seen <- id
+		return nil
+	})
+	defer w.Close()
+
+	reqCtx := context.WithValue(context.Background(), requestIDKey{}, "req-42")
+	id, err := w.EnqueueContext(reqCtx, "job", queue.EnqueueOptions{})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx)
+
+	select {
+	case got := <-seen:
+		if got != "req-42" {
+			t.Errorf("Expected the handler to see request ID req-42, got %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the handler")
+	}
+
+	item, err := queue.New(db, "test_queue").Get(id)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if item.Metadata["request_id"] != "req-42" {
+		t.Errorf("Expected the request ID in the metadata, got %v", item.Metadata)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	type progress struct {
+		Page int `json:"page"`
+	}
+
+	resumed := make(chan int, 2)
+	w := NewContext(db, Config{
+		QueueName:     "test_queue",
+		Interval:      10 * time.Millisecond,
+		RetrySchedule: []time.Duration{0},
+	}, func(ctx context.Context, payload []byte) error {
+		var p progress
+		if _, err := LoadCheckpoint(ctx, &p); err != nil {
+			return err
+		}
+		resumed <- p.Page
+		if p.Page == 0 {
+			// Crash halfway through the export
+			if err := SaveCheckpoint(ctx, progress{Page: 7}); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		}
+		return nil
+	})
+	defer w.Close()
+
+	id, err := w.Enqueue("export")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx)
+
+	for i, want := range []int{0, 7} {
+		select {
+		case page := <-resumed:
+			if page != want {
+				t.Errorf("Attempt %d: expected to resume from page %d, got %d", i+1, want, page)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for attempt %d", i+1)
+		}
+	}
+
+	var p progress
+	found, err := queue.New(db, "test_queue").Checkpoint(id, &p)
+	if err != nil || !found || p.Page != 7 {
+		t.Errorf("Expected the stored checkpoint at page 7, got %+v, %v, %v", p, found, err)
+	}
+}
+
+func TestManualClock(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	clock := queue.NewManualClock(time.Now())
+	calls := make(chan time.Time, 2)
+	w := NewContext(db, Config{
+		QueueName: "test_queue",
+		Interval:  time.Second,
+		Clock:     clock,
+	}, func(ctx context.Context, payload []byte) error {
+		calls <- clock.Now()
+		if len(calls) == 1 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	defer w.Close()
+
+	if _, err := w.Enqueue("job"); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx)
+
+	// Advance virtual time one poll interval at a time until the item is
+	// retried after the 2s backoff of its first failure
+	deadline := time.After(5 * time.Second)
+	for len(calls) < 2 {
+		select {
+		case <-deadline:
+			t.Fatalf("Timed out after %d calls", len(calls))
+		case <-time.After(5 * time.Millisecond):
+			clock.Advance(time.Second)
+		}
+	}
+
+	first, second := <-calls, <-calls
+	if backoff := second.Sub(first); backoff < 2*time.Second {
+		t.Errorf("Expected the retry at least 2s of virtual time later, got %v", backoff)
+	}
+}
+
+func TestSlowItems(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	clock := queue.NewManualClock(time.Now())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	events := make(chan Event, 10)
+	w := NewContext(db, Config{
+		QueueName:        "test_queue",
+		Interval:         time.Second,
+		Clock:            clock,
+		ExpectedDuration: time.Minute,
+		OnEvent:          func(e Event) { events <- e },
+	}, func(ctx context.Context, payload []byte) error {
+		close(started)
+		<-release
+		return nil
+	})
+	defer w.Close()
+
+	id, err := w.Enqueue("job")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx)
+
+	deadline := time.After(5 * time.Second)
+	for waiting := true; waiting; {
+		select {
+		case <-started:
+			waiting = false
+		case <-deadline:
+			t.Fatal("Timed out waiting for the handler")
+		case <-time.After(5 * time.Millisecond):
+			clock.Advance(time.Second)
+		}
+	}
+
+	// Within the expected duration, nothing is reported
+	clock.Advance(2 * time.Minute)
+	select {
+	case e := <-events:
+		t.Fatalf("Expected no event yet, got %+v", e)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(time.Minute)
+	select {
+	case e := <-events:
+		if e.Type != EventSlow || e.ItemID != id {
+			t.Errorf("Expected a slow event for item %d, got %+v", id, e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the slow event")
+	}
+	close(release)
+}
+
+func TestSlowDetectorLearns(t *testing.T) {
+	d := newSlowDetector(0, 0)
+	for i := 0; i < slowMinSamples-1; i++ {
+		d.observe(time.Second)
+	}
+	if _, slow := d.threshold(); slow != 0 {
+		t.Errorf("Expected no threshold before %d samples, got %v", slowMinSamples, slow)
+	}
+
+	d.observe(time.Second)
+	if expected, slow := d.threshold(); expected != time.Second || slow != 3*time.Second {
+		t.Errorf("Expected 1s and a 3s threshold, got %v and %v", expected, slow)
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	w := NewContext(db, Config{
+		QueueName: "test_queue",
+		Interval:  10 * time.Millisecond,
+	}, func(ctx context.Context, payload []byte) error {
+		close(started)
+		<-release
+		return nil
+	})
+	defer w.Close()
+
+	id, err := w.Enqueue("job")
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx)
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the handler")
+	}
+
+	server := httptest.NewServer(DebugHandler(w))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/debug/jobs")
+	if err != nil {
+		t.Fatalf("Failed to get jobs: %v", err)
+	}
+	var statuses []workerStatus
+	err = json.NewDecoder(resp.Body).Decode(&statuses)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to decode jobs: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Queue != "test_queue" || len(statuses[0].Jobs) != 1 || statuses[0].Jobs[0].ID != id {
+		t.Fatalf("Expected item %d in flight, got %+v", id, statuses)
+	}
+	if statuses[0].Jobs[0].Attempt != 1 || statuses[0].Jobs[0].StartedAt.IsZero() {
+		t.Errorf("Expected the attempt and start time, got %+v", statuses[0].Jobs[0])
+	}
+
+	resp, err = http.Get(server.URL + "/debug/stacks")
+	if err != nil {
+		t.Fatalf("Failed to get stacks: %v", err)
+	}
+	stacks, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(stacks), `"laqueue.item":"`+strconv.FormatInt(id, 10)+`"`) {
+		t.Errorf("Expected the handler goroutine to be labeled with item %d, got:\n%s", id, stacks)
+	}
+
+	close(release)
+	deadline := time.After(5 * time.Second)
+	for len(w.InFlight()) > 0 {
+		select {
+		case <-deadline:
+			t.Fatal("Timed out waiting for the item to finish")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestQueuePattern(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	enqueue := func(name string) {
+		t.Helper()
+		if _, err := queue.New(db, name).Enqueue(name); err != nil {
+			t.Fatalf("Failed to enqueue item: %v", err)
+		}
+	}
+	enqueue("emails.tenant1")
+	enqueue("emails.tenant2")
+	enqueue("sms.tenant1")
+
+	processed := make(chan string, 10)
+	w := NewContext(db, Config{
+		QueuePattern: "emails.*",
+		Interval:     10 * time.Millisecond,
+	}, func(ctx context.Context, payload []byte) error {
+		name, _ := QueueNameFromContext(ctx)
+		processed <- name
+		return nil
+	})
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Queues added while the worker runs are picked up too
+	enqueue("emails.tenant3")
+
+	seen := make(map[string]bool)
+	for len(seen) < 3 {
+		select {
+		case name := <-processed:
+			seen[name] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out with items of %v processed", seen)
+		}
+	}
+	if !seen["emails.tenant1"] || !seen["emails.tenant2"] || !seen["emails.tenant3"] {
+		t.Errorf("Expected items of the matching queues, got %v", seen)
+	}
+
+	item, err := queue.New(db, "emails.tenant3").Dequeue()
+	if err != nil || item != nil {
+		t.Errorf("Expected the items to be completed, got %+v, %v", item, err)
+	}
+	if size, err := queue.New(db, "sms.tenant1").Size(); err != nil || size != 1 {
+		t.Errorf("Expected the other queue to be left alone, got %d, %v", size, err)
+	}
+}
+
+func TestUnsupportedContentType(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	q := queue.New(db, "test_queue")
+	binaryID, err := q.EnqueueWithOptions([]byte{0x08, 0x01}, queue.EnqueueOptions{ContentType: queue.ContentTypeProtobuf})
+	if err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+	if _, err := q.Enqueue("json"); err != nil {
+		t.Fatalf("Failed to enqueue item: %v", err)
+	}
+
+	processed := make(chan string, 10)
+	w := NewContext(db, Config{
+		QueueName:    "test_queue",
+		Interval:     10 * time.Millisecond,
+		ContentTypes: []string{queue.ContentTypeJSON},
+	}, func(ctx context.Context, payload []byte) error {
+		contentType, _ := ContentTypeFromContext(ctx)
+		processed <- contentType
+		return nil
+	})
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case contentType := <-processed:
+		if contentType != queue.ContentTypeJSON {
+			t.Fatalf("Expected only JSON items to be handled, got %s", contentType)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the JSON item")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		item, err := q.Get(binaryID)
+		if err != nil {
+			t.Fatalf("Failed to get item: %v", err)
+		}
+		if item.Status == queue.StatusFailed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the protobuf item to fail, got status %s", item.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	attempts, err := q.AttemptStats(binaryID)
+	if err != nil || len(attempts) != 1 || !strings.Contains(attempts[0].Error, "unsupported content type") {
+		t.Errorf("Expected the rejection to be recorded, got %+v, %v", attempts, err)
+	}
+	if len(processed) != 0 {
+		t.Errorf("Expected the handler not to run for the protobuf item")
+	}
+}